@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"runtime"
 	"syscall"
 	"time"
 
@@ -16,7 +15,6 @@ import (
 	"github.com/smakimka/mtrcscollector/internal/agent/config"
 	"github.com/smakimka/mtrcscollector/internal/auth"
 	"github.com/smakimka/mtrcscollector/internal/logger"
-	"github.com/smakimka/mtrcscollector/internal/model"
 	"github.com/smakimka/mtrcscollector/internal/storage"
 	pb "github.com/smakimka/mtrcscollector/protobuf/server"
 )
@@ -29,6 +27,16 @@ var (
 )
 
 func main() {
+	cfg := config.NewConfig()
+	if cfg.PrintConfig {
+		data, err := cfg.Dump()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
 	if buildVersion == "" {
 		buildVersion = na
 	}
@@ -42,8 +50,13 @@ func main() {
 	fmt.Printf("Build date: %s\n", buildDate)
 	fmt.Printf("Build commit: %s\n", buildCommit)
 
-	cfg := config.NewConfig()
-	logger.SetLevel(logger.Info)
+	cfg.BuildVersion = buildVersion
+
+	lvl, err := logger.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		panic(err)
+	}
+	logger.SetLevel(lvl)
 
 	if cfg.Key != "" {
 		auth.Init(cfg.Key)
@@ -77,55 +90,102 @@ func main() {
 	}
 
 	ctx := context.Background()
-	// инициализация метрик
-	m := runtime.MemStats{}
-	runtime.ReadMemStats(&m)
-	agent.UpdateMetrics(ctx, &m, s)
-	s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "LastPollCount", Value: 0})
+	agent.InitMetrics(ctx, s)
 
-	run(ctx, cfg, s, client, grpcClient)
+	collectors, err := agent.DefaultRegistry(cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	run(ctx, cfg, s, collectors, client, grpcClient)
 }
 
-func run(ctx context.Context, cfg *config.Config, s storage.Storage, client *resty.Client, grpcClient pb.MetricsCollectorClient) {
-	pollTicker := time.NewTicker(cfg.PollInterval)
-	defer pollTicker.Stop()
+func run(ctx context.Context, cfg *config.Config, s storage.Storage, collectors *agent.Registry, client *resty.Client, grpcClient pb.MetricsCollectorClient) {
+	collectCtx, stopCollectors := context.WithCancel(ctx)
+	defer func() { stopCollectors() }()
+
 	reportTicker := time.NewTicker(cfg.ReportInterval)
 	defer reportTicker.Stop()
 
-	jobs := make(chan model.MetricsData, cfg.RateLimit)
+	jobs := make(chan agent.Batch, cfg.RateLimit)
+	sender := agent.NewSender()
 	errs := make(chan error)
 
-	for i := 0; i < cfg.RateLimit; i++ {
-		if client == nil {
-			go agent.GRPCWorker(ctx, *cfg, grpcClient, i+1, jobs, errs)
-		} else {
-			go agent.Worker(ctx, *cfg, client, i+1, jobs, errs)
-		}
+	collectors.Run(collectCtx, cfg, s, errs)
+	if err := agent.ServePush(ctx, cfg, s, errs); err != nil {
+		panic(err)
 	}
 
+	stopWorkers := startWorkers(ctx, cfg, client, grpcClient, jobs, errs)
+
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	go func() {
-		for range c {
-			pollTicker.Stop()
-			reportTicker.Stop()
 
-			fmt.Println("Just a second, sending data...")
-			agent.SendMetrics(context.Background(), cfg, s, jobs, errs)
-			fmt.Println("Done!")
-			os.Exit(0)
-		}
-	}()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 
 	for {
 		select {
-		case <-pollTicker.C:
-			go agent.CollectMetrics(ctx, s)
-			go agent.CollectPSutilMetrics(ctx, s, errs)
 		case <-reportTicker.C:
-			go agent.SendMetrics(ctx, cfg, s, jobs, errs)
+			go agent.SendMetrics(ctx, cfg, sender, s, jobs, errs)
 		case err := <-errs:
 			fmt.Println(err)
+		case <-hup:
+			next, changes, err := cfg.Reload()
+			if err != nil {
+				logger.Log.Err(err).Msg("error reloading config, keeping current settings")
+				continue
+			}
+
+			// уровень уже проверен при перечитывании
+			lvl, _ := logger.ParseLevel(next.LogLevel)
+			logger.SetLevel(lvl)
+			auth.Init(next.Key)
+
+			if changes.Has("report_interval") {
+				reportTicker.Reset(next.ReportInterval)
+			}
+			if changes.Has("poll_interval") {
+				stopCollectors()
+				collectCtx, stopCollectors = context.WithCancel(ctx)
+				collectors.Run(collectCtx, next, s, errs)
+			}
+			// отправщики получают копию конфига, поэтому перезапускаются и при смене периода отправки
+			if changes.Has("rate_limit") || changes.Has("report_interval") {
+				close(stopWorkers)
+				stopWorkers = startWorkers(ctx, next, client, grpcClient, jobs, errs)
+			}
+
+			changes.Log()
+			cfg = next
+		case <-c:
+			stopCollectors()
+			reportTicker.Stop()
+
+			// основной цикл больше не читает ошибки
+			go func() {
+				for err := range errs {
+					fmt.Println(err)
+				}
+			}()
+
+			fmt.Println("Just a second, sending data...")
+			agent.SendMetrics(context.Background(), cfg, sender, s, jobs, errs)
+			fmt.Println("Done!")
+			os.Exit(0)
+		}
+	}
+}
+
+// Запустить cfg.RateLimit отправщиков, они останавливаются закрытием возвращенного канала
+func startWorkers(ctx context.Context, cfg *config.Config, client *resty.Client, grpcClient pb.MetricsCollectorClient, jobs <-chan agent.Batch, errs chan<- error) chan struct{} {
+	stop := make(chan struct{})
+	for i := 0; i < cfg.RateLimit; i++ {
+		if client == nil {
+			go agent.GRPCWorker(ctx, *cfg, grpcClient, i+1, jobs, stop, errs)
+		} else {
+			go agent.Worker(ctx, *cfg, client, i+1, jobs, stop, errs)
 		}
 	}
+	return stop
 }
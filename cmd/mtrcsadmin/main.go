@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/smakimka/mtrcscollector/internal/admin"
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+const usage = `Usage: mtrcsadmin <command> [flags]
+
+Commands:
+  copy    copy all metrics from one storage to another and verify the result
+  export  dump a storage to a portable export file
+  import  restore a storage from an export file
+  verify  check that two storages hold the same metrics
+
+A storage is postgres://... (or postgresql://...) for a database,
+file:path or just a path for a server snapshot file.
+Run "mtrcsadmin <command> -h" for command flags.
+`
+
+var errUsage = errors.New("bad usage")
+
+func main() {
+	logger.SetLevel(logger.Info)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, errUsage) && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		cancel()
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return errUsage
+	}
+
+	commands := map[string]func(context.Context, []string, io.Writer) error{
+		"copy":   copyCmd,
+		"export": exportCmd,
+		"import": importCmd,
+		"verify": verifyCmd,
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], usage)
+		return errUsage
+	}
+
+	return cmd(ctx, args[1:], out)
+}
+
+// Флаги снимка, который пишется при копировании в файл
+func snapshotFlags(fs *flag.FlagSet) func() (storage.SnapshotOptions, error) {
+	format := fs.String("snapshot-format", string(storage.SnapshotJSON), "format of a written snapshot file: json or binary")
+	keep := fs.Int("snapshot-keep", storage.DefaultSnapshotKeep, "how many snapshot files to keep, including the current one")
+
+	return func() (storage.SnapshotOptions, error) {
+		f, err := storage.ParseSnapshotFormat(*format)
+		if err != nil {
+			return storage.SnapshotOptions{}, err
+		}
+		if *keep < 1 {
+			return storage.SnapshotOptions{}, errors.New("snapshot-keep must be positive")
+		}
+		return storage.SnapshotOptions{Keep: *keep, Format: f}, nil
+	}
+}
+
+func parse(fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, name := range required {
+		if fs.Lookup(name).Value.String() == "" {
+			return fmt.Errorf("flag -%s is required", name)
+		}
+	}
+	return nil
+}
+
+func copyCmd(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("copy", flag.ContinueOnError)
+	from := fs.String("from", "", "source storage")
+	to := fs.String("to", "", "destination storage")
+	verify := fs.Bool("verify", true, "check that the destination holds the same metrics afterwards")
+	opts := snapshotFlags(fs)
+	if err := parse(fs, args, "from", "to"); err != nil {
+		return err
+	}
+	snapshotOpts, err := opts()
+	if err != nil {
+		return err
+	}
+
+	src, err := admin.Open(ctx, *from, false, storage.DefaultSnapshotOptions)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", *from, err)
+	}
+	defer src.Close()
+	dst, err := admin.Open(ctx, *to, true, snapshotOpts)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", *to, err)
+	}
+
+	counts, err := admin.Copy(ctx, dst, src)
+	if err == nil && *verify {
+		_, _, err = admin.Verify(ctx, src, dst)
+	}
+	// снимок пишется при закрытии, поэтому ошибка закрытия тоже ошибка копирования
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Copied %s\n", counts)
+	return nil
+}
+
+func exportCmd(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	from := fs.String("from", "", "storage to export")
+	output := fs.String("o", "", "export file (- for stdout)")
+	if err := parse(fs, args, "from", "o"); err != nil {
+		return err
+	}
+
+	src, err := admin.Open(ctx, *from, false, storage.DefaultSnapshotOptions)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", *from, err)
+	}
+	defer src.Close()
+
+	metricsData, err := admin.ReadAll(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		return admin.WriteExport(out, metricsData)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err = admin.WriteExport(f, metricsData); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Exported %s to %s\n", admin.CountMetrics(metricsData), *output)
+	return nil
+}
+
+func importCmd(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	input := fs.String("i", "", "export file")
+	to := fs.String("to", "", "storage to restore")
+	opts := snapshotFlags(fs)
+	if err := parse(fs, args, "i", "to"); err != nil {
+		return err
+	}
+	snapshotOpts, err := opts()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	export, err := admin.ReadExport(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", *input, err)
+	}
+
+	dst, err := admin.Open(ctx, *to, true, snapshotOpts)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", *to, err)
+	}
+	err = admin.WriteAll(ctx, dst, export.Metrics)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Imported %s exported at %s\n", admin.CountMetrics(export.Metrics), export.Created.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+func verifyCmd(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	from := fs.String("from", "", "first storage")
+	to := fs.String("to", "", "second storage")
+	if err := parse(fs, args, "from", "to"); err != nil {
+		return err
+	}
+
+	a, err := admin.Open(ctx, *from, false, storage.DefaultSnapshotOptions)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", *from, err)
+	}
+	defer a.Close()
+	b, err := admin.Open(ctx, *to, false, storage.DefaultSnapshotOptions)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", *to, err)
+	}
+	defer b.Close()
+
+	counts, _, err := admin.Verify(ctx, a, b)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Storages match: %s\n", counts)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/admin"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "metrics.json")
+
+	s := storage.NewMemStorage()
+	value, delta := 1.5, int64(5)
+	require.NoError(t, s.UpdateMetrics(ctx, model.MetricsData{
+		{Name: "Alloc", Kind: model.Gauge, Value: &value},
+		{Name: "PollCount", Kind: model.Counter, Delta: &delta},
+	}))
+	require.NoError(t, s.Save(src))
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "copy to binary snapshot",
+			args: []string{"copy", "-from", src, "-to", "file:" + filepath.Join(dir, "copy.bin"), "-snapshot-format", "binary"},
+			want: "Copied 1 gauges, 1 counters\n",
+		},
+		{
+			name: "export",
+			args: []string{"export", "-from", filepath.Join(dir, "copy.bin"), "-o", filepath.Join(dir, "export.json")},
+			want: "Exported 1 gauges, 1 counters to " + filepath.Join(dir, "export.json") + "\n",
+		},
+		{
+			name: "import",
+			args: []string{"import", "-i", filepath.Join(dir, "export.json"), "-to", filepath.Join(dir, "imported.json")},
+			want: "Imported 1 gauges, 1 counters",
+		},
+		{
+			name: "verify",
+			args: []string{"verify", "-from", src, "-to", filepath.Join(dir, "imported.json")},
+			want: "Storages match: 1 gauges, 1 counters\n",
+		},
+		{
+			name:    "verify with missing metrics",
+			args:    []string{"verify", "-from", src, "-to", filepath.Join(dir, "empty.json")},
+			wantErr: admin.ErrMismatch,
+		},
+		{
+			name:    "unknown command",
+			args:    []string{"move"},
+			wantErr: errUsage,
+		},
+	}
+
+	// пустой снимок для проверки расхождений
+	require.NoError(t, storage.NewMemStorage().Save(filepath.Join(dir, "empty.json")))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(ctx, test.args, &out)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, out.String(), test.want)
+		})
+	}
+}
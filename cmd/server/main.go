@@ -14,10 +14,19 @@ import (
 
 	"github.com/smakimka/mtrcscollector/internal/auth"
 	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/server/agents"
+	"github.com/smakimka/mtrcscollector/internal/server/alerts"
+	"github.com/smakimka/mtrcscollector/internal/server/cache"
 	"github.com/smakimka/mtrcscollector/internal/server/config"
 	"github.com/smakimka/mtrcscollector/internal/server/grpc"
+	"github.com/smakimka/mtrcscollector/internal/server/janitor"
+	"github.com/smakimka/mtrcscollector/internal/server/limits"
+	"github.com/smakimka/mtrcscollector/internal/server/recording"
 	"github.com/smakimka/mtrcscollector/internal/server/router"
+	"github.com/smakimka/mtrcscollector/internal/server/selfmetrics"
 	"github.com/smakimka/mtrcscollector/internal/storage"
+	"github.com/smakimka/mtrcscollector/internal/storage/migrations"
+	"github.com/smakimka/mtrcscollector/internal/subnet"
 )
 
 var (
@@ -28,6 +37,16 @@ var (
 )
 
 func main() {
+	cfg := config.NewConfig()
+	if cfg.PrintConfig {
+		data, err := cfg.Dump()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+
 	if buildVersion == "" {
 		buildVersion = na
 	}
@@ -41,8 +60,6 @@ func main() {
 	fmt.Printf("Build date: %s\n", buildDate)
 	fmt.Printf("Build commit: %s\n", buildCommit)
 
-	cfg := config.NewConfig()
-
 	if cfg.CryptoKeyPath != "" {
 		if err := cfg.ReadCryptoKey(); err != nil {
 			panic(err)
@@ -61,18 +78,24 @@ func main() {
 }
 
 func run(cfg *config.Config) error {
-	logger.SetLevel(logger.Info)
+	lvl, err := logger.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	logger.SetLevel(lvl)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	var s storage.Storage
+	var saveTicker *time.Ticker
 	if cfg.DatabaseDSN == "" {
-		storage, err := initSyncStorage(cfg)
+		storage, ticker, err := initSyncStorage(cfg)
 		if err != nil {
 			return err
 		}
 		s = storage
+		saveTicker = ticker
 	} else {
 		pool, err := pgxpool.New(ctx, cfg.DatabaseDSN)
 		if err != nil {
@@ -80,16 +103,81 @@ func run(cfg *config.Config) error {
 		}
 		defer pool.Close()
 
+		if cfg.MigrateOnly {
+			return migrate(ctx, pool)
+		}
+		selfmetrics.Default.RegisterPGPool(pool)
+
 		s, err = storage.NewPGStorage(ctx, pool)
 		if err != nil {
 			return err
 		}
+		if cfg.PGCache {
+			s = cache.NewStorage(ctx, s, cache.NewPGBus(pool, cache.Channel))
+		}
 	}
 
 	if cfg.Key != "" {
 		auth.Init(cfg.Key)
 	}
 
+	trustedSubnet := subnet.NewTrusted(cfg.TrustedSubnet)
+	go watchReload(cfg, trustedSubnet, saveTicker)
+
+	if cfg.MaxSeries > 0 || cfg.MaxSeriesPerSource > 0 {
+		limitedStorage, err := limits.NewStorage(ctx, s, limits.Limits{
+			MaxSeries:          cfg.MaxSeries,
+			MaxSeriesPerSource: cfg.MaxSeriesPerSource,
+		})
+		if err != nil {
+			return err
+		}
+		selfmetrics.Default.CounterFunc("RejectedWrites", nil, limitedStorage.Rejected)
+		selfmetrics.Default.GaugeFunc("Series", nil, func() float64 { return float64(limitedStorage.Series()) })
+		s = limitedStorage
+	}
+
+	s = selfmetrics.NewStorage(s, selfmetrics.Default)
+
+	if cfg.MetricTTL > 0 {
+		j := janitor.NewJanitor(s, time.Duration(cfg.MetricTTL)*time.Second)
+		go j.Run(ctx)
+	}
+
+	if cfg.RecordingRulesPath != "" {
+		rules, err := recording.LoadRules(cfg.RecordingRulesPath)
+		if err != nil {
+			return err
+		}
+
+		recorder := recording.NewRecorder(s, rules)
+		go recorder.Run(ctx, time.Duration(cfg.RecordingInterval)*time.Second)
+	}
+
+	agentsRegistry := agents.NewRegistry(cfg.AgentStaleReports, time.Duration(cfg.AgentTTL)*time.Second, cfg.MaxAgents)
+	routerOpts := []router.Option{
+		router.WithAgents(agentsRegistry),
+		router.WithSelfMetrics(selfmetrics.Default),
+	}
+
+	if cfg.AlertRulesPath != "" {
+		rules, err := alerts.LoadRules(cfg.AlertRulesPath)
+		if err != nil {
+			return err
+		}
+
+		engine := alerts.NewEngine(s, rules)
+		if len(cfg.AlertWebhooks) > 0 {
+			engine.AddNotifier(alerts.NewWebhookNotifier(
+				cfg.AlertWebhooks,
+				time.Duration(cfg.AlertGroupWait)*time.Second,
+				time.Duration(cfg.AlertDedupWindow)*time.Second,
+			))
+		}
+		go engine.Run(ctx, time.Duration(cfg.AlertInterval)*time.Second)
+		routerOpts = append(routerOpts, router.WithAlerts(engine))
+	}
+
 	if cfg.StartAsGRPC {
 		listen, err := net.Listen("tcp", cfg.Addr)
 		if err != nil {
@@ -97,28 +185,64 @@ func run(cfg *config.Config) error {
 		}
 
 		logger.Log.Info().Msg(fmt.Sprintf("Running server on %s", cfg.Addr))
-		server := grpc.NewServer(cfg, s)
+		server := grpc.NewServer(cfg, s, grpc.WithAgents(agentsRegistry), grpc.WithTrustedSubnet(trustedSubnet))
 		if err := server.Serve(listen); err != nil {
 			return err
 		}
 	}
 
 	logger.Log.Info().Msg(fmt.Sprintf("Running server on %s", cfg.Addr))
-	return http.ListenAndServe(cfg.Addr, router.GetRouter(s, cfg.CryptoKey, cfg.TrustedSubnet))
+	return http.ListenAndServe(cfg.Addr, router.GetRouter(s, cfg.CryptoKey, trustedSubnet, routerOpts...))
+}
+
+// Применить миграции БД и ничего больше не запускать
+func migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	m, err := migrations.New(pool)
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Applied %d migrations, schema version is %d\n", len(applied), m.Latest())
+	return nil
 }
 
-func initSyncStorage(cfg *config.Config) (storage.SyncStorage, error) {
+func initSyncStorage(cfg *config.Config) (storage.SyncStorage, *time.Ticker, error) {
 	var s storage.SyncStorage
-	if cfg.StoreInterval == 0 {
+	var saveTicker *time.Ticker
+	if cfg.WALPath != "" {
+		// конфиг уже проверен
+		policy, _ := storage.ParseWALSync(cfg.WALSync)
+		walStorage, err := storage.NewWALMemStorage(cfg.WALPath, policy)
+		if err != nil {
+			return nil, nil, err
+		}
+		s = walStorage
+		saveTicker = time.NewTicker(time.Duration(cfg.StoreInterval) * time.Second)
+		go saveMetrics(s, cfg, saveTicker)
+	} else if cfg.StoreInterval == 0 {
 		s = storage.NewSyncMemStorage(cfg.FileStoragePath)
 	} else {
-		s = storage.NewMemStorage()
-		go saveMetrics(s, cfg)
+		if cfg.MemShards > 0 {
+			s = storage.NewShardedMemStorage(cfg.MemShards)
+		} else {
+			s = storage.NewMemStorage()
+		}
+		saveTicker = time.NewTicker(time.Duration(cfg.StoreInterval) * time.Second)
+		go saveMetrics(s, cfg, saveTicker)
 	}
 
+	// формат уже проверен
+	format, _ := storage.ParseSnapshotFormat(cfg.SnapshotFormat)
+	s.SetSnapshotOptions(storage.SnapshotOptions{Keep: cfg.SnapshotKeep, Format: format})
+
 	if cfg.Restore {
 		if err := s.Restore(cfg.FileStoragePath); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -127,19 +251,47 @@ func initSyncStorage(cfg *config.Config) (storage.SyncStorage, error) {
 	go func() {
 		for range c {
 			fmt.Println("Just a second, saving data...")
-			s.Save(cfg.FileStoragePath)
+			selfmetrics.Default.Save(s, cfg.FileStoragePath)
 			fmt.Println("Done!")
 			os.Exit(0)
 		}
 	}()
 
-	return s, nil
+	return s, saveTicker, nil
 }
 
-func saveMetrics(s storage.SyncStorage, cfg *config.Config) {
-	saveTicker := time.NewTicker(time.Duration(cfg.StoreInterval) * time.Second)
+// Перечитывать конфиг по SIGHUP и применять изменения, не перезапуская сервер
+func watchReload(cfg *config.Config, trustedSubnet *subnet.Trusted, saveTicker *time.Ticker) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	for range c {
+		next, changes, err := cfg.Reload()
+		if err != nil {
+			logger.Log.Err(err).Msg("error reloading config, keeping current settings")
+			continue
+		}
+
+		// уровень уже проверен при перечитывании
+		lvl, _ := logger.ParseLevel(next.LogLevel)
+		logger.SetLevel(lvl)
+		auth.Init(next.Key)
+		trustedSubnet.Set(next.TrustedSubnet)
+		if saveTicker != nil && changes.Has("store_interval") {
+			saveTicker.Reset(time.Duration(next.StoreInterval) * time.Second)
+		}
+
+		changes.Log()
+		cfg = next
+	}
+}
 
+func saveMetrics(s storage.SyncStorage, cfg *config.Config, saveTicker *time.Ticker) {
 	for range saveTicker.C {
-		go s.Save(cfg.FileStoragePath)
+		go func() {
+			if err := selfmetrics.Default.Save(s, cfg.FileStoragePath); err != nil {
+				logger.Log.Err(err).Msg("error saving metrics")
+			}
+		}()
 	}
 }
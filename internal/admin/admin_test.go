@@ -0,0 +1,139 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func gauge(name string, value float64) model.MetricData {
+	return model.MetricData{Name: name, Kind: model.Gauge, Value: &value}
+}
+
+func counter(name string, value int64) model.MetricData {
+	return model.MetricData{Name: name, Kind: model.Counter, Delta: &value}
+}
+
+func TestWriteAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing model.MetricsData
+		write    model.MetricsData
+		want     model.MetricsData
+		wantErr  error
+	}{
+		{
+			name:  "empty storage",
+			write: model.MetricsData{gauge("Alloc", 1.5), counter("PollCount", 5), counter("Zero", 0)},
+			want:  model.MetricsData{gauge("Alloc", 1.5), counter("PollCount", 5), counter("Zero", 0)},
+		},
+		{
+			name:     "counters are set, not added",
+			existing: model.MetricsData{gauge("Alloc", 1), counter("PollCount", 7), counter("Other", 1)},
+			write:    model.MetricsData{gauge("Alloc", 2), counter("PollCount", 5)},
+			want:     model.MetricsData{gauge("Alloc", 2), counter("Other", 1), counter("PollCount", 5)},
+		},
+		{
+			name:  "last duplicate wins",
+			write: model.MetricsData{counter("PollCount", 5), counter("PollCount", 3)},
+			want:  model.MetricsData{counter("PollCount", 3)},
+		},
+		{
+			name:    "missing value",
+			write:   model.MetricsData{{Name: "Alloc", Kind: model.Gauge}},
+			wantErr: model.ErrMissingFields,
+		},
+		{
+			name:    "unknown kind",
+			write:   model.MetricsData{{Name: "Alloc", Kind: "histogram"}},
+			wantErr: model.ErrWrongMetricKind,
+		},
+	}
+
+	ctx := context.Background()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := storage.NewMemStorage()
+			if test.existing != nil {
+				require.NoError(t, s.UpdateMetrics(ctx, test.existing))
+			}
+
+			err := WriteAll(ctx, s, test.write)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			// повторная запись ничего не меняет
+			require.NoError(t, WriteAll(ctx, s, test.write))
+			got, err := ReadAll(ctx, s)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestCopyAndVerify(t *testing.T) {
+	ctx := context.Background()
+	src := storage.NewMemStorage()
+	require.NoError(t, src.UpdateMetrics(ctx, model.MetricsData{gauge("Alloc", 1.5), gauge("Free", 2), counter("PollCount", 5)}))
+
+	dst := storage.NewShardedMemStorage(4)
+	require.NoError(t, dst.UpdateMetrics(ctx, model.MetricsData{counter("PollCount", 2)}))
+
+	counts, err := Copy(ctx, dst, src)
+	require.NoError(t, err)
+	assert.Equal(t, Counts{Gauges: 2, Counters: 1}, counts)
+
+	srcCounts, dstCounts, err := Verify(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Equal(t, srcCounts, dstCounts)
+
+	require.NoError(t, dst.UpdateMetrics(ctx, model.MetricsData{gauge("Alloc", 3), counter("Extra", 1)}))
+	_, _, err = Verify(ctx, src, dst)
+	assert.ErrorIs(t, err, ErrMismatch)
+	assert.ErrorContains(t, err, "gauge Alloc is 1.5 vs 3")
+	assert.ErrorContains(t, err, "counter Extra is missing in the first storage")
+}
+
+func TestExport(t *testing.T) {
+	metricsData := model.MetricsData{gauge("Alloc", 1.5), counter("PollCount", 5)}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteExport(&buf, metricsData))
+	export, err := ReadExport(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, metricsData, export.Metrics)
+	assert.False(t, export.Created.IsZero())
+
+	_, err = ReadExport(bytes.NewBufferString(`{"version": 100, "metrics": []}`))
+	assert.ErrorIs(t, err, ErrUnsupportedExportVersion)
+}
+
+func TestOpenSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.bin")
+
+	_, err := Open(ctx, path, false, storage.DefaultSnapshotOptions)
+	require.Error(t, err, "missing snapshot can't be read")
+
+	b, err := Open(ctx, "file:"+path, true, storage.SnapshotOptions{Keep: 1, Format: storage.SnapshotBinary})
+	require.NoError(t, err)
+	require.NoError(t, WriteAll(ctx, b, model.MetricsData{gauge("Alloc", 1.5)}))
+	require.NoError(t, b.Close())
+
+	b, err = Open(ctx, path, false, storage.DefaultSnapshotOptions)
+	require.NoError(t, err)
+	defer b.Close()
+	got, err := ReadAll(ctx, b)
+	require.NoError(t, err)
+	assert.Equal(t, model.MetricsData{gauge("Alloc", 1.5)}, got)
+}
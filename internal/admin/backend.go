@@ -0,0 +1,72 @@
+// Модуль admin переносит метрики между хранилищами для утилиты mtrcsadmin
+package admin
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// Backend Открытое хранилище. Close сохраняет изменения и освобождает ресурсы.
+type Backend struct {
+	storage.Storage
+	close func() error
+}
+
+func (b *Backend) Close() error {
+	return b.close()
+}
+
+// Открыть хранилище по адресу: postgres://... или postgresql://... - БД (схема приводится к последней версии),
+// file:path или просто путь - снимок, как у сервера в памяти. Снимок открытого на запись хранилища
+// пишется в Close с настройками opts, снимка еще может не быть, для чтения он должен существовать
+func Open(ctx context.Context, addr string, writable bool, opts storage.SnapshotOptions) (*Backend, error) {
+	if strings.HasPrefix(addr, "postgres://") || strings.HasPrefix(addr, "postgresql://") {
+		return openPG(ctx, addr)
+	}
+
+	return openSnapshot(strings.TrimPrefix(addr, "file:"), writable, opts)
+}
+
+func openPG(ctx context.Context, dsn string) (*Backend, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := storage.NewPGStorage(ctx, pool)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &Backend{Storage: s, close: func() error {
+		pool.Close()
+		return nil
+	}}, nil
+}
+
+func openSnapshot(filePath string, writable bool, opts storage.SnapshotOptions) (*Backend, error) {
+	if !writable {
+		// пустое хранилище вместо опечатки в пути молча скопировало бы ноль метрик
+		if _, err := os.Stat(filePath); err != nil {
+			return nil, err
+		}
+	}
+
+	s := storage.NewMemStorage()
+	s.SetSnapshotOptions(opts)
+	if err := s.Restore(filePath); err != nil {
+		return nil, err
+	}
+
+	b := &Backend{Storage: s, close: func() error { return nil }}
+	if writable {
+		b.close = func() error { return s.Save(filePath) }
+	}
+	return b, nil
+}
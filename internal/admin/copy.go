@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// Сколько расхождений показывать в ошибке сверки
+const maxReportedDiffs = 10
+
+var ErrMismatch = errors.New("storages differ")
+
+// Counts Число метрик каждого типа.
+type Counts struct {
+	Gauges   int
+	Counters int
+}
+
+func (c Counts) String() string {
+	return fmt.Sprintf("%d gauges, %d counters", c.Gauges, c.Counters)
+}
+
+// Все метрики хранилища, сначала gauge, потом counter, внутри типа по имени
+func ReadAll(ctx context.Context, s storage.Storage) (model.MetricsData, error) {
+	gauges, err := s.GetAllGaugeMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := s.GetAllCounterMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metricsData := make(model.MetricsData, 0, len(gauges)+len(counters))
+	for _, m := range gauges {
+		value := m.Value
+		metricsData = append(metricsData, model.MetricData{Name: m.Name, Kind: model.Gauge, Value: &value})
+	}
+	for _, m := range counters {
+		delta := m.Value
+		metricsData = append(metricsData, model.MetricData{Name: m.Name, Kind: model.Counter, Delta: &delta})
+	}
+	sort.SliceStable(metricsData, func(i, j int) bool {
+		if metricsData[i].Kind != metricsData[j].Kind {
+			return metricsData[i].Kind == model.Gauge
+		}
+		return metricsData[i].Name < metricsData[j].Name
+	})
+
+	return metricsData, nil
+}
+
+// Записать метрики так, чтобы в хранилище оказались ровно эти значения. Хранилище умеет только прибавлять
+// к counter, поэтому прибавляется разница с текущим значением и повторная запись ничего не меняет
+func WriteAll(ctx context.Context, s storage.Storage, metricsData model.MetricsData) error {
+	counters, err := s.GetAllCounterMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]int64, len(counters))
+	for _, m := range counters {
+		current[m.Name] = m.Value
+	}
+
+	batch := make(model.MetricsData, 0, len(metricsData))
+	for _, m := range metricsData {
+		switch m.Kind {
+		case model.Gauge:
+			if m.Value == nil {
+				return fmt.Errorf("%w: gauge %s has no value", model.ErrMissingFields, m.Name)
+			}
+			batch = append(batch, m)
+		case model.Counter:
+			if m.Delta == nil {
+				return fmt.Errorf("%w: counter %s has no value", model.ErrMissingFields, m.Name)
+			}
+			value, ok := current[m.Name]
+			delta := *m.Delta - value
+			// повторы в пачке складываются, так что следующий считается от этого значения
+			current[m.Name] = *m.Delta
+			if ok && delta == 0 {
+				continue
+			}
+			batch = append(batch, model.MetricData{Name: m.Name, Kind: model.Counter, Delta: &delta})
+		default:
+			return fmt.Errorf("%w: %s", model.ErrWrongMetricKind, m.Kind)
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	return s.UpdateMetrics(ctx, batch)
+}
+
+// Скопировать все метрики из src в dst, возвращает число скопированных
+func Copy(ctx context.Context, dst, src storage.Storage) (Counts, error) {
+	metricsData, err := ReadAll(ctx, src)
+	if err != nil {
+		return Counts{}, fmt.Errorf("reading source: %w", err)
+	}
+
+	if err = WriteAll(ctx, dst, metricsData); err != nil {
+		return Counts{}, fmt.Errorf("writing destination: %w", err)
+	}
+
+	return CountMetrics(metricsData), nil
+}
+
+// Сверить хранилища: число и значения метрик должны совпадать. Возвращает число метрик в каждом
+func Verify(ctx context.Context, a, b storage.Storage) (Counts, Counts, error) {
+	aData, err := ReadAll(ctx, a)
+	if err != nil {
+		return Counts{}, Counts{}, err
+	}
+	bData, err := ReadAll(ctx, b)
+	if err != nil {
+		return Counts{}, Counts{}, err
+	}
+
+	aCounts, bCounts := CountMetrics(aData), CountMetrics(bData)
+	if diffs := diff(aData, bData); len(diffs) > 0 {
+		if len(diffs) > maxReportedDiffs {
+			diffs = append(diffs[:maxReportedDiffs], fmt.Sprintf("and %d more", len(diffs)-maxReportedDiffs))
+		}
+		return aCounts, bCounts, fmt.Errorf("%w (%s vs %s): %s", ErrMismatch, aCounts, bCounts, strings.Join(diffs, "; "))
+	}
+
+	return aCounts, bCounts, nil
+}
+
+// Число метрик каждого типа в выгрузке
+func CountMetrics(metricsData model.MetricsData) Counts {
+	var c Counts
+	for _, m := range metricsData {
+		switch m.Kind {
+		case model.Gauge:
+			c.Gauges++
+		case model.Counter:
+			c.Counters++
+		}
+	}
+	return c
+}
+
+// Описания расхождений между выгрузками ReadAll
+func diff(a, b model.MetricsData) []string {
+	type key struct{ kind, name string }
+	values := func(metricsData model.MetricsData) map[key]string {
+		m := make(map[key]string, len(metricsData))
+		for _, data := range metricsData {
+			m[key{data.Kind, data.Name}] = formatValue(data)
+		}
+		return m
+	}
+
+	aValues, bValues := values(a), values(b)
+	var diffs []string
+	for _, data := range a {
+		k := key{data.Kind, data.Name}
+		bValue, ok := bValues[k]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("%s %s is missing in the second storage", k.kind, k.name))
+		case bValue != aValues[k]:
+			diffs = append(diffs, fmt.Sprintf("%s %s is %s vs %s", k.kind, k.name, aValues[k], bValue))
+		}
+	}
+	for _, data := range b {
+		if _, ok := aValues[key{data.Kind, data.Name}]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s %s is missing in the first storage", data.Kind, data.Name))
+		}
+	}
+	return diffs
+}
+
+func formatValue(m model.MetricData) string {
+	if m.Kind == model.Gauge {
+		return fmt.Sprint(*m.Value)
+	}
+	return fmt.Sprint(*m.Delta)
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+// Версия формата выгрузки
+const ExportVersion = 1
+
+var ErrUnsupportedExportVersion = errors.New("unsupported export version")
+
+// Export Переносимая выгрузка хранилища. Метрики в формате API сервера, у counter в delta полное значение.
+type Export struct {
+	Version int               `json:"version"`
+	Created time.Time         `json:"created"`
+	Metrics model.MetricsData `json:"metrics"`
+}
+
+// Записать выгрузку метрик в w
+func WriteExport(w io.Writer, metricsData model.MetricsData) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Export{Version: ExportVersion, Created: time.Now().UTC(), Metrics: metricsData})
+}
+
+// Прочитать выгрузку из r
+func ReadExport(r io.Reader) (Export, error) {
+	var export Export
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return Export{}, err
+	}
+	if export.Version != ExportVersion {
+		return Export{}, fmt.Errorf("%w: %d", ErrUnsupportedExportVersion, export.Version)
+	}
+
+	return export, nil
+}
@@ -11,11 +11,26 @@ import (
 	"github.com/smakimka/mtrcscollector/internal/storage"
 )
 
-func CollectMetrics(ctx context.Context, s storage.Storage) {
+// RuntimeCollector Сборщик метрик рантайма go.
+type RuntimeCollector struct{}
+
+func (c RuntimeCollector) Name() string {
+	return "runtime"
+}
+
+func (c RuntimeCollector) Collect(ctx context.Context, s storage.Storage) error {
 	m := runtime.MemStats{}
 	runtime.ReadMemStats(&m)
 
 	UpdateMetrics(ctx, &m, s)
+	return nil
+}
+
+// Первый сбор метрик рантайма при старте агента, до первого опроса сборщиков
+func InitMetrics(ctx context.Context, s storage.Storage) {
+	m := runtime.MemStats{}
+	runtime.ReadMemStats(&m)
+	UpdateMetrics(ctx, &m, s)
 }
 
 func UpdateMetrics(ctx context.Context, m *runtime.MemStats, s storage.Storage) {
@@ -51,14 +66,22 @@ func UpdateMetrics(ctx context.Context, m *runtime.MemStats, s storage.Storage)
 	s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
 }
 
-func CollectPSutilMetrics(ctx context.Context, s storage.Storage, errs chan<- error) {
+// PSutilCollector Сборщик системных метрик через gopsutil.
+type PSutilCollector struct{}
+
+func (c PSutilCollector) Name() string {
+	return "psutil"
+}
+
+func (c PSutilCollector) Collect(ctx context.Context, s storage.Storage) error {
 	v, err := mem.VirtualMemory()
 	if err != nil {
-		errs <- err
-		return
+		return err
 	}
 
 	s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "TotalMemory", Value: float64(v.Total)})
 	s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "FreeMemory", Value: float64(v.Free)})
-	s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "CPUutilization1", Value: float64(v.UsedPercent)})
+	s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "MemoryUsedPercent", Value: v.UsedPercent})
+
+	return nil
 }
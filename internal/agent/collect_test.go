@@ -62,3 +62,20 @@ func TestUpdateMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestInitMetricsSendsNoBookkeeping(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	jobs := make(chan Batch, 1)
+	sender := NewSender()
+	errs := make(chan error, 1)
+
+	InitMetrics(ctx, s)
+	SendMetrics(ctx, nil, sender, s, jobs, errs)
+
+	data := (<-jobs).Metrics
+	assert.Equal(t, int64(1), counterDelta(t, data, "PollCount"))
+	for _, metricData := range data {
+		assert.NotEqual(t, "LastPollCount", metricData.Name)
+	}
+}
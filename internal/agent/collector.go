@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+var ErrCollectorExists = errors.New("collector with this name is already registered")
+
+// Collector Источник метрик агента, складывает собранные значения в хранилище.
+type Collector interface {
+	Name() string
+	Collect(ctx context.Context, s storage.Storage) error
+}
+
+// Registry Набор зарегистрированных сборщиков метрик.
+type Registry struct {
+	collectors map[string]Collector
+	mutex      sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]Collector),
+	}
+}
+
+// Регистрация сборщика, имена сборщиков должны быть уникальны
+func (r *Registry) Register(c Collector) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.collectors[c.Name()]; ok {
+		return fmt.Errorf("%w: %s", ErrCollectorExists, c.Name())
+	}
+	r.collectors[c.Name()] = c
+
+	return nil
+}
+
+// Получение сборщика по имени
+func (r *Registry) Get(name string) (Collector, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	c, ok := r.collectors[name]
+	return c, ok
+}
+
+// Получение всех сборщиков в порядке имен
+func (r *Registry) Collectors() []Collector {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	collectors := make([]Collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].Name() < collectors[j].Name()
+	})
+
+	return collectors
+}
+
+// Запуск всех включенных сборщиков, каждый со своим периодом опроса, до отмены контекста
+func (r *Registry) Run(ctx context.Context, cfg *config.Config, s storage.Storage, errs chan<- error) {
+	for _, c := range r.Collectors() {
+		if !cfg.CollectorEnabled(c.Name()) {
+			logger.Log.Info().Msg(fmt.Sprintf("collector %s is disabled", c.Name()))
+			continue
+		}
+
+		go runCollector(ctx, c, cfg.CollectorPollInterval(c.Name()), s, errs)
+	}
+}
+
+func runCollector(ctx context.Context, c Collector, interval time.Duration, s storage.Storage, errs chan<- error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			start := time.Now()
+			err := c.Collect(ctx, s)
+			DefaultTelemetry.Collected(c.Name(), time.Since(start), err)
+			if err != nil {
+				errs <- fmt.Errorf("collector %s: %w", c.Name(), err)
+			}
+		}
+	}
+}
+
+// Реестр со всеми стандартными сборщиками агента и сборщиками, заданными в конфиге
+func DefaultRegistry(cfg *config.Config) (*Registry, error) {
+	r := NewRegistry()
+	r.Register(RuntimeCollector{})
+	r.Register(PSutilCollector{})
+	r.Register(CPUCollector{})
+	r.Register(LoadCollector{})
+	r.Register(NewDiskCollector())
+	r.Register(NewNetCollector())
+
+	if len(cfg.Processes) > 0 {
+		processCollector, err := NewProcessCollector(cfg.Processes)
+		if err != nil {
+			return nil, err
+		}
+		r.Register(processCollector)
+	}
+
+	for _, execCfg := range cfg.Exec {
+		execCollector, err := NewExecCollector(execCfg)
+		if err != nil {
+			return nil, err
+		}
+		if err = r.Register(execCollector); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
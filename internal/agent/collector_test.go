@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+type testCollector struct {
+	name  string
+	calls *atomic.Int64
+}
+
+func (c testCollector) Name() string {
+	return c.name
+}
+
+func (c testCollector) Collect(ctx context.Context, s storage.Storage) error {
+	c.calls.Add(1)
+	return s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: c.name, Value: 1})
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+
+	require.NoError(t, r.Register(testCollector{name: "b", calls: &atomic.Int64{}}))
+	require.NoError(t, r.Register(testCollector{name: "a", calls: &atomic.Int64{}}))
+	assert.ErrorIs(t, r.Register(testCollector{name: "a", calls: &atomic.Int64{}}), ErrCollectorExists)
+
+	_, ok := r.Get("a")
+	assert.True(t, ok)
+	_, ok = r.Get("c")
+	assert.False(t, ok)
+
+	names := []string{}
+	for _, c := range r.Collectors() {
+		names = append(names, c.Name())
+	}
+	assert.Equal(t, []string{"a", "b"}, names)
+}
+
+func TestRegistryRun(t *testing.T) {
+	enabled := testCollector{name: "enabled", calls: &atomic.Int64{}}
+	disabled := testCollector{name: "disabled", calls: &atomic.Int64{}}
+
+	r := NewRegistry()
+	require.NoError(t, r.Register(enabled))
+	require.NoError(t, r.Register(disabled))
+
+	cfg := &config.Config{
+		PollInterval: time.Hour,
+		Collectors: map[string]config.CollectorConfig{
+			"enabled":  {PollInterval: 10 * time.Millisecond},
+			"disabled": {Disabled: true, PollInterval: 10 * time.Millisecond},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := storage.NewMemStorage()
+	errs := make(chan error)
+	r.Run(ctx, cfg, s, errs)
+
+	assert.Eventually(t, func() bool {
+		return enabled.calls.Load() >= 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int64(0), disabled.calls.Load())
+
+	_, err := s.GetGaugeMetric(ctx, "enabled")
+	assert.NoError(t, err)
+}
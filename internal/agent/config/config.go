@@ -3,15 +3,17 @@ package config
 import (
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"flag"
+	"fmt"
 	"net"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/caarlos0/env/v10"
+	"github.com/smakimka/mtrcscollector/internal/configloader"
+	"github.com/smakimka/mtrcscollector/internal/logger"
 )
 
 type Config struct {
@@ -24,31 +26,117 @@ type Config struct {
 	RateLimit      int
 	MyIP           string
 	GRPC           bool
+	Collectors     map[string]CollectorConfig
+	Processes      []ProcessConfig
+	Exec           []ExecConfig
+	PushAddr       string
+	PushSocket     string
+	// кроме loopback по tcp метрики принимаются только из этой подсети
+	PushTrustedSubnet *net.IPNet
+	BuildVersion      string
+	LogLevel          string
+	ConfigPath        string
+	PrintConfig       bool
+
+	// загрузчик нужен, чтобы перечитать конфиг с теми же флагами
+	loader *configloader.Loader
+	source *JSONConfig
+}
+
+// CollectorConfig Настройки отдельного сборщика метрик.
+type CollectorConfig struct {
+	Disabled     bool
+	PollInterval time.Duration
+}
+
+// ProcessConfig Процесс, за которым следит агент, ищется по pid файлу, имени или регулярке по cmdline.
+type ProcessConfig struct {
+	Label   string `json:"label"`
+	PIDFile string `json:"pid_file"`
+	Name    string `json:"name"`
+	Cmdline string `json:"cmdline"`
+}
+
+// ExecConfig Внешняя команда, вывод которой разбирается в метрики.
+type ExecConfig struct {
+	Name    string
+	Command []string
+	Timeout time.Duration
+	Format  string
 }
 
+type JSONExecConfig struct {
+	Name         string   `json:"name"`
+	Command      []string `json:"command"`
+	Timeout      int      `json:"timeout"`
+	PollInterval int      `json:"poll_interval"`
+	Format       string   `json:"format"`
+}
+
+type JSONCollectorConfig struct {
+	Enabled      *bool `json:"enabled"`
+	PollInterval int   `json:"poll_interval"`
+}
+
+// JSONConfig Настройки агента в том виде, в котором они задаются файлом, переменными окружения и флагами.
 type JSONConfig struct {
-	Addr           string `json:"addr"`
-	CryptoKey      string `json:"crypto_key"`
-	Key            string `json:"key"`
-	ReportInterval int    `json:"report_interval"`
-	PollInterval   int    `json:"poll_interval"`
-	RateLimit      int    `json:"rate_limit"`
-	GRPC           string `json:"grpc"`
-}
-
-type EnvParams struct {
-	Config         string `env:"CONFIG"`
-	Addr           string `env:"ADDRESS"`
-	Key            string `env:"KEY"`
-	CryptoKeyPath  string `env:"CRYPTO_KEY"`
-	ReportInterval int    `env:"REPORT_INTERVAL"`
-	PollInterval   int    `env:"POLL_INTERVAL"`
-	RateLimit      int    `env:"RATE_LIMIT"`
-	GRPC           string `env:"GRPC"`
+	Addr               string `env:"ADDRESS" json:"addr" flag:"a" default:"localhost:8080" usage:"server addres without http://"`
+	CryptoKey          string `env:"CRYPTO_KEY" json:"crypto_key" flag:"crypto-key" usage:"path to public key file"`
+	Key                string `env:"KEY" json:"key" flag:"k" usage:"auth key string" loader:"secret"`
+	ReportInterval     int    `env:"REPORT_INTERVAL" json:"report_interval" flag:"r" default:"10" usage:"metrics sending period (in seconds)"`
+	PollInterval       int    `env:"POLL_INTERVAL" json:"poll_interval" flag:"p" default:"2" usage:"metrics updqating period (in seconds)"`
+	RateLimit          int    `env:"RATE_LIMIT" json:"rate_limit" flag:"l" default:"1" usage:"number of max concurrent request"`
+	GRPC               bool   `env:"GRPC" json:"grpc" flag:"g" usage:"grpc or not"`
+	PushAddr           string `env:"PUSH_ADDRESS" json:"push_addr" flag:"push-addr" usage:"host:port to accept metrics from local applications on"`
+	PushSocket         string `env:"PUSH_SOCKET" json:"push_socket" flag:"push-socket" usage:"unix socket path to accept metrics from local applications on"`
+	PushTrustedSubnet  string `env:"PUSH_TRUSTED_SUBNET" json:"push_trusted_subnet" flag:"push-trusted-subnet" usage:"subnet (CIDR) allowed to push metrics over tcp, by default only loopback is"`
+	LogLevel           string `env:"LOG_LEVEL" json:"log_level" flag:"log-level" default:"info" usage:"logging level (debug or info)"`
+	DisabledCollectors string `env:"DISABLED_COLLECTORS" json:"disabled_collectors" flag:"dc" usage:"comma separated list of disabled collectors"`
+	ConfigPath         string `env:"CONFIG" json:"-" flag:"c,config" usage:"path to a json or yaml config file, reread on SIGHUP" loader:"path"`
+	PrintConfig        bool   `json:"-" flag:"print-config" usage:"print the effective config with secrets hidden and exit"`
+
+	Collectors map[string]JSONCollectorConfig `json:"collectors"`
+	Processes  []ProcessConfig                `json:"processes"`
+	Exec       []JSONExecConfig               `json:"exec"`
+}
+
+// Проверить значения конфига, ошибки собираются все сразу
+func (c *JSONConfig) Validate() error {
+	errs := []error{
+		configloader.Required("addr", c.Addr),
+		configloader.Readable("crypto_key", c.CryptoKey),
+		configloader.Positive("report_interval", c.ReportInterval),
+		configloader.Positive("poll_interval", c.PollInterval),
+		configloader.Positive("rate_limit", c.RateLimit),
+		configloader.CIDR("push_trusted_subnet", c.PushTrustedSubnet),
+	}
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+
+	for name, collectorCfg := range c.Collectors {
+		errs = append(errs, configloader.NonNegative(fmt.Sprintf("collectors.%s.poll_interval", name), collectorCfg.PollInterval))
+	}
+	for i, execCfg := range c.Exec {
+		errs = append(errs,
+			configloader.Required(fmt.Sprintf("exec[%d].name", i), execCfg.Name),
+			configloader.NonNegative(fmt.Sprintf("exec[%d].timeout", i), execCfg.Timeout),
+			configloader.NonNegative(fmt.Sprintf("exec[%d].poll_interval", i), execCfg.PollInterval),
+		)
+		if len(execCfg.Command) == 0 {
+			errs = append(errs, fmt.Errorf("exec[%d].command: must be set", i))
+		}
+	}
+
+	return errors.Join(errs...)
 }
 
 func NewConfig() *Config {
-	return parseFlags()
+	cfg, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	return cfg
 }
 
 var ErrNokey = errors.New("key file doesn't contain key'")
@@ -74,6 +162,29 @@ func (c *Config) ReadCryptoKey() error {
 	return nil
 }
 
+// Имя сборщика для внешней команды
+func ExecCollectorName(name string) string {
+	return "exec." + name
+}
+
+// CollectorEnabled Включен ли сборщик с таким именем, по умолчанию включены все.
+func (c *Config) CollectorEnabled(name string) bool {
+	return !c.Collectors[name].Disabled
+}
+
+// CollectorPollInterval Период опроса сборщика, если не задан отдельно - общий PollInterval.
+func (c *Config) CollectorPollInterval(name string) time.Duration {
+	if interval := c.Collectors[name].PollInterval; interval > 0 {
+		return interval
+	}
+	return c.PollInterval
+}
+
+// Итоговый конфиг в json для вывода, секреты скрыты
+func (c *Config) Dump() ([]byte, error) {
+	return configloader.Dump(c.source)
+}
+
 func (c *Config) SetMyIP() error {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -90,131 +201,87 @@ func (c *Config) SetMyIP() error {
 	return nil
 }
 
-func parseFlags() *Config {
-	var serverAddr string
-	var flagReportInterval int
-	var flagPollInteraval int
-	var rateLimit int
-	var flagGRPC bool
-	var flagKey string
-	var flagCryptoKey string
-	var flagConfig string
-
-	flag.StringVar(&flagConfig, "c", "{}", "config in json format")
-	flag.StringVar(&serverAddr, "a", "localhost:8080", "server addres without http://")
-	flag.IntVar(&flagReportInterval, "r", 10, "metrics sending period (in seconds)")
-	flag.IntVar(&flagPollInteraval, "p", 2, "metrics updqating period (in seconds)")
-	reportInterval := time.Duration(flagReportInterval) * time.Second
-	pollInteraval := time.Duration(flagPollInteraval) * time.Second
-	flag.StringVar(&flagKey, "k", "", "auth key string")
-	flag.StringVar(&flagCryptoKey, "crypto-key", "", "path to public key file")
-	flag.IntVar(&rateLimit, "l", 1, "number of max concurrent request")
-	flag.BoolVar(&flagGRPC, "g", false, "grpc or not")
-	flag.Parse()
-
-	var jsonCfg JSONConfig
-	err := json.Unmarshal([]byte(flagConfig), &jsonCfg)
-	if err != nil {
-		panic(err)
+func parseFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	loader := configloader.New(&JSONConfig{}, fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	cfg := &Config{}
-	envParams := &EnvParams{}
-	err = env.Parse(envParams)
-	if err != nil {
-		panic(err)
+	jsonCfg := &JSONConfig{}
+	if err := loader.Load(jsonCfg); err != nil {
+		return nil, err
 	}
 
-	readJSON(cfg, &jsonCfg)
+	cfg := readJSON(jsonCfg)
+	cfg.loader = loader
+	return cfg, nil
+}
 
-	if envParams.Addr == "" {
-		if serverAddr != "localhost:8080" {
-			cfg.Addr = serverAddr
-		} else if cfg.Addr == "" {
-			cfg.Addr = serverAddr
+func disableCollectors(cfg *Config, names string) {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
 		}
-	} else {
-		cfg.Addr = envParams.Addr
-	}
 
-	if envParams.PollInterval == 0 {
-		if flagPollInteraval != 2 {
-			cfg.PollInterval = pollInteraval
-		} else if cfg.PollInterval == 0 {
-			cfg.PollInterval = pollInteraval
-		}
-	} else {
-		cfg.PollInterval = time.Duration(envParams.PollInterval) * time.Second
+		collectorCfg := cfg.Collectors[name]
+		collectorCfg.Disabled = true
+		cfg.Collectors[name] = collectorCfg
 	}
+}
 
-	if envParams.ReportInterval == 0 {
-		if flagReportInterval != 10 {
-			cfg.ReportInterval = reportInterval
-		} else if cfg.ReportInterval == 0 {
-			cfg.ReportInterval = reportInterval
-		}
-	} else {
-		cfg.ReportInterval = time.Duration(envParams.ReportInterval) * time.Second
+func readJSON(jsonCfg *JSONConfig) *Config {
+	cfg := &Config{
+		Addr:           jsonCfg.Addr,
+		CryptoKeyPath:  jsonCfg.CryptoKey,
+		Key:            jsonCfg.Key,
+		ReportInterval: time.Duration(jsonCfg.ReportInterval) * time.Second,
+		PollInterval:   time.Duration(jsonCfg.PollInterval) * time.Second,
+		RateLimit:      jsonCfg.RateLimit,
+		GRPC:           jsonCfg.GRPC,
+		PushAddr:       jsonCfg.PushAddr,
+		PushSocket:     jsonCfg.PushSocket,
+		LogLevel:       jsonCfg.LogLevel,
+		ConfigPath:     jsonCfg.ConfigPath,
+		PrintConfig:    jsonCfg.PrintConfig,
+		Processes:      jsonCfg.Processes,
+		source:         jsonCfg,
 	}
 
-	if envParams.RateLimit == 0 {
-		if rateLimit != 1 {
-			cfg.RateLimit = rateLimit
-		} else if cfg.RateLimit == 0 {
-			cfg.RateLimit = rateLimit
+	cfg.Collectors = make(map[string]CollectorConfig, len(jsonCfg.Collectors))
+	for name, jsonCollectorCfg := range jsonCfg.Collectors {
+		collectorCfg := CollectorConfig{
+			PollInterval: time.Duration(jsonCollectorCfg.PollInterval) * time.Second,
 		}
-	} else {
-		cfg.RateLimit = envParams.RateLimit
-	}
-
-	if envParams.Key == "" {
-		if flagKey != "" {
-			cfg.Key = flagKey
-		} else if cfg.Key == "" {
-			cfg.Key = flagKey
+		if jsonCollectorCfg.Enabled != nil {
+			collectorCfg.Disabled = !*jsonCollectorCfg.Enabled
 		}
-	} else {
-		cfg.Key = envParams.Key
+		cfg.Collectors[name] = collectorCfg
 	}
 
-	if envParams.CryptoKeyPath == "" {
-		if flagCryptoKey != "" {
-			cfg.CryptoKeyPath = flagCryptoKey
-		} else if cfg.CryptoKeyPath == "" {
-			cfg.CryptoKeyPath = flagCryptoKey
+	for _, jsonExecCfg := range jsonCfg.Exec {
+		cfg.Exec = append(cfg.Exec, ExecConfig{
+			Name:    jsonExecCfg.Name,
+			Command: jsonExecCfg.Command,
+			Timeout: time.Duration(jsonExecCfg.Timeout) * time.Second,
+			Format:  jsonExecCfg.Format,
+		})
+
+		// период опроса команды можно задать рядом с ней, настройки в collectors приоритетнее
+		collectorName := ExecCollectorName(jsonExecCfg.Name)
+		collectorCfg := cfg.Collectors[collectorName]
+		if collectorCfg.PollInterval == 0 {
+			collectorCfg.PollInterval = time.Duration(jsonExecCfg.PollInterval) * time.Second
 		}
-		cfg.CryptoKeyPath = flagCryptoKey
+		cfg.Collectors[collectorName] = collectorCfg
 	}
 
-	if envParams.GRPC == "" {
-		if !flagGRPC {
-			cfg.GRPC = flagGRPC
-		} else if !cfg.GRPC {
-			cfg.GRPC = flagGRPC
-		}
-		cfg.GRPC = flagGRPC
+	// подсеть уже проверена
+	if jsonCfg.PushTrustedSubnet != "" {
+		_, cfg.PushTrustedSubnet, _ = net.ParseCIDR(jsonCfg.PushTrustedSubnet)
 	}
 
-	return cfg
-}
+	disableCollectors(cfg, jsonCfg.DisabledCollectors)
 
-func readJSON(cfg *Config, jsonCfg *JSONConfig) {
-	if jsonCfg.Addr != "" {
-		cfg.Addr = jsonCfg.Addr
-	}
-	if jsonCfg.CryptoKey != "" {
-		cfg.CryptoKeyPath = jsonCfg.CryptoKey
-	}
-	if jsonCfg.Key != "" {
-		cfg.Key = jsonCfg.Key
-	}
-	if jsonCfg.RateLimit != 0 {
-		cfg.RateLimit = jsonCfg.RateLimit
-	}
-	if jsonCfg.PollInterval != 0 {
-		cfg.PollInterval = time.Duration(jsonCfg.PollInterval) * time.Second
-	}
-	if jsonCfg.ReportInterval != 0 {
-		cfg.ReportInterval = time.Duration(jsonCfg.ReportInterval) * time.Second
-	}
+	return cfg
 }
@@ -1,10 +1,14 @@
 package config
 
 import (
+	"flag"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAgentConfig(t *testing.T) {
@@ -23,3 +27,46 @@ func TestAgentConfig(t *testing.T) {
 	assert.Equal(t, defaultValues.reportInterval, cfg.ReportInterval)
 	assert.Equal(t, defaultValues.pollInterval, cfg.PollInterval)
 }
+
+func TestParseFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+addr: localhost:9090
+grpc: true
+report_interval: 5
+collectors:
+  gopsutil:
+    enabled: false
+exec:
+  - name: queue
+    command: ["echo", "1"]
+    poll_interval: 30
+`), 0o600))
+
+	t.Setenv("REPORT_INTERVAL", "7")
+	cfg, err := parseFlags(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-c", path, "-dc", "runtime"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "localhost:9090", cfg.Addr)
+	assert.True(t, cfg.GRPC)
+	assert.Equal(t, 7*time.Second, cfg.ReportInterval)
+	assert.Equal(t, 2*time.Second, cfg.PollInterval)
+	assert.False(t, cfg.CollectorEnabled("gopsutil"))
+	assert.False(t, cfg.CollectorEnabled("runtime"))
+	assert.Equal(t, 30*time.Second, cfg.CollectorPollInterval(ExecCollectorName("queue")))
+
+	_, err = parseFlags(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-r", "0", "-l", "0"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "report_interval")
+	assert.Contains(t, err.Error(), "rate_limit")
+}
+
+func TestDump(t *testing.T) {
+	cfg, err := parseFlags(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-k", "secret"})
+	require.NoError(t, err)
+
+	data, err := cfg.Dump()
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.Contains(t, string(data), `"report_interval": 10`)
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"errors"
+
+	"github.com/smakimka/mtrcscollector/internal/reload"
+)
+
+var ErrNoConfigFile = errors.New("config file is not set")
+
+// Reload Перечитать конфиг с теми же флагами и переменными окружения. Меняются только уровень логирования,
+// ключ подписи, периоды опроса и отправки и количество одновременных запросов, флаги и переменные окружения
+// по-прежнему приоритетнее файла. Текущий конфиг не меняется, возвращается новый вместе со списком изменений.
+func (c *Config) Reload() (*Config, reload.Changes, error) {
+	if c.ConfigPath == "" || c.loader == nil {
+		return nil, nil, ErrNoConfigFile
+	}
+
+	jsonCfg := &JSONConfig{}
+	if err := c.loader.Load(jsonCfg); err != nil {
+		return nil, nil, err
+	}
+	loaded := readJSON(jsonCfg)
+
+	next := *c
+	next.LogLevel = loaded.LogLevel
+	next.Key = loaded.Key
+	next.PollInterval = loaded.PollInterval
+	next.ReportInterval = loaded.ReportInterval
+	next.RateLimit = loaded.RateLimit
+	next.source = jsonCfg
+
+	var changes reload.Changes
+	changes.Add("log_level", c.LogLevel, next.LogLevel)
+	changes.AddSecret("key", c.Key, next.Key)
+	changes.Add("poll_interval", c.PollInterval, next.PollInterval)
+	changes.Add("report_interval", c.ReportInterval, next.ReportInterval)
+	changes.Add("rate_limit", c.RateLimit, next.RateLimit)
+
+	return &next, changes, nil
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/reload"
+)
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		file    string
+		want    reload.Changes
+		wantErr bool
+	}{
+		{
+			name: "reloadable settings",
+			file: `{"log_level": "debug", "key": "secret", "poll_interval": 1, "report_interval": 5, "rate_limit": 3, "addr": "localhost:9090"}`,
+			want: reload.Changes{
+				{Name: "log_level", Old: "info", New: "debug"},
+				{Name: "key", Old: `""`, New: reload.Redacted},
+				{Name: "poll_interval", Old: "2s", New: "1s"},
+				{Name: "report_interval", Old: "10s", New: "5s"},
+				{Name: "rate_limit", Old: "1", New: "3"},
+			},
+		},
+		{
+			name: "flags are kept",
+			args: []string{"-l", "1"},
+			file: `{"report_interval": 5, "rate_limit": 3}`,
+			want: reload.Changes{
+				{Name: "report_interval", Old: "10s", New: "5s"},
+			},
+		},
+		{
+			name:    "invalid settings",
+			file:    `{"log_level": "trace", "rate_limit": -1}`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			require.NoError(t, os.WriteFile(path, []byte(`{}`), 0o600))
+
+			cfg, err := parseFlags(flag.NewFlagSet("test", flag.ContinueOnError), append([]string{"-c", path}, test.args...))
+			require.NoError(t, err)
+
+			require.NoError(t, os.WriteFile(path, []byte(test.file), 0o600))
+			next, changes, err := cfg.Reload()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, changes)
+			assert.Equal(t, "localhost:8080", next.Addr)
+			assert.Equal(t, 1, cfg.RateLimit)
+		})
+	}
+}
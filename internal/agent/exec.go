@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+const (
+	ExecFormatText = "text"
+	ExecFormatJSON = "json"
+
+	DefaultExecTimeout = 10 * time.Second
+	// сколько ждать закрытия вывода после остановки команды, дочерние процессы могут держать его открытым
+	execWaitDelay = time.Second
+)
+
+var (
+	ErrEmptyExecConfig = errors.New("exec config must have name and command")
+	ErrWrongExecFormat = errors.New("wrong exec output format")
+	ErrWrongExecLine   = errors.New("exec output line must be \"kind name value\"")
+)
+
+// ExecCollector Сборщик, запускающий внешнюю команду и разбирающий ее вывод в метрики.
+type ExecCollector struct {
+	cfg config.ExecConfig
+}
+
+func NewExecCollector(cfg config.ExecConfig) (*ExecCollector, error) {
+	if cfg.Name == "" || len(cfg.Command) == 0 {
+		return nil, ErrEmptyExecConfig
+	}
+
+	if cfg.Format == "" {
+		cfg.Format = ExecFormatText
+	}
+	if cfg.Format != ExecFormatText && cfg.Format != ExecFormatJSON {
+		return nil, fmt.Errorf("%w: %s", ErrWrongExecFormat, cfg.Format)
+	}
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultExecTimeout
+	}
+
+	return &ExecCollector{cfg: cfg}, nil
+}
+
+func (c *ExecCollector) Name() string {
+	return config.ExecCollectorName(c.cfg.Name)
+}
+
+func (c *ExecCollector) Collect(ctx context.Context, s storage.Storage) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	stderr := bytes.NewBuffer([]byte{})
+	cmd := exec.CommandContext(ctx, c.cfg.Command[0], c.cfg.Command[1:]...)
+	cmd.Stderr = stderr
+	cmd.WaitDelay = execWaitDelay
+
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	metricsData, err := ParseExecOutput(c.cfg.Format, out)
+	if err != nil {
+		return err
+	}
+
+	return s.UpdateMetrics(ctx, metricsData)
+}
+
+// Разбор вывода команды, для text каждая строка имеет вид "kind name value",
+// пустые строки и строки начинающиеся с # пропускаются, для json ожидается model.MetricsData
+func ParseExecOutput(format string, out []byte) (model.MetricsData, error) {
+	var metricsData model.MetricsData
+
+	switch format {
+	case ExecFormatJSON:
+		if err := json.Unmarshal(out, &metricsData); err != nil {
+			return nil, err
+		}
+	case ExecFormatText:
+		scanner := bufio.NewScanner(bytes.NewReader(out))
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+
+			metricData, err := parseExecLine(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			metricsData = append(metricsData, metricData)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrWrongExecFormat, format)
+	}
+
+	for _, metricData := range metricsData {
+		if err := metricData.Bind(nil); err != nil {
+			return nil, err
+		}
+		if (metricData.Kind == model.Gauge && metricData.Value == nil) ||
+			(metricData.Kind == model.Counter && metricData.Delta == nil) {
+			return nil, model.ErrMissingFields
+		}
+	}
+
+	return metricsData, nil
+}
+
+func parseExecLine(line string) (model.MetricData, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return model.MetricData{}, ErrWrongExecLine
+	}
+
+	metricData := model.MetricData{Kind: fields[0], Name: fields[1]}
+	switch metricData.Kind {
+	case model.Gauge:
+		value, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return metricData, err
+		}
+		metricData.Value = &value
+	case model.Counter:
+		delta, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return metricData, err
+		}
+		metricData.Delta = &delta
+	default:
+		return metricData, model.ErrWrongMetricKind
+	}
+
+	return metricData, nil
+}
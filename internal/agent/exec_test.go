@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		out        string
+		wantLength int
+		wantErr    bool
+	}{
+		{
+			name:       "text",
+			format:     ExecFormatText,
+			out:        "# queue stats\ngauge QueueDepth 12.5\n\ncounter QueueProcessed 3\n",
+			wantLength: 2,
+		},
+		{
+			name:    "text wrong kind",
+			format:  ExecFormatText,
+			out:     "histogram QueueDepth 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "text wrong counter value",
+			format:  ExecFormatText,
+			out:     "counter QueueProcessed 1.5\n",
+			wantErr: true,
+		},
+		{
+			name:    "text missing value",
+			format:  ExecFormatText,
+			out:     "gauge QueueDepth\n",
+			wantErr: true,
+		},
+		{
+			name:       "json",
+			format:     ExecFormatJSON,
+			out:        `[{"id":"CertExpiryDays","type":"gauge","value":30},{"id":"Renewals","type":"counter","delta":1}]`,
+			wantLength: 2,
+		},
+		{
+			name:    "json missing delta",
+			format:  ExecFormatJSON,
+			out:     `[{"id":"Renewals","type":"counter"}]`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metricsData, err := ParseExecOutput(test.format, []byte(test.out))
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, metricsData, test.wantLength)
+		})
+	}
+}
+
+func TestExecCollector(t *testing.T) {
+	ctx := context.Background()
+
+	c, err := NewExecCollector(config.ExecConfig{
+		Name:    "queue",
+		Command: []string{"/bin/sh", "-c", "echo gauge QueueDepth 7; echo counter QueueProcessed 2"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "exec.queue", c.Name())
+
+	s := storage.NewMemStorage()
+	require.NoError(t, c.Collect(ctx, s))
+	require.NoError(t, c.Collect(ctx, s))
+
+	gauge, err := s.GetGaugeMetric(ctx, "QueueDepth")
+	require.NoError(t, err)
+	assert.Equal(t, float64(7), gauge.Value)
+
+	counter, err := s.GetCounterMetric(ctx, "QueueProcessed")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), counter.Value)
+}
+
+func TestExecCollectorTimeout(t *testing.T) {
+	c, err := NewExecCollector(config.ExecConfig{
+		Name:    "slow",
+		Command: []string{"/bin/sh", "-c", "sleep 5"},
+		Timeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	assert.Error(t, c.Collect(context.Background(), storage.NewMemStorage()))
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+var ErrEmptyProcessConfig = errors.New("process config must have pid_file, name or cmdline")
+
+// processWatch Состояние одного отслеживаемого процесса между опросами.
+type processWatch struct {
+	cfg     config.ProcessConfig
+	label   string
+	cmdline *regexp.Regexp
+
+	// самый старый из найденных процессов, его смена считается перезапуском
+	mainPID        int32
+	mainCreateTime int64
+}
+
+// ProcessCollector Сборщик потребления ресурсов заданными процессами.
+type ProcessCollector struct {
+	watches []*processWatch
+	// процессы кешируются между опросами, без этого gopsutil не может посчитать загрузку CPU
+	procs map[int32]*process.Process
+	mutex sync.Mutex
+}
+
+func NewProcessCollector(cfgs []config.ProcessConfig) (*ProcessCollector, error) {
+	c := &ProcessCollector{
+		procs: make(map[int32]*process.Process),
+	}
+
+	for _, cfg := range cfgs {
+		if cfg.PIDFile == "" && cfg.Name == "" && cfg.Cmdline == "" {
+			return nil, ErrEmptyProcessConfig
+		}
+		watch := &processWatch{cfg: cfg, label: processLabel(cfg)}
+
+		if cfg.PIDFile == "" && cfg.Name == "" {
+			re, err := regexp.Compile(cfg.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("process %s: %w", watch.label, err)
+			}
+			watch.cmdline = re
+		}
+
+		c.watches = append(c.watches, watch)
+	}
+
+	return c, nil
+}
+
+func processLabel(cfg config.ProcessConfig) string {
+	switch {
+	case cfg.Label != "":
+		return cfg.Label
+	case cfg.PIDFile != "":
+		return strings.TrimSuffix(filepath.Base(cfg.PIDFile), ".pid")
+	case cfg.Name != "":
+		return cfg.Name
+	default:
+		return cfg.Cmdline
+	}
+}
+
+func (c *ProcessCollector) Name() string {
+	return "process"
+}
+
+func (c *ProcessCollector) Collect(ctx context.Context, s storage.Storage) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	alive := make(map[int32]*process.Process, len(procs))
+	for _, p := range procs {
+		alive[p.Pid] = c.cached(ctx, p)
+	}
+	c.procs = alive
+
+	for _, watch := range c.watches {
+		matched, err := c.match(ctx, watch)
+		if err != nil {
+			return fmt.Errorf("process %s: %w", watch.label, err)
+		}
+
+		if err = c.report(ctx, s, watch, matched); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Процесс из кеша, если pid не был переиспользован другим процессом
+func (c *ProcessCollector) cached(ctx context.Context, p *process.Process) *process.Process {
+	old, ok := c.procs[p.Pid]
+	if !ok {
+		return p
+	}
+
+	oldCreateTime, err := old.CreateTimeWithContext(ctx)
+	if err != nil {
+		return p
+	}
+	createTime, err := p.CreateTimeWithContext(ctx)
+	if err != nil || createTime != oldCreateTime {
+		return p
+	}
+
+	return old
+}
+
+func (c *ProcessCollector) match(ctx context.Context, watch *processWatch) ([]*process.Process, error) {
+	if watch.cfg.PIDFile != "" {
+		data, err := os.ReadFile(watch.cfg.PIDFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		if p, ok := c.procs[int32(pid)]; ok {
+			return []*process.Process{p}, nil
+		}
+		return nil, nil
+	}
+
+	var matched []*process.Process
+	for _, p := range c.procs {
+		if watch.cfg.Name != "" {
+			name, err := p.NameWithContext(ctx)
+			if err == nil && name == watch.cfg.Name {
+				matched = append(matched, p)
+			}
+			continue
+		}
+
+		cmdline, err := p.CmdlineWithContext(ctx)
+		if err == nil && watch.cmdline.MatchString(cmdline) {
+			matched = append(matched, p)
+		}
+	}
+
+	return matched, nil
+}
+
+// Запись суммарных по найденным процессам метрик, процессы могут завершиться во время опроса,
+// поэтому ошибки чтения отдельных значений пропускаются
+func (c *ProcessCollector) report(ctx context.Context, s storage.Storage, watch *processWatch, matched []*process.Process) error {
+	var cpuPercent, rss, fds, threads float64
+	mainPID, mainCreateTime := int32(0), int64(0)
+
+	for _, p := range matched {
+		if percent, err := p.PercentWithContext(ctx, 0); err == nil {
+			cpuPercent += percent
+		}
+		if memInfo, err := p.MemoryInfoWithContext(ctx); err == nil {
+			rss += float64(memInfo.RSS)
+		}
+		if numFDs, err := p.NumFDsWithContext(ctx); err == nil {
+			fds += float64(numFDs)
+		}
+		if numThreads, err := p.NumThreadsWithContext(ctx); err == nil {
+			threads += float64(numThreads)
+		}
+
+		createTime, err := p.CreateTimeWithContext(ctx)
+		if err == nil && (mainPID == 0 || createTime < mainCreateTime) {
+			mainPID, mainCreateTime = p.Pid, createTime
+		}
+	}
+
+	restarts := int64(0)
+	if mainPID != 0 {
+		if watch.mainPID != 0 && (watch.mainPID != mainPID || watch.mainCreateTime != mainCreateTime) {
+			restarts = 1
+		}
+		watch.mainPID, watch.mainCreateTime = mainPID, mainCreateTime
+	}
+
+	labels := model.Labels{"process": watch.label}
+	count := float64(len(matched))
+	return s.UpdateMetrics(ctx, model.MetricsData{
+		{Name: model.LabeledName("ProcessCount", labels), Kind: model.Gauge, Value: &count},
+		{Name: model.LabeledName("ProcessCPUPercent", labels), Kind: model.Gauge, Value: &cpuPercent},
+		{Name: model.LabeledName("ProcessRSS", labels), Kind: model.Gauge, Value: &rss},
+		{Name: model.LabeledName("ProcessOpenFDs", labels), Kind: model.Gauge, Value: &fds},
+		{Name: model.LabeledName("ProcessThreads", labels), Kind: model.Gauge, Value: &threads},
+		{Name: model.LabeledName("ProcessRestarts", labels), Kind: model.Counter, Delta: &restarts},
+	})
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func TestNewProcessCollector(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfgs    []config.ProcessConfig
+		wantErr bool
+	}{
+		{
+			name: "valid configs",
+			cfgs: []config.ProcessConfig{
+				{Name: "nginx"},
+				{PIDFile: "/run/nginx.pid"},
+				{Cmdline: "^/usr/bin/python .*worker"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "empty config",
+			cfgs:    []config.ProcessConfig{{Label: "nothing"}},
+			wantErr: true,
+		},
+		{
+			name:    "bad regexp",
+			cfgs:    []config.ProcessConfig{{Cmdline: "(("}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewProcessCollector(test.cfgs)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProcessCollectorPIDFile(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "self.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644))
+
+	c, err := NewProcessCollector([]config.ProcessConfig{{PIDFile: pidFile}})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	require.NoError(t, c.Collect(ctx, s))
+	require.NoError(t, c.Collect(ctx, s))
+
+	labels := model.Labels{"process": "self"}
+
+	count, err := s.GetGaugeMetric(ctx, model.LabeledName("ProcessCount", labels))
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), count.Value)
+
+	rss, err := s.GetGaugeMetric(ctx, model.LabeledName("ProcessRSS", labels))
+	require.NoError(t, err)
+	assert.Greater(t, rss.Value, float64(0))
+
+	restarts, err := s.GetCounterMetric(ctx, model.LabeledName("ProcessRestarts", labels))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), restarts.Value)
+}
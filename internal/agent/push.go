@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/ingest"
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// Роутер для приема метрик от локальных приложений, принимает те же запросы обновления, что и сервер.
+// Метрики складываются в хранилище агента и уходят на сервер вместе с остальными, поэтому по tcp
+// принимаются только с loopback и из trusted, по unix сокету доступ ограничивают права на файл
+func NewPushRouter(s storage.Storage, trusted *net.IPNet) chi.Router {
+	r := chi.NewRouter()
+	r.Use(allowPushPeer(trusted))
+	r.Use(ingest.Gzip)
+
+	r.Post("/update/", func(w http.ResponseWriter, r *http.Request) {
+		data := &model.MetricData{}
+		if err := render.Bind(r, data); err != nil {
+			pushResponse(w, r, http.StatusBadRequest, err)
+			return
+		}
+		pushUpdate(w, r, s, model.MetricsData{*data})
+	})
+	r.Post("/updates/", func(w http.ResponseWriter, r *http.Request) {
+		data := model.MetricsData{}
+		if err := render.Bind(r, &data); err != nil {
+			pushResponse(w, r, http.StatusBadRequest, err)
+			return
+		}
+		pushUpdate(w, r, s, data)
+	})
+
+	r.Route("/update/{metricKind}", func(r chi.Router) {
+		r.Use(ingest.MetricKind)
+		r.Post("/{metricName}/{metricValue}", func(w http.ResponseWriter, r *http.Request) {
+			data, err := ingest.ParseURLMetric(chi.URLParam(r, "metricKind"), ingest.URLParam(r, "metricName"), chi.URLParam(r, "metricValue"))
+			if err != nil {
+				pushResponse(w, r, http.StatusBadRequest, err)
+				return
+			}
+			pushUpdate(w, r, s, model.MetricsData{data})
+		})
+	})
+
+	return r
+}
+
+// Пускать tcp соединения только с loopback и из доверенной подсети
+func allowPushPeer(trusted *net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			ip := net.ParseIP(host)
+			if err != nil || ip == nil || !ip.IsLoopback() && (trusted == nil || !trusted.Contains(ip)) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func pushUpdate(w http.ResponseWriter, r *http.Request, s storage.Storage, data model.MetricsData) {
+	if err := s.UpdateMetrics(r.Context(), data); err != nil {
+		pushResponse(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	pushResponse(w, r, http.StatusOK, nil)
+}
+
+func pushResponse(w http.ResponseWriter, r *http.Request, status int, err error) {
+	response := model.Response{Ok: err == nil}
+	if err != nil {
+		response.Detail = err.Error()
+	}
+	render.Status(r, status)
+	render.JSON(w, r, response)
+}
+
+// Запуск приема метрик на tcp адресе и/или unix сокете из конфига, прием останавливается при отмене контекста
+func ServePush(ctx context.Context, cfg *config.Config, s storage.Storage, errs chan<- error) error {
+	var listeners []net.Listener
+
+	if cfg.PushAddr != "" {
+		listener, err := net.Listen("tcp", cfg.PushAddr)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if cfg.PushSocket != "" {
+		// сокет мог остаться от предыдущего запуска
+		if err := os.Remove(cfg.PushSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			closeListeners(listeners)
+			return err
+		}
+
+		listener, err := net.Listen("unix", cfg.PushSocket)
+		if err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 0 {
+		return nil
+	}
+
+	server := &http.Server{Handler: NewPushRouter(s, cfg.PushTrustedSubnet)}
+	for _, listener := range listeners {
+		logger.Log.Info().Msg(fmt.Sprintf("accepting metrics on %s", listener.Addr()))
+
+		go func(listener net.Listener) {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(listener)
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	return nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func TestPushRouter(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		body string
+		want int
+	}{
+		{
+			name: "update by url",
+			url:  "/update/counter/AppRequests/2",
+			want: http.StatusOK,
+		},
+		{
+			name: "update json",
+			url:  "/update/",
+			body: `{"id":"AppRequests","type":"counter","delta":3}`,
+			want: http.StatusOK,
+		},
+		{
+			name: "updates json",
+			url:  "/updates/",
+			body: `[{"id":"AppQueue","type":"gauge","value":4.5},{"id":"AppRequests","type":"counter","delta":5}]`,
+			want: http.StatusOK,
+		},
+		{
+			name: "wrong kind",
+			url:  "/update/histogram/AppRequests/2",
+			want: http.StatusBadRequest,
+		},
+	}
+
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	ts := httptest.NewServer(NewPushRouter(s, nil))
+	defer ts.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := ts.Client().Post(ts.URL+test.url, "application/json", strings.NewReader(test.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.want, resp.StatusCode)
+		})
+	}
+
+	counter, err := s.GetCounterMetric(ctx, "AppRequests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), counter.Value)
+
+	gauge, err := s.GetGaugeMetric(ctx, "AppQueue")
+	require.NoError(t, err)
+	assert.Equal(t, 4.5, gauge.Value)
+}
+
+func TestPushRouterPeers(t *testing.T) {
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		remote  string
+		trusted *net.IPNet
+		want    int
+	}{
+		{name: "loopback", remote: "127.0.0.1:5000", want: http.StatusOK},
+		{name: "ipv6 loopback", remote: "[::1]:5000", want: http.StatusOK},
+		{name: "other host", remote: "10.1.2.3:5000", want: http.StatusForbidden},
+		{name: "trusted subnet", remote: "10.1.2.3:5000", trusted: trusted, want: http.StatusOK},
+		{name: "outside trusted subnet", remote: "192.168.1.1:5000", trusted: trusted, want: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/gauge/AppQueue/1", nil)
+			req.RemoteAddr = test.remote
+			w := httptest.NewRecorder()
+
+			NewPushRouter(storage.NewMemStorage(), test.trusted).ServeHTTP(w, req)
+			assert.Equal(t, test.want, w.Code)
+		})
+	}
+}
+
+func TestServePushSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := storage.NewMemStorage()
+	errs := make(chan error, 1)
+	require.NoError(t, ServePush(ctx, &config.Config{PushSocket: socket}, s, errs))
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	resp, err := client.Post("http://agent/update/gauge/AppQueue/1", "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	gauge, err := s.GetGaugeMetric(ctx, "AppQueue")
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), gauge.Value)
+}
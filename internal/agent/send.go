@@ -8,11 +8,17 @@ import (
 	"crypto/rsa"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/smakimka/mtrcscollector/internal/agent/config"
 	"github.com/smakimka/mtrcscollector/internal/auth"
@@ -22,31 +28,60 @@ import (
 	pb "github.com/smakimka/mtrcscollector/protobuf/server"
 )
 
-func Worker(ctx context.Context, cfg config.Config, client *resty.Client, id int, jobs <-chan model.MetricsData, errs chan<- error) {
-	for metricsData := range jobs {
-		logger.Log.Debug().Msg(fmt.Sprintf("worker %d started work", id))
+var ErrNotDelivered = errors.New("metrics were not delivered")
 
-		err := sendRequest(ctx, &cfg, metricsData, client)
-		if err != nil {
-			errs <- err
+// Отправлять метрики по http, пока не закроется stop. Начатая отправка при остановке доводится до конца
+func Worker(ctx context.Context, cfg config.Config, client *resty.Client, id int, jobs <-chan Batch, stop <-chan struct{}, errs chan<- error) {
+	for {
+		select {
+		case <-stop:
+			return
+		case batch, ok := <-jobs:
+			if !ok {
+				return
+			}
+			logger.Log.Debug().Msg(fmt.Sprintf("worker %d started work", id))
+
+			err := sendRequest(ctx, &cfg, batch.Metrics, client)
+			if err != nil {
+				batch.failed()
+				errs <- err
+			}
+			logger.Log.Debug().Msg(fmt.Sprintf("worker %d finished work", id))
 		}
-		logger.Log.Debug().Msg(fmt.Sprintf("worker %d finished work", id))
 	}
 }
 
-func GRPCWorker(ctx context.Context, cfg config.Config, client pb.MetricsCollectorClient, id int, jobs <-chan model.MetricsData, errs chan<- error) {
-	for metricsData := range jobs {
-		logger.Log.Debug().Msg(fmt.Sprintf("worker %d started work", id))
+// Отправлять метрики по grpc, пока не закроется stop. Начатая отправка при остановке доводится до конца
+func GRPCWorker(ctx context.Context, cfg config.Config, client pb.MetricsCollectorClient, id int, jobs <-chan Batch, stop <-chan struct{}, errs chan<- error) {
+	for {
+		select {
+		case <-stop:
+			return
+		case batch, ok := <-jobs:
+			if !ok {
+				return
+			}
+			logger.Log.Debug().Msg(fmt.Sprintf("worker %d started work", id))
 
-		err := sendGRPCRequest(ctx, &cfg, metricsData, client)
-		if err != nil {
-			errs <- err
+			err := sendGRPCRequest(ctx, &cfg, batch.Metrics, client)
+			if err != nil {
+				batch.failed()
+				errs <- err
+			}
+			logger.Log.Debug().Msg(fmt.Sprintf("worker %d finished work", id))
 		}
-		logger.Log.Debug().Msg(fmt.Sprintf("worker %d finished work", id))
 	}
 }
 
-func SendMetrics(ctx context.Context, _ *config.Config, s storage.Storage, jobs chan<- model.MetricsData, errs chan<- error) {
+// Собрать пачку метрик для отправки, у counter в пачке приращение с прошлой доставленной отправки
+func SendMetrics(ctx context.Context, _ *config.Config, sender *Sender, s storage.Storage, jobs chan<- Batch, errs chan<- error) {
+	DefaultTelemetry.QueueDepth(len(jobs))
+	if err := DefaultTelemetry.Flush(ctx, s); err != nil {
+		errs <- err
+		return
+	}
+
 	gaugeMetrics, err := s.GetAllGaugeMetrics(ctx)
 	if err != nil {
 		errs <- err
@@ -62,10 +97,6 @@ func SendMetrics(ctx context.Context, _ *config.Config, s storage.Storage, jobs
 	metricsData := model.MetricsData{}
 
 	for i := range gaugeMetrics {
-		if gaugeMetrics[i].Name == "LastPollCount" {
-			continue
-		}
-
 		metricsData = append(metricsData, model.MetricData{
 			Name:  gaugeMetrics[i].Name,
 			Kind:  model.Gauge,
@@ -74,60 +105,76 @@ func SendMetrics(ctx context.Context, _ *config.Config, s storage.Storage, jobs
 	}
 
 	for i := range counterMetrics {
-		if counterMetrics[i].Name == "PollCount" {
-			pollCountData, err := getPollCountData(ctx, s, counterMetrics[i])
-			if err != nil {
-				errs <- err
-				return
-			}
-			metricsData = append(metricsData, pollCountData)
-			continue
-		}
+		inc := sender.delta(counterMetrics[i].Name, counterMetrics[i].Value)
 		metricsData = append(metricsData, model.MetricData{
 			Name:  counterMetrics[i].Name,
 			Kind:  model.Counter,
-			Delta: &counterMetrics[i].Value,
+			Delta: &inc,
 		})
 	}
 
 	logger.Log.Debug().Msg("sending job to workers")
-	jobs <- metricsData
+	jobs <- Batch{Metrics: metricsData, sender: sender}
 }
 
-func getPollCountData(ctx context.Context, s storage.Storage, m model.CounterMetric) (model.MetricData, error) {
-	data := model.MetricData{}
+// Sender Значения счетчиков на момент прошлой отправки. Хранятся отдельно от метрик,
+// чтобы не пересекаться с ними по именам и не попадать в отправку.
+type Sender struct {
+	last  map[string]int64
+	mutex sync.Mutex
+}
 
-	pollCount, err := s.GetCounterMetric(ctx, "PollCount")
-	if err != nil {
-		return data, err
-	}
+func NewSender() *Sender {
+	return &Sender{last: make(map[string]int64)}
+}
 
-	lastPollCount, err := s.GetGaugeMetric(ctx, "LastPollCount")
-	if err != nil {
-		return data, err
-	}
-	inc := pollCount.Value - int64(lastPollCount.Value)
+// Приращение счетчика с прошлой отправки, счетчики в хранилище агента накопительные
+func (s *Sender) delta(name string, current int64) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	if err = s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "LastPollCount", Value: float64(pollCount.Value)}); err != nil {
-		return data, err
+	inc := current - s.last[name]
+	s.last[name] = current
+	return inc
+}
+
+// Вернуть приращения недоставленной пачки, чтобы они ушли со следующей. Приращения складываются,
+// поэтому порядок доставки пачек, отправляемых параллельно, не важен
+func (s *Sender) rollback(metricsData model.MetricsData) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, m := range metricsData {
+		if m.Kind == model.Counter && m.Delta != nil {
+			s.last[m.Name] -= *m.Delta
+		}
 	}
+}
 
-	return model.MetricData{
-		Name:  m.Name,
-		Kind:  model.Counter,
-		Delta: &inc,
-	}, nil
+// Batch Пачка метрик для отправки.
+type Batch struct {
+	Metrics model.MetricsData
+	sender  *Sender
+}
+
+// Пачка не доставлена, приращения счетчиков уйдут со следующей
+func (b Batch) failed() {
+	if b.sender != nil {
+		b.sender.rollback(b.Metrics)
+	}
 }
 
 func sendRequest(_ context.Context, cfg *config.Config, data model.MetricsData, client *resty.Client) error {
 	body, err := json.Marshal(data)
 	if err != nil {
+		DefaultTelemetry.SendFailed(SendErrorEncode)
 		return err
 	}
 
 	if cfg.CryptoKey != nil {
 		encBody, err := rsa.EncryptPKCS1v15(rand.Reader, cfg.CryptoKey, body)
 		if err != nil {
+			DefaultTelemetry.SendFailed(SendErrorEncode)
 			return err
 		}
 		body = encBody
@@ -142,7 +189,9 @@ func sendRequest(_ context.Context, cfg *config.Config, data model.MetricsData,
 		SetHeader("Content-Type", "application/json").
 		SetHeader("Content-Encoding", "gzip").
 		SetHeader("Accept-Encoding", "gzip").
-		SetHeader("X-Real-IP", cfg.MyIP)
+		SetHeader("X-Real-IP", cfg.MyIP).
+		SetHeader(model.AgentVersionHeader, cfg.BuildVersion).
+		SetHeader(model.ReportIntervalHeader, strconv.Itoa(int(cfg.ReportInterval.Seconds())))
 
 	if cfg.CryptoKey != nil {
 		req.SetHeader("Encryption", "crypto-key")
@@ -154,18 +203,33 @@ func sendRequest(_ context.Context, cfg *config.Config, data model.MetricsData,
 	}
 
 	// Можно просто SetBody со структурой, которая сюда передается, но надо чтобы в импортах был хоть где-то json, будет тут
+	compressedSize := zipBody.Len()
+	start := time.Now()
 	resp, err := req.
 		SetBody(zipBody).
 		Post("/updates/")
 
 	if err != nil {
+		DefaultTelemetry.SendFailed(SendErrorNetwork)
 		return err
 	}
 
-	if resp.StatusCode() != http.StatusOK {
-		logger.Log.Warn().Msg(fmt.Sprintf("got not ok status (%d)", resp.StatusCode()))
+	switch resp.StatusCode() {
+	case http.StatusOK:
+	case http.StatusMultiStatus:
+		// пачка доставлена, но часть новых метрик сервер отклонил по лимитам
+		var response model.Response
+		if err = json.Unmarshal(resp.Body(), &response); err != nil {
+			logger.Log.Warn().Msg(fmt.Sprintf("error decoding partial write response: %v", err))
+		}
+		DefaultTelemetry.Rejected(len(response.Rejected))
+		logger.Log.Warn().Msg(fmt.Sprintf("server rejected %d metrics: %s", len(response.Rejected), strings.Join(response.Rejected, ", ")))
+	default:
+		DefaultTelemetry.SendFailed(SendErrorStatus)
+		return fmt.Errorf("%w: got not ok status (%d)", ErrNotDelivered, resp.StatusCode())
 	}
 
+	DefaultTelemetry.Sent("http", len(body), compressedSize, time.Since(start))
 	return nil
 }
 
@@ -180,18 +244,32 @@ func sendGRPCRequest(ctx context.Context, cfg *config.Config, data model.Metrics
 		})
 	}
 
-	md := metadata.New(map[string]string{"X-Real-IP": cfg.MyIP})
+	md := metadata.New(map[string]string{
+		"X-Real-IP":                cfg.MyIP,
+		model.AgentVersionHeader:   cfg.BuildVersion,
+		model.ReportIntervalHeader: strconv.Itoa(int(cfg.ReportInterval.Seconds())),
+	})
 	ctx = metadata.NewOutgoingContext(ctx, md)
 
+	start := time.Now()
 	resp, err := client.Update(ctx, in)
 	if err != nil {
+		DefaultTelemetry.SendFailed(SendErrorNetwork)
 		return err
 	}
 
 	if !resp.Ok {
-		logger.Log.Warn().Msg(fmt.Sprintf("got error (%s)", resp.Detail))
+		DefaultTelemetry.SendFailed(SendErrorStatus)
+		return fmt.Errorf("%w: got error (%s)", ErrNotDelivered, resp.Detail)
+	}
+	if resp.Detail != "" {
+		// пачка доставлена, но часть новых метрик сервер отклонил по лимитам
+		logger.Log.Warn().Msg(fmt.Sprintf("partial write (%s)", resp.Detail))
 	}
 
+	// grpc не сжимает запросы, размер до и после сжатия совпадает
+	size := proto.Size(in)
+	DefaultTelemetry.Sent("grpc", size, size, time.Since(start))
 	return nil
 
 }
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/net"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// deltaTracker Переводит накопительные счетчики ОС в приращения между опросами.
+type deltaTracker struct {
+	last  map[string]uint64
+	mutex sync.Mutex
+}
+
+func newDeltaTracker() *deltaTracker {
+	return &deltaTracker{last: make(map[string]uint64)}
+}
+
+// Приращение счетчика с прошлого опроса, при первом опросе и после сброса счетчика
+// приращением считается 0 и текущее значение соответственно
+func (t *deltaTracker) delta(name string, current uint64) int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	last, ok := t.last[name]
+	t.last[name] = current
+
+	switch {
+	case !ok:
+		return 0
+	case current < last:
+		return int64(current)
+	default:
+		return int64(current - last)
+	}
+}
+
+// Добавить приращение счетчика в хранилище
+func (t *deltaTracker) update(ctx context.Context, s storage.Storage, name string, current uint64) error {
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: name, Value: t.delta(name, current)})
+	return err
+}
+
+// CPUCollector Сборщик загрузки каждого ядра процессора.
+type CPUCollector struct{}
+
+func (c CPUCollector) Name() string {
+	return "cpu"
+}
+
+func (c CPUCollector) Collect(ctx context.Context, s storage.Storage) error {
+	percents, err := cpu.PercentWithContext(ctx, 0, true)
+	if err != nil {
+		return err
+	}
+
+	for i, percent := range percents {
+		err = s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: fmt.Sprintf("CPUutilization%d", i+1), Value: percent})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadCollector Сборщик средней загрузки системы.
+type LoadCollector struct{}
+
+func (c LoadCollector) Name() string {
+	return "load"
+}
+
+func (c LoadCollector) Collect(ctx context.Context, s storage.Storage) error {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.UpdateMetrics(ctx, model.MetricsData{
+		{Name: "Load1", Kind: model.Gauge, Value: &avg.Load1},
+		{Name: "Load5", Kind: model.Gauge, Value: &avg.Load5},
+		{Name: "Load15", Kind: model.Gauge, Value: &avg.Load15},
+	})
+}
+
+// DiskCollector Сборщик заполненности точек монтирования и ввода-вывода по устройствам.
+type DiskCollector struct {
+	deltas *deltaTracker
+}
+
+func NewDiskCollector() *DiskCollector {
+	return &DiskCollector{deltas: newDeltaTracker()}
+}
+
+func (c *DiskCollector) Name() string {
+	return "disk"
+}
+
+func (c *DiskCollector) Collect(ctx context.Context, s storage.Storage) error {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		usage, err := disk.UsageWithContext(ctx, partition.Mountpoint)
+		if err != nil {
+			continue
+		}
+
+		labels := model.Labels{"mount": partition.Mountpoint}
+		total := float64(usage.Total)
+		used := float64(usage.Used)
+		free := float64(usage.Free)
+		err = s.UpdateMetrics(ctx, model.MetricsData{
+			{Name: model.LabeledName("DiskTotal", labels), Kind: model.Gauge, Value: &total},
+			{Name: model.LabeledName("DiskUsed", labels), Kind: model.Gauge, Value: &used},
+			{Name: model.LabeledName("DiskFree", labels), Kind: model.Gauge, Value: &free},
+			{Name: model.LabeledName("DiskUsedPercent", labels), Kind: model.Gauge, Value: &usage.UsedPercent},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	counters, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	for device, stat := range counters {
+		labels := model.Labels{"device": device}
+		values := map[string]uint64{
+			"DiskReadBytes":  stat.ReadBytes,
+			"DiskWriteBytes": stat.WriteBytes,
+			"DiskReadCount":  stat.ReadCount,
+			"DiskWriteCount": stat.WriteCount,
+		}
+		for name, value := range values {
+			if err = c.deltas.update(ctx, s, model.LabeledName(name, labels), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// NetCollector Сборщик трафика, пакетов и ошибок по сетевым интерфейсам.
+type NetCollector struct {
+	deltas *deltaTracker
+}
+
+func NewNetCollector() *NetCollector {
+	return &NetCollector{deltas: newDeltaTracker()}
+}
+
+func (c *NetCollector) Name() string {
+	return "net"
+}
+
+func (c *NetCollector) Collect(ctx context.Context, s storage.Storage) error {
+	counters, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	for _, stat := range counters {
+		labels := model.Labels{"iface": stat.Name}
+		values := map[string]uint64{
+			"NetBytesSent":   stat.BytesSent,
+			"NetBytesRecv":   stat.BytesRecv,
+			"NetPacketsSent": stat.PacketsSent,
+			"NetPacketsRecv": stat.PacketsRecv,
+			"NetErrIn":       stat.Errin,
+			"NetErrOut":      stat.Errout,
+			"NetDropIn":      stat.Dropin,
+			"NetDropOut":     stat.Dropout,
+		}
+		for name, value := range values {
+			if err = c.deltas.update(ctx, s, model.LabeledName(name, labels), value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
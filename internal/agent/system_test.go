@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func TestDeltaTracker(t *testing.T) {
+	tests := []struct {
+		name    string
+		current uint64
+		want    int64
+	}{
+		{
+			name:    "first observation",
+			current: 100,
+			want:    0,
+		},
+		{
+			name:    "increase",
+			current: 150,
+			want:    50,
+		},
+		{
+			name:    "no change",
+			current: 150,
+			want:    0,
+		},
+		{
+			name:    "counter reset",
+			current: 20,
+			want:    20,
+		},
+	}
+
+	tracker := newDeltaTracker()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, tracker.delta("test", test.current))
+		})
+	}
+}
+
+func TestSendMetricsCounterDeltas(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	jobs := make(chan Batch, 1)
+	sender := NewSender()
+	errs := make(chan error, 1)
+
+	name := model.LabeledName("NetBytesSent", model.Labels{"iface": "eth0"})
+
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: name, Value: 10})
+	require.NoError(t, err)
+	SendMetrics(ctx, nil, sender, s, jobs, errs)
+	assert.Equal(t, int64(10), counterDelta(t, (<-jobs).Metrics, name))
+
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: name, Value: 5})
+	require.NoError(t, err)
+	SendMetrics(ctx, nil, sender, s, jobs, errs)
+	data := (<-jobs).Metrics
+	assert.Equal(t, int64(5), counterDelta(t, data, name))
+
+	for _, metricData := range data {
+		assert.NotEqual(t, "Last"+name, metricData.Name)
+	}
+}
+
+func TestSendMetricsCounterNamedLikeGauge(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	jobs := make(chan Batch, 1)
+	sender := NewSender()
+	errs := make(chan error, 1)
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "LastGC", Value: 42}))
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "GC", Value: 3})
+	require.NoError(t, err)
+	SendMetrics(ctx, nil, sender, s, jobs, errs)
+	data := (<-jobs).Metrics
+
+	assert.Equal(t, int64(3), counterDelta(t, data, "GC"))
+	for _, metricData := range data {
+		if metricData.Name == "LastGC" {
+			assert.Equal(t, float64(42), *metricData.Value)
+		}
+	}
+	lastGC, err := s.GetGaugeMetric(ctx, "LastGC")
+	require.NoError(t, err)
+	assert.Equal(t, float64(42), lastGC.Value)
+}
+
+func TestSendMetricsUndeliveredDeltas(t *testing.T) {
+	status := http.StatusInternalServerError
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	sender := NewSender()
+	client := resty.New().SetBaseURL(ts.URL)
+	errs := make(chan error, 1)
+
+	// собрать пачку и отправить ее отправщиком, он завершается, когда закрыт канал задач
+	send := func() model.MetricsData {
+		jobs := make(chan Batch, 1)
+		SendMetrics(ctx, nil, sender, s, jobs, errs)
+		close(jobs)
+		batch := <-jobs
+
+		worker := make(chan Batch, 1)
+		worker <- batch
+		close(worker)
+		Worker(ctx, config.Config{}, client, 1, worker, make(chan struct{}), errs)
+		return batch.Metrics
+	}
+
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), counterDelta(t, send(), "PollCount"))
+	assert.ErrorIs(t, <-errs, ErrNotDelivered)
+
+	// недоставленное приращение уходит со следующей пачкой
+	status = http.StatusOK
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 5})
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), counterDelta(t, send(), "PollCount"))
+
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), counterDelta(t, send(), "PollCount"))
+	assert.Empty(t, errs)
+}
+
+func counterDelta(t *testing.T, data model.MetricsData, name string) int64 {
+	for _, metricData := range data {
+		if metricData.Name == name && metricData.Kind == model.Counter {
+			return *metricData.Delta
+		}
+	}
+	require.Fail(t, "counter not sent", name)
+	return 0
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// Типы ошибок отправки
+const (
+	SendErrorEncode  = "encode"
+	SendErrorNetwork = "network"
+	SendErrorStatus  = "status"
+)
+
+// DefaultTelemetry Метрики агента о себе, которые отправляются на сервер вместе с остальными.
+var DefaultTelemetry = NewTelemetry()
+
+// Telemetry Метрики агента о себе, копятся между отправками и переносятся в хранилище перед отправкой.
+// Для counter метрик хранятся приращения с прошлого переноса.
+type Telemetry struct {
+	counters map[string]int64
+	gauges   map[string]float64
+	mutex    sync.Mutex
+}
+
+func NewTelemetry() *Telemetry {
+	return &Telemetry{
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+	}
+}
+
+func (t *Telemetry) add(name string, labels model.Labels, delta int64) {
+	t.counters[model.LabeledName(name, labels)] += delta
+}
+
+func (t *Telemetry) set(name string, labels model.Labels, value float64) {
+	t.gauges[model.LabeledName(name, labels)] = value
+}
+
+// Успешная отправка пачки: размер до и после сжатия и длительность запроса
+func (t *Telemetry) Sent(transport string, rawBytes, compressedBytes int, d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	labels := model.Labels{"transport": transport}
+	t.add("AgentBatchesSent", labels, 1)
+	t.add("AgentBytesRaw", labels, int64(rawBytes))
+	t.add("AgentBytesCompressed", labels, int64(compressedBytes))
+	t.set("AgentSendSeconds", labels, d.Seconds())
+}
+
+// Неудачная отправка пачки, kind - один из SendError*
+func (t *Telemetry) SendFailed(kind string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.add("AgentSendErrors", model.Labels{"type": kind}, 1)
+}
+
+// Метрики, которые сервер отклонил по лимитам при частичной записи пачки
+func (t *Telemetry) Rejected(count int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.add("AgentSeriesRejected", nil, int64(count))
+}
+
+// Длительность и результат одного сбора метрик сборщиком
+func (t *Telemetry) Collected(collector string, d time.Duration, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	labels := model.Labels{"collector": collector}
+	t.set("AgentCollectSeconds", labels, d.Seconds())
+	if err != nil {
+		t.add("AgentCollectErrors", labels, 1)
+	}
+}
+
+// Количество пачек, ожидающих отправки
+func (t *Telemetry) QueueDepth(depth int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.set("AgentQueueDepth", nil, float64(depth))
+}
+
+// Перенести накопленные метрики в хранилище агента
+func (t *Telemetry) Flush(ctx context.Context, s storage.Storage) error {
+	t.mutex.Lock()
+	metricsData := make(model.MetricsData, 0, len(t.counters)+len(t.gauges))
+	for name, delta := range t.counters {
+		delta := delta
+		metricsData = append(metricsData, model.MetricData{Name: name, Kind: model.Counter, Delta: &delta})
+	}
+	for name, value := range t.gauges {
+		value := value
+		metricsData = append(metricsData, model.MetricData{Name: name, Kind: model.Gauge, Value: &value})
+	}
+	t.counters = make(map[string]int64)
+	t.mutex.Unlock()
+
+	if len(metricsData) == 0 {
+		return nil
+	}
+
+	return s.UpdateMetrics(ctx, metricsData)
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/agent/config"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func TestTelemetryFlush(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	telemetry := NewTelemetry()
+
+	telemetry.Sent("http", 100, 40, time.Second)
+	telemetry.Sent("http", 50, 20, 2*time.Second)
+	telemetry.SendFailed(SendErrorNetwork)
+	telemetry.Collected("cpu", time.Second, errors.New("no cpu"))
+	telemetry.QueueDepth(3)
+	require.NoError(t, telemetry.Flush(ctx, s))
+
+	// после переноса приращения обнуляются, gauge метрики сохраняют последнее значение
+	telemetry.Sent("http", 10, 5, time.Second)
+	require.NoError(t, telemetry.Flush(ctx, s))
+
+	tests := []struct {
+		name string
+		want int64
+	}{
+		{name: `AgentBatchesSent{transport="http"}`, want: 3},
+		{name: `AgentBytesRaw{transport="http"}`, want: 160},
+		{name: `AgentBytesCompressed{transport="http"}`, want: 65},
+		{name: `AgentSendErrors{type="network"}`, want: 1},
+		{name: `AgentCollectErrors{collector="cpu"}`, want: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := s.GetCounterMetric(ctx, test.name)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, m.Value)
+		})
+	}
+
+	latency, err := s.GetGaugeMetric(ctx, `AgentSendSeconds{transport="http"}`)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, latency.Value)
+
+	depth, err := s.GetGaugeMetric(ctx, "AgentQueueDepth")
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, depth.Value)
+}
+
+func TestSendRequestTelemetry(t *testing.T) {
+	defaultTelemetry := DefaultTelemetry
+	defer func() { DefaultTelemetry = defaultTelemetry }()
+	DefaultTelemetry = NewTelemetry()
+
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		if status == http.StatusMultiStatus {
+			w.Write([]byte(`{"ok": false, "rejected": ["gauge/a", "gauge/b"], "written": 1}`))
+		}
+	}))
+	defer ts.Close()
+
+	client := resty.New().SetBaseURL(ts.URL)
+	value := 1.0
+	data := model.MetricsData{{Name: "Alloc", Kind: model.Gauge, Value: &value}}
+	ctx := context.Background()
+
+	require.NoError(t, sendRequest(ctx, &config.Config{}, data, client))
+	status = http.StatusInternalServerError
+	require.ErrorIs(t, sendRequest(ctx, &config.Config{}, data, client), ErrNotDelivered)
+	// частичная запись - доставленная пачка
+	status = http.StatusMultiStatus
+	require.NoError(t, sendRequest(ctx, &config.Config{}, data, client))
+
+	s := storage.NewMemStorage()
+	require.NoError(t, DefaultTelemetry.Flush(ctx, s))
+
+	sent, err := s.GetCounterMetric(ctx, `AgentBatchesSent{transport="http"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), sent.Value)
+
+	rejected, err := s.GetCounterMetric(ctx, "AgentSeriesRejected")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rejected.Value)
+
+	failed, err := s.GetCounterMetric(ctx, `AgentSendErrors{type="status"}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), failed.Value)
+
+	compressed, err := s.GetCounterMetric(ctx, `AgentBytesCompressed{transport="http"}`)
+	require.NoError(t, err)
+	assert.Positive(t, compressed.Value)
+}
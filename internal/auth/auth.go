@@ -1,34 +1,54 @@
 package auth
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"hash"
+	"sync/atomic"
 )
 
 var (
-	key []byte
+	// ключ меняется при перечитывании конфига, поэтому хранится атомарно
+	key atomic.Pointer[[]byte]
 )
 
 func Enabled() bool {
-	return key != nil
+	return key.Load() != nil
 }
 
+// Задать ключ подписи, пустой ключ отключает подпись
 func Init(newKey string) {
-	key = []byte(newKey)
+	if newKey == "" {
+		key.Store(nil)
+		return
+	}
+
+	keyBytes := []byte(newKey)
+	key.Store(&keyBytes)
 }
 
+// HMAC-SHA256 данных текущим ключом
 func Sign(data []byte) []byte {
-	haser := GetHasher()
-	return haser.Sum(data)
+	hasher := GetHasher()
+	hasher.Write(data)
+	return hasher.Sum(nil)
 }
 
 func Check(originalSign []byte, data []byte) (bool, error) {
-	hasher := GetHasher()
-	sign := hasher.Sum(data)
-	return hmac.Equal(originalSign, sign), nil
+	return hmac.Equal(originalSign, Sign(data)), nil
+}
+
+// Подпись в старом формате: данные и за ними HMAC пустой строки. Она не зависит от данных и ничего
+// не удостоверяет, поэтому не принимается, а распознается только чтобы понятно отказать старым агентам
+func IsLegacy(sign, data []byte) bool {
+	return len(sign) == len(data)+sha256.Size && bytes.Equal(sign[:len(data)], data)
 }
 
 func GetHasher() hash.Hash {
-	return hmac.New(sha256.New, key)
+	var keyBytes []byte
+	if k := key.Load(); k != nil {
+		keyBytes = *k
+	}
+	return hmac.New(sha256.New, keyBytes)
 }
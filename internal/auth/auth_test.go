@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,3 +16,46 @@ func TestSignCheck(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, res)
 }
+
+func TestSignIsHMAC(t *testing.T) {
+	defer Init("")
+	Init("test key")
+
+	data := []byte("test string")
+	mac := hmac.New(sha256.New, []byte("test key"))
+	mac.Write(data)
+	assert.Equal(t, mac.Sum(nil), Sign(data))
+
+	// подпись не зависит от длины данных и не содержит их
+	assert.Len(t, Sign(append(data, data...)), sha256.Size)
+}
+
+func TestInit(t *testing.T) {
+	defer Init("")
+
+	Init("test key")
+	assert.True(t, Enabled())
+	sign := Sign([]byte("test string"))
+
+	Init("other key")
+	res, err := Check(sign, []byte("test string"))
+	assert.NoError(t, err)
+	assert.False(t, res)
+
+	Init("")
+	assert.False(t, Enabled())
+}
+
+func TestIsLegacy(t *testing.T) {
+	defer Init("")
+	Init("test key")
+
+	data := []byte("test string")
+	legacy := GetHasher().Sum(data)
+
+	assert.True(t, IsLegacy(legacy, data))
+	assert.False(t, IsLegacy(Sign(data), data))
+	res, err := Check(legacy, data)
+	assert.NoError(t, err)
+	assert.False(t, res)
+}
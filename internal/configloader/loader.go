@@ -0,0 +1,271 @@
+package configloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/caarlos0/env/v10"
+	"gopkg.in/yaml.v3"
+
+	"github.com/smakimka/mtrcscollector/internal/reload"
+)
+
+// Теги полей конфига, которые понимает загрузчик:
+//
+//	flag:"a,addr"     имена флагов поля
+//	default:"..."     значение по умолчанию
+//	usage:"..."       описание флага
+//	loader:"path"     поле с путем до файла конфига
+//	loader:"secret"   значение скрывается при выводе конфига
+//
+// Переменные окружения и ключи файла берутся из тегов env и json.
+
+var ErrNotStruct = errors.New("config must be a pointer to a struct")
+
+// Loader Загрузчик конфига. Значения применяются в одном порядке для всех полей:
+// по умолчанию, из файла, из переменных окружения, из явно заданных флагов.
+type Loader struct {
+	configType reflect.Type
+	fields     []*field
+	pathField  *field
+}
+
+// field Поле конфига, которое можно задать флагом или значением по умолчанию.
+type field struct {
+	index    []int
+	typ      reflect.Type
+	env      string
+	defValue string
+	value    string
+	set      bool
+}
+
+func (f *field) String() string {
+	if f == nil {
+		return ""
+	}
+	if f.set {
+		return f.value
+	}
+	return f.defValue
+}
+
+func (f *field) Set(value string) error {
+	if err := setValue(reflect.New(f.typ).Elem(), value); err != nil {
+		return err
+	}
+
+	f.value = value
+	f.set = true
+	return nil
+}
+
+func (f *field) IsBoolFlag() bool {
+	return f.typ.Kind() == reflect.Bool
+}
+
+// Создать загрузчик для типа cfg и зарегистрировать флаги его полей в fs
+func New(cfg any, fs *flag.FlagSet) *Loader {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		panic(ErrNotStruct)
+	}
+
+	t := v.Elem().Type()
+	l := &Loader{configType: t}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		f := &field{
+			index:    sf.Index,
+			typ:      sf.Type,
+			env:      sf.Tag.Get("env"),
+			defValue: sf.Tag.Get("default"),
+		}
+		if sf.Tag.Get("loader") == "path" {
+			l.pathField = f
+		}
+
+		names := sf.Tag.Get("flag")
+		if names == "" && f.defValue == "" && l.pathField != f {
+			continue
+		}
+		if err := setValue(reflect.New(sf.Type).Elem(), f.defValue); err != nil {
+			panic(fmt.Sprintf("config field %s: %v", sf.Name, err))
+		}
+
+		if names != "" {
+			for _, name := range strings.Split(names, ",") {
+				fs.Var(f, strings.TrimSpace(name), sf.Tag.Get("usage"))
+			}
+		}
+		l.fields = append(l.fields, f)
+	}
+
+	return l
+}
+
+// Заполнить cfg и проверить его, если у конфига есть метод Validate.
+// Флаги к этому моменту должны быть разобраны
+func (l *Loader) Load(cfg any) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Type() != l.configType {
+		return ErrNotStruct
+	}
+	v = v.Elem()
+
+	for _, f := range l.fields {
+		if err := setValue(v.FieldByIndex(f.index), f.defValue); err != nil {
+			return err
+		}
+	}
+
+	if path := l.configPath(); path != "" {
+		if err := readFile(path, cfg); err != nil {
+			return fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	if err := env.Parse(cfg); err != nil {
+		return err
+	}
+
+	for _, f := range l.fields {
+		if !f.set {
+			continue
+		}
+		if err := setValue(v.FieldByIndex(f.index), f.value); err != nil {
+			return err
+		}
+	}
+
+	if l.pathField != nil {
+		v.FieldByIndex(l.pathField.index).SetString(l.configPath())
+	}
+
+	if validator, ok := cfg.(interface{ Validate() error }); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// Путь до файла конфига: из флага, из переменной окружения или по умолчанию
+func (l *Loader) configPath() string {
+	if l.pathField == nil {
+		return ""
+	}
+	if l.pathField.set {
+		return l.pathField.value
+	}
+	if path, ok := os.LookupEnv(l.pathField.env); ok && l.pathField.env != "" {
+		return path
+	}
+	return l.pathField.defValue
+}
+
+// Прочитать json или yaml файл поверх уже заданных значений
+func readFile(path string, cfg any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// yaml сводится к json, чтобы ключи файла в обоих форматах брались из тегов json
+		var raw any
+		if err = yaml.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(raw); err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, cfg)
+}
+
+func setValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		if value == "" {
+			v.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		if value == "" {
+			v.SetInt(0)
+			return nil
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(i))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported config field type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported config field type %s", v.Type())
+	}
+
+	return nil
+}
+
+// Итоговый конфиг в json, секреты скрыты
+func Dump(cfg any) ([]byte, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, ErrNotStruct
+	}
+
+	redacted := reflect.New(v.Elem().Type()).Elem()
+	redacted.Set(v.Elem())
+
+	t := redacted.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("loader") != "secret" {
+			continue
+		}
+
+		f := redacted.Field(i)
+		if f.Kind() == reflect.String && f.String() != "" {
+			f.SetString(reload.Redacted)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(redacted.Interface()); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
@@ -0,0 +1,117 @@
+package configloader
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/reload"
+)
+
+type testConfig struct {
+	Addr     string   `env:"TEST_ADDR" json:"addr" flag:"a" default:"localhost:8080"`
+	Interval int      `env:"TEST_INTERVAL" json:"interval" flag:"i" default:"10"`
+	Restore  bool     `env:"TEST_RESTORE" json:"restore" flag:"r" default:"true"`
+	Hooks    []string `env:"TEST_HOOKS" json:"hooks" flag:"hooks"`
+	Key      string   `env:"TEST_KEY" json:"key" flag:"k" loader:"secret"`
+	Path     string   `env:"TEST_CONFIG" json:"-" flag:"c,config" loader:"path"`
+}
+
+func (c *testConfig) Validate() error {
+	return errors.Join(Positive("interval", c.Interval), Required("addr", c.Addr))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"addr": "file:1", "interval": 20, "restore": false}`), 0o600))
+	yamlPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte("addr: yaml:1\nhooks: [a, b]\n"), 0o600))
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		want    testConfig
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: testConfig{Addr: "localhost:8080", Interval: 10, Restore: true},
+		},
+		{
+			name: "file",
+			args: []string{"-config", jsonPath},
+			want: testConfig{Addr: "file:1", Interval: 20, Restore: false, Path: jsonPath},
+		},
+		{
+			name: "yaml file from env",
+			env:  map[string]string{"TEST_CONFIG": yamlPath},
+			want: testConfig{Addr: "yaml:1", Interval: 10, Restore: true, Hooks: []string{"a", "b"}, Path: yamlPath},
+		},
+		{
+			name: "env over file",
+			args: []string{"-c", jsonPath},
+			env:  map[string]string{"TEST_RESTORE": "true", "TEST_INTERVAL": "30"},
+			want: testConfig{Addr: "file:1", Interval: 30, Restore: true, Path: jsonPath},
+		},
+		{
+			name: "flags over env",
+			args: []string{"-c", jsonPath, "-i", "10", "-r", "-hooks", "x, y"},
+			env:  map[string]string{"TEST_INTERVAL": "30"},
+			want: testConfig{Addr: "file:1", Interval: 10, Restore: true, Hooks: []string{"x", "y"}, Path: jsonPath},
+		},
+		{
+			name:    "validation",
+			args:    []string{"-i", "0", "-a", ""},
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			args:    []string{"-c", filepath.Join(dir, "missing.json")},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			l := New(&testConfig{}, fs)
+			require.NoError(t, fs.Parse(test.args))
+
+			var cfg testConfig
+			err := l.Load(&cfg)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, cfg)
+		})
+	}
+}
+
+func TestFlagParseError(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	New(&testConfig{}, fs)
+	assert.Error(t, fs.Parse([]string{"-i", "ten"}))
+}
+
+func TestDump(t *testing.T) {
+	data, err := Dump(&testConfig{Addr: "localhost:8080", Key: "secret"})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), reload.Redacted)
+	assert.NotContains(t, string(data), "secret")
+	assert.NotContains(t, string(data), "Path")
+}
@@ -0,0 +1,54 @@
+package configloader
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// Проверки значений конфига для Validate. Возвращают nil, если значение подходит,
+// так что их можно сразу собирать через errors.Join
+
+func Required(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s: must be set", name)
+	}
+	return nil
+}
+
+func Positive(name string, value int) error {
+	if value <= 0 {
+		return fmt.Errorf("%s: must be positive, got %d", name, value)
+	}
+	return nil
+}
+
+func NonNegative(name string, value int) error {
+	if value < 0 {
+		return fmt.Errorf("%s: must not be negative, got %d", name, value)
+	}
+	return nil
+}
+
+// Подсеть в формате CIDR, пустое значение допустимо
+func CIDR(name, value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(value); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Файл, который можно прочитать, пустой путь допустим
+func Readable(name, path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return f.Close()
+}
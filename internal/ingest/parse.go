@@ -0,0 +1,32 @@
+// Модуль ingest разбирает запросы обновления метрик, общие для сервера и приема метрик агентом
+package ingest
+
+import (
+	"strconv"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+// Метрика из пути запроса /update/{metricKind}/{metricName}/{metricValue}
+func ParseURLMetric(kind, name, value string) (model.MetricData, error) {
+	m := model.MetricData{Name: name, Kind: kind}
+
+	switch kind {
+	case model.Gauge:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return m, err
+		}
+		m.Value = &v
+	case model.Counter:
+		delta, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return m, err
+		}
+		m.Delta = &delta
+	default:
+		return m, ErrWrongMetricKind
+	}
+
+	return m, nil
+}
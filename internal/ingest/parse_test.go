@@ -0,0 +1,39 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+func TestParseURLMetric(t *testing.T) {
+	value, delta := 1.5, int64(3)
+	tests := []struct {
+		name    string
+		kind    string
+		value   string
+		want    model.MetricData
+		wantErr bool
+	}{
+		{name: "gauge", kind: model.Gauge, value: "1.5", want: model.MetricData{Name: "m", Kind: model.Gauge, Value: &value}},
+		{name: "counter", kind: model.Counter, value: "3", want: model.MetricData{Name: "m", Kind: model.Counter, Delta: &delta}},
+		{name: "fractional counter", kind: model.Counter, value: "1.5", wantErr: true},
+		{name: "bad gauge", kind: model.Gauge, value: "none", wantErr: true},
+		{name: "unknown kind", kind: "histogram", value: "1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, err := ParseURLMetric(test.kind, "m", test.value)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, m)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package ingest
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Параметр пути запроса без экранирования. Если в пути есть символы, которые нельзя передать как есть
+// (например / в метке DiskUsed{mount="/"} передается как %2F), chi разбирает исходный путь
+// и отдает параметр экранированным
+func URLParam(r *http.Request, key string) string {
+	value := chi.URLParam(r, key)
+	if r.URL.RawPath == "" {
+		return value
+	}
+
+	unescaped, err := url.PathUnescape(value)
+	if err != nil {
+		return value
+	}
+	return unescaped
+}
@@ -0,0 +1,38 @@
+package ingest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestURLParam(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "plain", path: "/value/Alloc", want: "Alloc"},
+		{name: "labels", path: "/value/NetBytesSent%7Biface=%22eth0%22%7D", want: `NetBytesSent{iface="eth0"}`},
+		{name: "escaped slash", path: "/value/DiskUsed%7Bmount=%22%2F%22%7D", want: `DiskUsed{mount="/"}`},
+		{name: "escaped percent", path: "/value/Used%2525%2F", want: "Used%25/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got string
+			r := chi.NewRouter()
+			r.Get("/value/{metricName}", func(w http.ResponseWriter, r *http.Request) {
+				got = URLParam(r, "metricName")
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
@@ -3,6 +3,8 @@ package logger
 import (
 	"errors"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -10,6 +12,9 @@ import (
 
 var Log zerolog.Logger = zerolog.New(os.Stdout)
 
+// логгер подменяется один раз, дальше уровень меняется глобально, чтобы не гоняться с пишущими горутинами
+var initLog sync.Once
+
 type Level int
 
 const (
@@ -22,12 +27,28 @@ var ErrNoSuchLevel = errors.New("no such logging level")
 func SetLevel(lvl Level) error {
 	switch lvl {
 	case Debug:
-		Log = log.Level(zerolog.DebugLevel)
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	case Info:
-		Log = log.Level(zerolog.InfoLevel)
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	default:
 		return ErrNoSuchLevel
 	}
 
+	initLog.Do(func() {
+		Log = log.Logger
+	})
+
 	return nil
 }
+
+// Уровень логирования по имени из конфига
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return Debug, nil
+	case "info", "":
+		return Info, nil
+	default:
+		return 0, ErrNoSuchLevel
+	}
+}
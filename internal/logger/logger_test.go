@@ -10,3 +10,26 @@ func TestServer(t *testing.T) {
 	err := SetLevel(256)
 	assert.Equal(t, err, ErrNoSuchLevel)
 }
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name string
+		want Level
+		err  error
+	}{
+		{name: "debug", want: Debug},
+		{name: "INFO", want: Info},
+		{name: "", want: Info},
+		{name: "trace", err: ErrNoSuchLevel},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lvl, err := ParseLevel(test.name)
+			assert.Equal(t, test.err, err)
+			if err == nil {
+				assert.Equal(t, test.want, lvl)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels Метки метрики, кодируются в имя в виде Name{key="value",...}.
+// В путях запросов /value/... и /update/... такое имя передается экранированным, например / как %2F.
+type Labels map[string]string
+
+// Имя метрики с метками, метки сортируются по ключу чтобы имя было стабильным
+func LabeledName(name string, labels Labels) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s=%q", key, labels[key])
+	}
+
+	return fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+}
+
+// Разбор имени метрики на базовое имя и метки, обратная операция к LabeledName
+func ParseLabeledName(fullName string) (string, Labels) {
+	start := strings.IndexByte(fullName, '{')
+	if start < 0 || !strings.HasSuffix(fullName, "}") {
+		return fullName, nil
+	}
+
+	name := fullName[:start]
+	labels := Labels{}
+	rest := fullName[start+1 : len(fullName)-1]
+
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 || eq+1 >= len(rest) || rest[eq+1] != '"' {
+			return fullName, nil
+		}
+		key := rest[:eq]
+
+		end := eq + 2
+		for end < len(rest) && rest[end] != '"' {
+			if rest[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(rest) {
+			return fullName, nil
+		}
+
+		var value string
+		if _, err := fmt.Sscanf(rest[eq+1:end+1], "%q", &value); err != nil {
+			return fullName, nil
+		}
+		labels[key] = value
+
+		rest = strings.TrimPrefix(rest[end+1:], ",")
+	}
+
+	return name, labels
+}
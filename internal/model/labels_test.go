@@ -0,0 +1,64 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabeledName(t *testing.T) {
+	tests := []struct {
+		name     string
+		metric   string
+		labels   Labels
+		wantName string
+	}{
+		{
+			name:     "no labels",
+			metric:   "Alloc",
+			labels:   nil,
+			wantName: "Alloc",
+		},
+		{
+			name:     "single label",
+			metric:   "DiskUsed",
+			labels:   Labels{"mount": "/"},
+			wantName: `DiskUsed{mount="/"}`,
+		},
+		{
+			name:     "sorted labels",
+			metric:   "NetBytesSent",
+			labels:   Labels{"zone": "a", "iface": "eth0"},
+			wantName: `NetBytesSent{iface="eth0",zone="a"}`,
+		},
+		{
+			name:     "escaped value",
+			metric:   "ProcessRSS",
+			labels:   Labels{"cmd": `a "b",c`},
+			wantName: `ProcessRSS{cmd="a \"b\",c"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fullName := LabeledName(test.metric, test.labels)
+			assert.Equal(t, test.wantName, fullName)
+
+			name, labels := ParseLabeledName(fullName)
+			assert.Equal(t, test.metric, name)
+			if len(test.labels) == 0 {
+				assert.Empty(t, labels)
+			} else {
+				assert.Equal(t, test.labels, labels)
+			}
+		})
+	}
+}
+
+func TestParseLabeledNameMalformed(t *testing.T) {
+	for _, fullName := range []string{`Name{`, `Name{key}`, `Name{key="value}`} {
+		name, labels := ParseLabeledName(fullName)
+		assert.Equal(t, fullName, name)
+		assert.Nil(t, labels)
+	}
+}
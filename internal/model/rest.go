@@ -8,11 +8,17 @@ import (
 var ErrMissingFields = errors.New("missing some of required fields")
 var ErrWrongMetricKind = errors.New("wrong metric kind")
 
+// Заголовки (и ключи метаданных grpc), которыми агент сообщает о себе серверу
+const (
+	AgentVersionHeader   = "X-Agent-Version"
+	ReportIntervalHeader = "X-Report-Interval"
+)
+
 type MetricData struct {
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Name  string   `json:"id"`
-	Kind  string   `json:"type"`
+	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
+	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	Name  string   `json:"id"`              // имя метрики
+	Kind  string   `json:"type"`            // параметр, принимающий значение gauge или counter
 }
 
 func (m *MetricData) Bind(r *http.Request) error {
@@ -40,6 +46,13 @@ func (d MetricsData) Bind(r *http.Request) error {
 }
 
 type Response struct {
-	Detail string `json:"detail,omitempty"`
-	Ok     bool   `json:"ok"`
+	Detail   string   `json:"detail,omitempty"`
+	Rejected []string `json:"rejected,omitempty"`
+	Written  int      `json:"written,omitempty"`
+	Ok       bool     `json:"ok"`
+}
+
+// DeleteResponse Ответ на удаление метрик по префиксу.
+type DeleteResponse struct {
+	Deleted int64 `json:"deleted"`
 }
@@ -0,0 +1,70 @@
+package reload
+
+import (
+	"fmt"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+)
+
+// Redacted Значение секрета в логах.
+const Redacted = "<redacted>"
+
+// Change Настройка, поменявшаяся при перечитывании конфига.
+type Change struct {
+	Name string
+	Old  string
+	New  string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Name, c.Old, c.New)
+}
+
+// Changes Список поменявшихся настроек.
+type Changes []Change
+
+// Добавить настройку в список, если ее значение поменялось
+func (c *Changes) Add(name string, oldValue, newValue any) {
+	oldString, newString := fmt.Sprint(oldValue), fmt.Sprint(newValue)
+	if oldString == newString {
+		return
+	}
+	*c = append(*c, Change{Name: name, Old: oldString, New: newString})
+}
+
+// Добавить секрет в список, значения в список не попадают
+func (c *Changes) AddSecret(name string, oldValue, newValue string) {
+	if oldValue == newValue {
+		return
+	}
+	*c = append(*c, Change{Name: name, Old: redact(oldValue), New: redact(newValue)})
+}
+
+// Поменялась ли настройка
+func (c Changes) Has(name string) bool {
+	for _, change := range c {
+		if change.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Записать изменения в лог
+func (c Changes) Log() {
+	if len(c) == 0 {
+		logger.Log.Info().Msg("config reloaded, nothing changed")
+		return
+	}
+
+	for _, change := range c {
+		logger.Log.Info().Msg(fmt.Sprintf("config reloaded, %s", change))
+	}
+}
+
+func redact(value string) string {
+	if value == "" {
+		return `""`
+	}
+	return Redacted
+}
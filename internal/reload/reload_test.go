@@ -0,0 +1,23 @@
+package reload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	var changes Changes
+	changes.Add("store_interval", 300, 300)
+	changes.Add("log_level", "info", "debug")
+	changes.AddSecret("key", "", "secret")
+	changes.AddSecret("other_key", "secret", "secret")
+
+	assert.Equal(t, Changes{
+		{Name: "log_level", Old: "info", New: "debug"},
+		{Name: "key", Old: `""`, New: Redacted},
+	}, changes)
+	assert.True(t, changes.Has("key"))
+	assert.False(t, changes.Has("store_interval"))
+	assert.Equal(t, "log_level: info -> debug", changes[0].String())
+}
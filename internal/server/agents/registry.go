@@ -0,0 +1,155 @@
+// Модуль agents отслеживает агентов, которые присылают метрики на сервер
+package agents
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/subnet"
+)
+
+// DefaultReportInterval Интервал отправки, если агент его не передал (значение по умолчанию у агента).
+const DefaultReportInterval = 10 * time.Second
+
+// Transport Способ, которым агент присылает метрики.
+type Transport string
+
+const (
+	HTTP Transport = "http"
+	GRPC Transport = "grpc"
+)
+
+// Report Сведения об одной отправке метрик агентом.
+type Report struct {
+	IP             string
+	Version        string
+	Transport      Transport
+	ReportInterval time.Duration
+	MetricsCount   int
+}
+
+// Agent Агент, который присылал метрики, MetricsCount - количество метрик в последней отправке, ReportInterval в секундах.
+type Agent struct {
+	LastSeen       time.Time `json:"last_seen"`
+	IP             string    `json:"ip"`
+	Version        string    `json:"version"`
+	Transport      Transport `json:"transport"`
+	ReportInterval int       `json:"report_interval"`
+	MetricsCount   int       `json:"metrics_count"`
+	Reports        int64     `json:"reports"`
+	Stale          bool      `json:"stale"`
+}
+
+type agentState struct {
+	agent          Agent
+	reportInterval time.Duration
+}
+
+// Registry Агенты по IP, агент считается пропавшим, если не присылал метрики staleReports интервалов отправки.
+// Агенты, молчащие дольше ttl, забываются, а при maxAgents известных агентах новый вытесняет самого давно молчащего,
+// чтобы реестр не рос без предела от клиентов с разных адресов.
+type Registry struct {
+	agents       map[string]*agentState
+	staleReports int
+	ttl          time.Duration
+	maxAgents    int
+	mutex        sync.RWMutex
+}
+
+func NewRegistry(staleReports int, ttl time.Duration, maxAgents int) *Registry {
+	return &Registry{
+		agents:       make(map[string]*agentState),
+		staleReports: staleReports,
+		ttl:          ttl,
+		maxAgents:    maxAgents,
+	}
+}
+
+// Учесть отправку метрик агентом
+func (r *Registry) Seen(report Report, now time.Time) {
+	if report.IP == "" {
+		return
+	}
+	if report.ReportInterval <= 0 {
+		report.ReportInterval = DefaultReportInterval
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	state, ok := r.agents[report.IP]
+	if !ok {
+		r.makeRoom(now)
+		state = &agentState{}
+		r.agents[report.IP] = state
+	}
+
+	state.reportInterval = report.ReportInterval
+	state.agent.IP = report.IP
+	state.agent.Version = report.Version
+	state.agent.Transport = report.Transport
+	state.agent.ReportInterval = int(report.ReportInterval.Seconds())
+	state.agent.MetricsCount = report.MetricsCount
+	state.agent.LastSeen = now
+	state.agent.Reports++
+}
+
+// Забыть агентов, молчащих дольше ttl, и если места все равно нет, самого давно молчащего
+func (r *Registry) makeRoom(now time.Time) {
+	var oldest string
+	for ip, state := range r.agents {
+		if r.expired(state, now) {
+			delete(r.agents, ip)
+			continue
+		}
+		if oldest == "" || state.agent.LastSeen.Before(r.agents[oldest].agent.LastSeen) {
+			oldest = ip
+		}
+	}
+
+	if len(r.agents) >= r.maxAgents && oldest != "" {
+		delete(r.agents, oldest)
+	}
+}
+
+func (r *Registry) expired(state *agentState, now time.Time) bool {
+	return now.Sub(state.agent.LastSeen) > r.ttl
+}
+
+// Все известные агенты на момент now, отсортированные по IP, без молчащих дольше ttl
+func (r *Registry) List(now time.Time) []Agent {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	agents := make([]Agent, 0, len(r.agents))
+	for _, state := range r.agents {
+		if r.expired(state, now) {
+			continue
+		}
+		agent := state.agent
+		agent.Stale = now.Sub(agent.LastSeen) > time.Duration(r.staleReports)*state.reportInterval
+		agents = append(agents, agent)
+	}
+
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].IP < agents[j].IP
+	})
+
+	return agents
+}
+
+// IP агента из адреса соединения. X-Real-IP его заменяет, только если соединение пришло из доверенной
+// подсети (например, от прокси), иначе клиент мог бы представляться кем угодно
+func AgentIP(realIP, remoteAddr string, trusted *subnet.Trusted) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	if realIP != "" && trusted.Contains(host) {
+		return realIP
+	}
+	return host
+}
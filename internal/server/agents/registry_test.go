@@ -0,0 +1,101 @@
+package agents
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/subnet"
+)
+
+func TestRegistry(t *testing.T) {
+	start := time.Now()
+	r := NewRegistry(3, time.Hour, 10)
+
+	r.Seen(Report{IP: "10.0.0.2", Version: "v1", Transport: GRPC, ReportInterval: 2 * time.Second, MetricsCount: 5}, start)
+	r.Seen(Report{IP: "10.0.0.1", Version: "v1", Transport: HTTP, MetricsCount: 10}, start)
+	r.Seen(Report{IP: "10.0.0.1", Version: "v2", Transport: HTTP, MetricsCount: 12}, start.Add(time.Second))
+	r.Seen(Report{Version: "v1", Transport: HTTP}, start)
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		stale []bool
+	}{
+		{
+			name:  "all fresh",
+			now:   start.Add(time.Second),
+			stale: []bool{false, false},
+		},
+		{
+			name:  "grpc agent missed three reports",
+			now:   start.Add(7 * time.Second),
+			stale: []bool{false, true},
+		},
+		{
+			name:  "http agent missed three default reports",
+			now:   start.Add(32 * time.Second),
+			stale: []bool{true, true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list := r.List(test.now)
+			require.Len(t, list, 2)
+
+			assert.Equal(t, "10.0.0.1", list[0].IP)
+			assert.Equal(t, "v2", list[0].Version)
+			assert.Equal(t, 12, list[0].MetricsCount)
+			assert.Equal(t, int64(2), list[0].Reports)
+			assert.Equal(t, int(DefaultReportInterval.Seconds()), list[0].ReportInterval)
+			assert.Equal(t, "10.0.0.2", list[1].IP)
+			assert.Equal(t, GRPC, list[1].Transport)
+
+			assert.Equal(t, test.stale, []bool{list[0].Stale, list[1].Stale})
+		})
+	}
+}
+
+func TestRegistryEviction(t *testing.T) {
+	start := time.Now()
+	ips := func(list []Agent) []string {
+		result := []string{}
+		for _, agent := range list {
+			result = append(result, agent.IP)
+		}
+		return result
+	}
+
+	r := NewRegistry(3, time.Minute, 2)
+	r.Seen(Report{IP: "10.0.0.1"}, start)
+	r.Seen(Report{IP: "10.0.0.2"}, start.Add(time.Second))
+	r.Seen(Report{IP: "10.0.0.1"}, start.Add(2*time.Second))
+
+	// места нет, вытесняется самый давно молчащий
+	r.Seen(Report{IP: "10.0.0.3"}, start.Add(3*time.Second))
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.3"}, ips(r.List(start.Add(3*time.Second))))
+
+	// молчащие дольше ttl не показываются и забываются при добавлении нового
+	assert.Equal(t, []string{"10.0.0.3"}, ips(r.List(start.Add(63*time.Second))))
+	r.Seen(Report{IP: "10.0.0.4"}, start.Add(2*time.Minute))
+	assert.Equal(t, []string{"10.0.0.4"}, ips(r.List(start.Add(2*time.Minute))))
+	assert.Len(t, r.agents, 1)
+}
+
+func TestAgentIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	trusted := subnet.NewTrusted(proxies)
+
+	assert.Equal(t, "10.0.0.1", AgentIP("10.0.0.1", "127.0.0.1:5000", trusted))
+	assert.Equal(t, "127.0.0.1", AgentIP("", "127.0.0.1:5000", trusted))
+	assert.Equal(t, "bufconn", AgentIP("", "bufconn", trusted))
+
+	// заголовок от соединения не из доверенной подсети игнорируется
+	assert.Equal(t, "192.168.0.1", AgentIP("10.0.0.1", "192.168.0.1:5000", trusted))
+	assert.Equal(t, "127.0.0.1", AgentIP("10.0.0.1", "127.0.0.1:5000", subnet.NewTrusted(nil)))
+}
@@ -0,0 +1,219 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// State Состояние алерта.
+type State string
+
+const (
+	Inactive State = "inactive"
+	Pending  State = "pending"
+	Firing   State = "firing"
+	Resolved State = "resolved"
+)
+
+// Alert Текущее состояние правила.
+type Alert struct {
+	Value       *float64   `json:"value,omitempty"`
+	ActiveSince *time.Time `json:"active_since,omitempty"`
+	FiredAt     *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	Rule        string     `json:"rule"`
+	Expr        string     `json:"expr"`
+	State       State      `json:"state"`
+}
+
+// ruleState Состояние правила между вычислениями.
+type ruleState struct {
+	rule  Rule
+	alert Alert
+
+	// для Unchanged - последнее значение и время его изменения
+	lastValue  *float64
+	lastChange time.Time
+}
+
+// Engine Периодически вычисляет правила по хранилищу и отслеживает состояния алертов.
+type Engine struct {
+	s         storage.Storage
+	states    []*ruleState
+	notifiers []Notifier
+	mutex     sync.RWMutex
+}
+
+func NewEngine(s storage.Storage, rules []Rule) *Engine {
+	e := &Engine{s: s}
+	for _, rule := range rules {
+		e.states = append(e.states, &ruleState{
+			rule:  rule,
+			alert: Alert{Rule: rule.Name, Expr: rule.Expr, State: Inactive},
+		})
+	}
+
+	return e
+}
+
+// Добавить получателя изменений состояния алертов
+func (e *Engine) AddNotifier(n Notifier) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.notifiers = append(e.notifiers, n)
+}
+
+// Вычисление правил раз в interval до отмены контекста
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := e.Evaluate(ctx, now); err != nil {
+				logger.Log.Err(err).Msg("error evaluating alert rules")
+			}
+		}
+	}
+}
+
+// Вычисление всех правил на момент now, возвращает алерты, которые перешли в firing или resolved
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) ([]Alert, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var changed []Alert
+	var errs []error
+
+	for _, rs := range e.states {
+		value, err := e.getValue(ctx, rs.rule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rs.rule.Name, err))
+			continue
+		}
+
+		active, since := rs.check(value, now)
+		if rs.transition(active, since, value, now) {
+			logger.Log.Info().
+				Str("rule", rs.rule.Name).
+				Str("state", string(rs.alert.State)).
+				Msg("alert state changed")
+			changed = append(changed, rs.alert)
+
+			for _, n := range e.notifiers {
+				n.Notify(rs.alert)
+			}
+		}
+	}
+
+	return changed, errors.Join(errs...)
+}
+
+// Значение метрики правила, nil если метрики нет
+func (e *Engine) getValue(ctx context.Context, rule Rule) (*float64, error) {
+	var value float64
+
+	switch rule.Kind {
+	case model.Gauge:
+		m, err := e.s.GetGaugeMetric(ctx, rule.Metric)
+		if errors.Is(err, storage.ErrNoSuchMetric) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value = m.Value
+	case model.Counter:
+		m, err := e.s.GetCounterMetric(ctx, rule.Metric)
+		if errors.Is(err, storage.ErrNoSuchMetric) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value = float64(m.Value)
+	}
+
+	return &value, nil
+}
+
+// Выполняется ли условие правила и с какого момента
+func (rs *ruleState) check(value *float64, now time.Time) (bool, time.Time) {
+	switch rs.rule.Condition {
+	case Absent:
+		return value == nil, now
+	case Unchanged:
+		// для counter отсутствие роста означает то же значение, сброс счетчика считается изменением
+		if value != nil && (rs.lastValue == nil || *rs.lastValue != *value) {
+			rs.lastChange = now
+		} else if rs.lastChange.IsZero() {
+			rs.lastChange = now
+		}
+		if value != nil {
+			rs.lastValue = value
+		}
+		return rs.lastChange.Before(now), rs.lastChange
+	default:
+		return value != nil && operators[rs.rule.Operator](*value, rs.rule.Value), now
+	}
+}
+
+// Переход в следующее состояние, возвращает true при переходе в firing или resolved
+func (rs *ruleState) transition(active bool, since time.Time, value *float64, now time.Time) bool {
+	alert := &rs.alert
+	alert.Value = value
+
+	if !active {
+		switch alert.State {
+		case Firing:
+			alert.State = Resolved
+			alert.ResolvedAt = &now
+			return true
+		case Pending:
+			alert.State = Inactive
+			alert.ActiveSince = nil
+		}
+		return false
+	}
+
+	if alert.State == Inactive || alert.State == Resolved {
+		alert.State = Pending
+		alert.ActiveSince = &since
+		alert.FiredAt = nil
+		alert.ResolvedAt = nil
+	}
+
+	if alert.State == Pending && now.Sub(*alert.ActiveSince) >= rs.rule.For {
+		alert.State = Firing
+		alert.FiredAt = &now
+		return true
+	}
+
+	return false
+}
+
+// Алерты в состоянии pending и firing
+func (e *Engine) Active() []Alert {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	alerts := []Alert{}
+	for _, rs := range e.states {
+		if rs.alert.State == Pending || rs.alert.State == Firing {
+			alerts = append(alerts, rs.alert)
+		}
+	}
+
+	return alerts
+}
@@ -0,0 +1,144 @@
+package alerts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func mustParseRule(t *testing.T, name, expr string) Rule {
+	rule, err := ParseRule(name, expr)
+	require.NoError(t, err)
+	return rule
+}
+
+func TestEngineThreshold(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	e := NewEngine(s, []Rule{mustParseRule(t, "high heap", "gauge HeapAlloc > 100 for 1m")})
+	start := time.Now()
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "HeapAlloc", Value: 200}))
+
+	changed, err := e.Evaluate(ctx, start)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	require.Len(t, e.Active(), 1)
+	assert.Equal(t, Pending, e.Active()[0].State)
+
+	changed, err = e.Evaluate(ctx, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, Firing, changed[0].State)
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "HeapAlloc", Value: 50}))
+
+	changed, err = e.Evaluate(ctx, start.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, Resolved, changed[0].State)
+	assert.Empty(t, e.Active())
+}
+
+func TestEnginePendingReset(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	e := NewEngine(s, []Rule{mustParseRule(t, "high heap", "gauge HeapAlloc > 100 for 1m")})
+	start := time.Now()
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "HeapAlloc", Value: 200}))
+	_, err := e.Evaluate(ctx, start)
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "HeapAlloc", Value: 50}))
+	changed, err := e.Evaluate(ctx, start.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+	assert.Empty(t, e.Active())
+}
+
+func TestEngineAbsent(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	e := NewEngine(s, []Rule{mustParseRule(t, "no alloc", "gauge Alloc absent for 1m")})
+	start := time.Now()
+
+	_, err := e.Evaluate(ctx, start)
+	require.NoError(t, err)
+	changed, err := e.Evaluate(ctx, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, Firing, changed[0].State)
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1}))
+	changed, err = e.Evaluate(ctx, start.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, Resolved, changed[0].State)
+}
+
+func TestEngineUnchanged(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	e := NewEngine(s, []Rule{mustParseRule(t, "agent stuck", "counter PollCount unchanged for 2m")})
+	start := time.Now()
+
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+	require.NoError(t, err)
+
+	_, err = e.Evaluate(ctx, start)
+	require.NoError(t, err)
+	assert.Empty(t, e.Active())
+
+	_, err = e.Evaluate(ctx, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, e.Active(), 1)
+	assert.Equal(t, Pending, e.Active()[0].State)
+
+	changed, err := e.Evaluate(ctx, start.Add(2*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, Firing, changed[0].State)
+
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+	require.NoError(t, err)
+
+	changed, err = e.Evaluate(ctx, start.Add(3*time.Minute))
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, Resolved, changed[0].State)
+}
+
+type recordingNotifier struct {
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Notify(alert Alert) {
+	n.alerts = append(n.alerts, alert)
+}
+
+func TestEngineNotifies(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	e := NewEngine(s, []Rule{mustParseRule(t, "no alloc", "gauge Alloc absent for 0s")})
+	n := &recordingNotifier{}
+	e.AddNotifier(n)
+	start := time.Now()
+
+	_, err := e.Evaluate(ctx, start)
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1}))
+	_, err = e.Evaluate(ctx, start.Add(time.Minute))
+	require.NoError(t, err)
+
+	require.Len(t, n.alerts, 2)
+	assert.Equal(t, Firing, n.alerts[0].State)
+	assert.Equal(t, Resolved, n.alerts[1].State)
+}
@@ -0,0 +1,138 @@
+// Модуль alerts вычисляет правила алертинга по метрикам из хранилища
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+var (
+	ErrWrongRule     = errors.New("rule must look like \"<kind> <name> <op> <value> [for <duration>]\" or \"<kind> <name> absent|unchanged for <duration>\"")
+	ErrWrongOperator = errors.New("wrong rule operator")
+	ErrEmptyRuleName = errors.New("rule must have a name")
+)
+
+// Condition Тип условия правила.
+type Condition string
+
+const (
+	// Значение метрики сравнивается с порогом
+	Threshold Condition = "threshold"
+	// Метрики нет в хранилище
+	Absent Condition = "absent"
+	// Значение не менялось (для counter - не росло)
+	Unchanged Condition = "unchanged"
+)
+
+var operators = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+// RuleConfig Правило в том виде, в котором оно задается в файле правил.
+type RuleConfig struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+}
+
+// Rule Разобранное правило алертинга.
+type Rule struct {
+	Name      string
+	Expr      string
+	Kind      string
+	Metric    string
+	Condition Condition
+	Operator  string
+	Value     float64
+	For       time.Duration
+}
+
+// Разбор выражения правила, например "gauge HeapAlloc > 1e9 for 5m" или "counter PollCount unchanged for 2m"
+func ParseRule(name, expr string) (Rule, error) {
+	rule := Rule{Name: name, Expr: expr}
+	if name == "" {
+		return rule, ErrEmptyRuleName
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) < 3 {
+		return rule, ErrWrongRule
+	}
+
+	rule.Kind, rule.Metric = fields[0], fields[1]
+	if rule.Kind != model.Gauge && rule.Kind != model.Counter {
+		return rule, model.ErrWrongMetricKind
+	}
+
+	var rest []string
+	switch fields[2] {
+	case string(Absent), string(Unchanged):
+		rule.Condition = Condition(fields[2])
+		rest = fields[3:]
+	default:
+		if len(fields) < 4 {
+			return rule, ErrWrongRule
+		}
+		if _, ok := operators[fields[2]]; !ok {
+			return rule, fmt.Errorf("%w: %s", ErrWrongOperator, fields[2])
+		}
+
+		value, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return rule, err
+		}
+
+		rule.Condition = Threshold
+		rule.Operator = fields[2]
+		rule.Value = value
+		rest = fields[4:]
+	}
+
+	switch {
+	case len(rest) == 0 && rule.Condition == Threshold:
+	case len(rest) == 2 && rest[0] == "for":
+		duration, err := time.ParseDuration(rest[1])
+		if err != nil {
+			return rule, err
+		}
+		rule.For = duration
+	default:
+		return rule, ErrWrongRule
+	}
+
+	return rule, nil
+}
+
+// Загрузка правил из json файла со списком RuleConfig
+func LoadRules(filePath string) ([]Rule, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var ruleConfigs []RuleConfig
+	if err = json.Unmarshal(data, &ruleConfigs); err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, len(ruleConfigs))
+	for i, ruleConfig := range ruleConfigs {
+		rules[i], err = ParseRule(ruleConfig.Name, ruleConfig.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", ruleConfig.Name, err)
+		}
+	}
+
+	return rules, nil
+}
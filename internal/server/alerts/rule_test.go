@@ -0,0 +1,118 @@
+package alerts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		wantRule Rule
+		wantErr  bool
+	}{
+		{
+			name: "threshold with duration",
+			expr: "gauge HeapAlloc > 1e9 for 5m",
+			wantRule: Rule{
+				Kind:      "gauge",
+				Metric:    "HeapAlloc",
+				Condition: Threshold,
+				Operator:  ">",
+				Value:     1e9,
+				For:       5 * time.Minute,
+			},
+		},
+		{
+			name: "threshold without duration",
+			expr: "counter PollCount <= 0",
+			wantRule: Rule{
+				Kind:      "counter",
+				Metric:    "PollCount",
+				Condition: Threshold,
+				Operator:  "<=",
+				Value:     0,
+			},
+		},
+		{
+			name: "unchanged",
+			expr: "counter PollCount unchanged for 2m",
+			wantRule: Rule{
+				Kind:      "counter",
+				Metric:    "PollCount",
+				Condition: Unchanged,
+				For:       2 * time.Minute,
+			},
+		},
+		{
+			name: "absent",
+			expr: "gauge Alloc absent for 30s",
+			wantRule: Rule{
+				Kind:      "gauge",
+				Metric:    "Alloc",
+				Condition: Absent,
+				For:       30 * time.Second,
+			},
+		},
+		{
+			name:    "wrong kind",
+			expr:    "histogram Alloc > 1",
+			wantErr: true,
+		},
+		{
+			name:    "wrong operator",
+			expr:    "gauge Alloc => 1",
+			wantErr: true,
+		},
+		{
+			name:    "unchanged without duration",
+			expr:    "counter PollCount unchanged",
+			wantErr: true,
+		},
+		{
+			name:    "wrong duration",
+			expr:    "gauge Alloc > 1 for ever",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := ParseRule("test", test.expr)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			test.wantRule.Name = "test"
+			test.wantRule.Expr = test.expr
+			assert.Equal(t, test.wantRule, rule)
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	rulesPath := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(rulesPath, []byte(`[
+		{"name": "high heap", "expr": "gauge HeapAlloc > 1e9 for 5m"},
+		{"name": "agent stuck", "expr": "counter PollCount unchanged for 2m"}
+	]`), 0644)
+	require.NoError(t, err)
+
+	rules, err := LoadRules(rulesPath)
+	require.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	err = os.WriteFile(rulesPath, []byte(`[{"name": "broken", "expr": "gauge HeapAlloc"}]`), 0644)
+	require.NoError(t, err)
+
+	_, err = LoadRules(rulesPath)
+	assert.Error(t, err)
+}
@@ -0,0 +1,154 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/auth"
+	"github.com/smakimka/mtrcscollector/internal/logger"
+)
+
+var (
+	DefaultRetryDelays = []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
+	ErrWebhookStatus   = errors.New("webhook responded with not ok status")
+)
+
+// Notifier Получатель изменений состояния алертов, Notify не должен блокировать вычисление правил.
+type Notifier interface {
+	Notify(alert Alert)
+}
+
+// WebhookPayload Тело запроса, отправляемого на вебхук.
+type WebhookPayload struct {
+	SentAt time.Time `json:"sent_at"`
+	Alerts []Alert   `json:"alerts"`
+}
+
+// WebhookNotifier Отправляет изменения алертов на вебхуки.
+// Изменения, пришедшие в течение GroupWait, отправляются одним запросом,
+// повтор того же состояния того же правила в течение DedupWindow не отправляется.
+type WebhookNotifier struct {
+	client      *http.Client
+	urls        []string
+	groupWait   time.Duration
+	dedupWindow time.Duration
+	retryDelays []time.Duration
+
+	batch    []Alert
+	lastSent map[string]time.Time
+	timer    *time.Timer
+	mutex    sync.Mutex
+}
+
+func NewWebhookNotifier(urls []string, groupWait, dedupWindow time.Duration) *WebhookNotifier {
+	return &WebhookNotifier{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		urls:        urls,
+		groupWait:   groupWait,
+		dedupWindow: dedupWindow,
+		retryDelays: DefaultRetryDelays,
+		lastSent:    make(map[string]time.Time),
+	}
+}
+
+// Задержки между повторными попытками, их количество равно количеству повторов
+func (n *WebhookNotifier) SetRetryDelays(delays []time.Duration) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.retryDelays = delays
+}
+
+func (n *WebhookNotifier) Notify(alert Alert) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	key := alert.Rule + "/" + string(alert.State)
+	now := time.Now()
+	if last, ok := n.lastSent[key]; ok && now.Sub(last) < n.dedupWindow {
+		logger.Log.Debug().Msg(fmt.Sprintf("skipping duplicate notification for %s", key))
+		return
+	}
+	n.lastSent[key] = now
+
+	n.batch = append(n.batch, alert)
+	if n.timer == nil {
+		n.timer = time.AfterFunc(n.groupWait, n.flush)
+	}
+}
+
+func (n *WebhookNotifier) flush() {
+	n.mutex.Lock()
+	batch := n.batch
+	retryDelays := n.retryDelays
+	n.batch = nil
+	n.timer = nil
+	n.mutex.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(WebhookPayload{SentAt: time.Now(), Alerts: batch})
+	if err != nil {
+		logger.Log.Err(err).Msg("error encoding webhook payload")
+		return
+	}
+
+	for _, url := range n.urls {
+		if err = n.send(url, body, retryDelays); err != nil {
+			logger.Log.Err(err).Str("url", url).Msg("error sending webhook")
+		}
+	}
+}
+
+func (n *WebhookNotifier) send(url string, body []byte, retryDelays []time.Duration) error {
+	var err error
+
+	for attempt := 0; attempt <= len(retryDelays); attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryDelays[attempt-1])
+		}
+
+		var retryable bool
+		retryable, err = n.post(url, body)
+		if err == nil || !retryable {
+			return err
+		}
+	}
+
+	return err
+}
+
+// Один запрос на вебхук, повторять имеет смысл при сетевых ошибках, 429 и 5xx
+func (n *WebhookNotifier) post(url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if auth.Enabled() {
+		req.Header.Set("HashSHA256", hex.EncodeToString(auth.Sign(body)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("%w (%d)", ErrWebhookStatus, resp.StatusCode)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
@@ -0,0 +1,135 @@
+package alerts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/auth"
+)
+
+const testKey = "test key"
+
+// webhookStandIn Локальный вебхук, запоминающий полученные запросы.
+type webhookStandIn struct {
+	payloads []WebhookPayload
+	signs    []string
+	// сколько первых запросов завершить с ошибкой
+	failFirst int
+	calls     int
+	mutex     sync.Mutex
+}
+
+func (h *webhookStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.calls++
+	if h.calls <= h.failFirst {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var payload WebhookPayload
+	if err = json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// подпись проверяется независимо от пакета auth
+	mac := hmac.New(sha256.New, []byte(testKey))
+	mac.Write(body)
+	if hmac.Equal(mustDecodeHex(r.Header.Get("HashSHA256")), mac.Sum(nil)) {
+		h.signs = append(h.signs, r.Header.Get("HashSHA256"))
+	}
+	h.payloads = append(h.payloads, payload)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *webhookStandIn) received() []WebhookPayload {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return append([]WebhookPayload{}, h.payloads...)
+}
+
+func mustDecodeHex(s string) []byte {
+	data, _ := hex.DecodeString(s)
+	return data
+}
+
+func TestWebhookNotifierGroupsAndSigns(t *testing.T) {
+	auth.Init(testKey)
+
+	standIn := &webhookStandIn{}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+
+	n := NewWebhookNotifier([]string{ts.URL}, 50*time.Millisecond, time.Minute)
+	n.Notify(Alert{Rule: "high heap", State: Firing})
+	n.Notify(Alert{Rule: "agent stuck", State: Firing})
+
+	require.Eventually(t, func() bool {
+		return len(standIn.received()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	payload := standIn.received()[0]
+	require.Len(t, payload.Alerts, 2)
+	assert.Equal(t, "high heap", payload.Alerts[0].Rule)
+	assert.Equal(t, "agent stuck", payload.Alerts[1].Rule)
+	assert.Len(t, standIn.signs, 1)
+}
+
+func TestWebhookNotifierDedup(t *testing.T) {
+	standIn := &webhookStandIn{}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+
+	n := NewWebhookNotifier([]string{ts.URL}, 10*time.Millisecond, time.Minute)
+	n.Notify(Alert{Rule: "high heap", State: Firing})
+
+	require.Eventually(t, func() bool {
+		return len(standIn.received()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	n.Notify(Alert{Rule: "high heap", State: Firing})
+	n.Notify(Alert{Rule: "high heap", State: Resolved})
+
+	require.Eventually(t, func() bool {
+		return len(standIn.received()) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	payload := standIn.received()[1]
+	require.Len(t, payload.Alerts, 1)
+	assert.Equal(t, Resolved, payload.Alerts[0].State)
+}
+
+func TestWebhookNotifierRetries(t *testing.T) {
+	standIn := &webhookStandIn{failFirst: 2}
+	ts := httptest.NewServer(standIn)
+	defer ts.Close()
+
+	n := NewWebhookNotifier([]string{ts.URL}, 0, time.Minute)
+	n.SetRetryDelays([]time.Duration{10 * time.Millisecond, 10 * time.Millisecond})
+	n.Notify(Alert{Rule: "high heap", State: Firing})
+
+	require.Eventually(t, func() bool {
+		return len(standIn.received()) == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Канал postgres, в который экземпляры сервера сообщают об изменении метрик
+const Channel = "metrics_changed"
+
+// Bus Канал уведомлений об изменениях между экземплярами сервера.
+type Bus interface {
+	Notify(ctx context.Context, payload string) error
+	// Слушать уведомления до ошибки или отмены ctx, ready вызывается, когда подписка установлена
+	Listen(ctx context.Context, ready func(), handle func(payload string)) error
+}
+
+// PGBus Уведомления через LISTEN/NOTIFY postgres.
+type PGBus struct {
+	p       *pgxpool.Pool
+	channel string
+}
+
+func NewPGBus(p *pgxpool.Pool, channel string) *PGBus {
+	return &PGBus{p: p, channel: channel}
+}
+
+func (b *PGBus) Notify(ctx context.Context, payload string) error {
+	_, err := b.p.Exec(ctx, `select pg_notify($1, $2)`, b.channel, payload)
+	return err
+}
+
+func (b *PGBus) Listen(ctx context.Context, ready func(), handle func(payload string)) error {
+	pooled, err := b.p.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// соединение с подпиской не возвращается в пул, чтобы уведомления не копились в чужих запросах
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err = conn.Exec(ctx, "listen "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		return err
+	}
+	ready()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		handle(notification.Payload)
+	}
+}
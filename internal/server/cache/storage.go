@@ -0,0 +1,404 @@
+// Модуль cache кеширует чтения из общего для нескольких серверов хранилища
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+var _ storage.Storage = (*Storage)(nil)
+
+// Пауза перед повторной подпиской на уведомления
+const RelistenDelay = time.Second
+
+// Уведомление длиннее этого сбрасывает весь тип целиком (у NOTIFY ограничение в 8000 байт)
+const maxPayload = 7000
+
+// change Уведомление об изменении метрик. Пустой Kind - оба типа, All - все метрики типа.
+type change struct {
+	Instance string   `json:"instance"`
+	Kind     string   `json:"kind,omitempty"`
+	Names    []string `json:"names,omitempty"`
+	All      bool     `json:"all,omitempty"`
+}
+
+// Storage Обертка над хранилищем, которая отдает чтения из памяти. Записи идут в хранилище и выбрасывают метрики из кеша,
+// остальные экземпляры сервера узнают о них через Bus и выбрасывают измененные метрики из своего кеша.
+// Пока подписки на уведомления нет, кеш не используется и чтения идут в хранилище.
+type Storage struct {
+	storage.Storage
+	bus      Bus
+	instance string
+
+	mutex     sync.RWMutex
+	gauges    map[string]float64
+	counters  map[string]int64
+	allGauges bool
+	allCounts bool
+	listening bool
+	// меняется при каждом сбросе, значение, прочитанное из хранилища до сброса, в кеш не попадает
+	generation uint64
+}
+
+// Обертка с кешем, подписка на уведомления живет, пока не отменен ctx
+func NewStorage(ctx context.Context, s storage.Storage, bus Bus) *Storage {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	cs := &Storage{
+		Storage:  s,
+		bus:      bus,
+		instance: hex.EncodeToString(id),
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+	go cs.listen(ctx)
+
+	return cs
+}
+
+// Хранилище, которое оборачивает кеш
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+// Держать подписку на уведомления, при обрыве кеш сбрасывается, так как уведомления могли потеряться
+func (s *Storage) listen(ctx context.Context) {
+	for {
+		err := s.bus.Listen(ctx, s.startListening, s.handle)
+		s.stopListening()
+		if ctx.Err() != nil {
+			return
+		}
+
+		logger.Log.Err(err).Msg("lost cache invalidation channel, reading from storage until it is back")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(RelistenDelay):
+		}
+	}
+}
+
+// Кеш начинается с чистого листа: пока подписки не было, изменения других экземпляров могли пройти мимо
+func (s *Storage) startListening() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.listening = true
+	s.reset("")
+}
+
+func (s *Storage) stopListening() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.listening = false
+	s.reset("")
+}
+
+// Применить уведомление другого экземпляра
+func (s *Storage) handle(payload string) {
+	var c change
+	if err := json.Unmarshal([]byte(payload), &c); err != nil {
+		logger.Log.Err(err).Msg("bad cache invalidation message, dropping the whole cache")
+		c = change{All: true}
+	}
+	if c.Instance == s.instance {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if c.All {
+		s.reset(c.Kind)
+		return
+	}
+
+	s.generation++
+	for _, name := range c.Names {
+		switch c.Kind {
+		case model.Gauge:
+			delete(s.gauges, name)
+			s.allGauges = false
+		case model.Counter:
+			delete(s.counters, name)
+			s.allCounts = false
+		}
+	}
+}
+
+// Сбросить кеш метрик типа kind (пустой - обоих), вызывается под блокировкой
+func (s *Storage) reset(kind string) {
+	s.generation++
+	if kind != model.Counter {
+		s.gauges = make(map[string]float64)
+		s.allGauges = false
+	}
+	if kind != model.Gauge {
+		s.counters = make(map[string]int64)
+		s.allCounts = false
+	}
+}
+
+// Выбросить из кеша метрики, записанные через этот экземпляр. Значение в кеш не кладется: чтение, начатое
+// до записи, могло бы положить поверх него старое, поэтому поколение меняется и такие чтения отбрасываются
+func (s *Storage) invalidate(kind string, names ...string) {
+	if len(names) == 0 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.generation++
+	for _, name := range names {
+		switch kind {
+		case model.Gauge:
+			delete(s.gauges, name)
+			s.allGauges = false
+		case model.Counter:
+			delete(s.counters, name)
+			s.allCounts = false
+		}
+	}
+}
+
+// Текущее поколение кеша и можно ли им пользоваться
+func (s *Storage) current() (uint64, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.generation, s.listening
+}
+
+// Изменить кеш, если с момента generation его не сбрасывали
+func (s *Storage) fill(generation uint64, fn func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.listening && s.generation == generation {
+		fn()
+	}
+}
+
+// Сообщить остальным экземплярам об изменении, ошибка не ломает запись, у них кеш просто дольше будет старым
+func (s *Storage) notify(ctx context.Context, c change) {
+	c.Instance = s.instance
+	payload, err := json.Marshal(c)
+	if err == nil && len(payload) > maxPayload {
+		c.Names, c.All = nil, true
+		payload, err = json.Marshal(c)
+	}
+	if err == nil {
+		err = s.bus.Notify(ctx, string(payload))
+	}
+	if err != nil {
+		logger.Log.Err(err).Msg("error sending cache invalidation")
+	}
+}
+
+func (s *Storage) GetGaugeMetric(ctx context.Context, name string) (model.GaugeMetric, error) {
+	s.mutex.RLock()
+	value, ok := s.gauges[name]
+	generation, listening := s.generation, s.listening
+	s.mutex.RUnlock()
+	if ok && listening {
+		return model.GaugeMetric{Name: name, Value: value}, nil
+	}
+
+	m, err := s.Storage.GetGaugeMetric(ctx, name)
+	if err != nil {
+		return m, err
+	}
+
+	s.fill(generation, func() { s.gauges[name] = m.Value })
+	return m, nil
+}
+
+func (s *Storage) GetCounterMetric(ctx context.Context, name string) (model.CounterMetric, error) {
+	s.mutex.RLock()
+	value, ok := s.counters[name]
+	generation, listening := s.generation, s.listening
+	s.mutex.RUnlock()
+	if ok && listening {
+		return model.CounterMetric{Name: name, Value: value}, nil
+	}
+
+	m, err := s.Storage.GetCounterMetric(ctx, name)
+	if err != nil {
+		return m, err
+	}
+
+	s.fill(generation, func() { s.counters[name] = m.Value })
+	return m, nil
+}
+
+func (s *Storage) GetAllGaugeMetrics(ctx context.Context) ([]model.GaugeMetric, error) {
+	s.mutex.RLock()
+	if s.allGauges && s.listening {
+		metrics := make([]model.GaugeMetric, 0, len(s.gauges))
+		for name, value := range s.gauges {
+			metrics = append(metrics, model.GaugeMetric{Name: name, Value: value})
+		}
+		s.mutex.RUnlock()
+		return metrics, nil
+	}
+	generation := s.generation
+	s.mutex.RUnlock()
+
+	metrics, err := s.Storage.GetAllGaugeMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fill(generation, func() {
+		s.gauges = make(map[string]float64, len(metrics))
+		for _, m := range metrics {
+			s.gauges[m.Name] = m.Value
+		}
+		s.allGauges = true
+	})
+	return metrics, nil
+}
+
+func (s *Storage) GetAllCounterMetrics(ctx context.Context) ([]model.CounterMetric, error) {
+	s.mutex.RLock()
+	if s.allCounts && s.listening {
+		metrics := make([]model.CounterMetric, 0, len(s.counters))
+		for name, value := range s.counters {
+			metrics = append(metrics, model.CounterMetric{Name: name, Value: value})
+		}
+		s.mutex.RUnlock()
+		return metrics, nil
+	}
+	generation := s.generation
+	s.mutex.RUnlock()
+
+	metrics, err := s.Storage.GetAllCounterMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fill(generation, func() {
+		s.counters = make(map[string]int64, len(metrics))
+		for _, m := range metrics {
+			s.counters[m.Name] = m.Value
+		}
+		s.allCounts = true
+	})
+	return metrics, nil
+}
+
+func (s *Storage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMetric) error {
+	err := s.Storage.UpdateGaugeMetric(ctx, m)
+	s.invalidate(model.Gauge, m.Name)
+	if err != nil {
+		return err
+	}
+
+	s.notify(ctx, change{Kind: model.Gauge, Names: []string{m.Name}})
+	return nil
+}
+
+func (s *Storage) UpdateCounterMetric(ctx context.Context, m model.CounterMetric) (int64, error) {
+	value, err := s.Storage.UpdateCounterMetric(ctx, m)
+	s.invalidate(model.Counter, m.Name)
+	if err != nil {
+		return value, err
+	}
+
+	s.notify(ctx, change{Kind: model.Counter, Names: []string{m.Name}})
+	return value, nil
+}
+
+func (s *Storage) UpdateMetrics(ctx context.Context, metricsData model.MetricsData) error {
+	var gauges, counters []string
+	for _, m := range metricsData {
+		switch m.Kind {
+		case model.Gauge:
+			gauges = append(gauges, m.Name)
+		case model.Counter:
+			counters = append(counters, m.Name)
+		}
+	}
+
+	err := s.Storage.UpdateMetrics(ctx, metricsData)
+	s.invalidate(model.Gauge, gauges...)
+	s.invalidate(model.Counter, counters...)
+	if err != nil {
+		return err
+	}
+
+	if len(gauges) > 0 {
+		s.notify(ctx, change{Kind: model.Gauge, Names: gauges})
+	}
+	if len(counters) > 0 {
+		s.notify(ctx, change{Kind: model.Counter, Names: counters})
+	}
+	return nil
+}
+
+func (s *Storage) Delete(ctx context.Context, kind, name string) error {
+	err := s.Storage.Delete(ctx, kind, name)
+	s.invalidate(kind, name)
+	if err != nil {
+		return err
+	}
+
+	s.notify(ctx, change{Kind: kind, Names: []string{name}})
+	return nil
+}
+
+func (s *Storage) DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error) {
+	deleted, err := s.Storage.DeleteByPrefix(ctx, kind, prefix)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	s.mutex.Lock()
+	s.generation++
+	switch kind {
+	case model.Gauge:
+		for name := range s.gauges {
+			if strings.HasPrefix(name, prefix) {
+				delete(s.gauges, name)
+			}
+		}
+	case model.Counter:
+		for name := range s.counters {
+			if strings.HasPrefix(name, prefix) {
+				delete(s.counters, name)
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	s.notify(ctx, change{Kind: kind, All: true})
+	return deleted, nil
+}
+
+// Какие метрики удалены, неизвестно, поэтому кеш сбрасывается целиком
+func (s *Storage) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	deleted, err := s.Storage.DeleteStale(ctx, before)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	s.mutex.Lock()
+	s.reset("")
+	s.mutex.Unlock()
+
+	s.notify(ctx, change{All: true})
+	return deleted, nil
+}
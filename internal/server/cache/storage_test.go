@@ -0,0 +1,329 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// memBus Шина в памяти, уведомления доставляются всем подписчикам сразу.
+type memBus struct {
+	mutex    sync.Mutex
+	handlers map[int]func(string)
+	next     int
+}
+
+func newMemBus() *memBus {
+	return &memBus{handlers: make(map[int]func(string))}
+}
+
+func (b *memBus) Notify(_ context.Context, payload string) error {
+	b.mutex.Lock()
+	handlers := make([]func(string), 0, len(b.handlers))
+	for _, handle := range b.handlers {
+		handlers = append(handlers, handle)
+	}
+	b.mutex.Unlock()
+
+	for _, handle := range handlers {
+		handle(payload)
+	}
+	return nil
+}
+
+func (b *memBus) Listen(ctx context.Context, ready func(), handle func(string)) error {
+	b.mutex.Lock()
+	id := b.next
+	b.next++
+	b.handlers[id] = handle
+	b.mutex.Unlock()
+
+	ready()
+	<-ctx.Done()
+
+	b.mutex.Lock()
+	delete(b.handlers, id)
+	b.mutex.Unlock()
+	return ctx.Err()
+}
+
+func newListening(t *testing.T, ctx context.Context, s storage.Storage, bus Bus) *Storage {
+	cs := NewStorage(ctx, s, bus)
+	require.Eventually(t, func() bool {
+		_, listening := cs.current()
+		return listening
+	}, time.Second, time.Millisecond)
+	return cs
+}
+
+func TestReadThrough(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := storage.NewMemStorage()
+	bus := newMemBus()
+	a := newListening(t, ctx, db, bus)
+	b := newListening(t, ctx, db, bus)
+
+	require.NoError(t, db.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1}))
+	_, err := db.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+	require.NoError(t, err)
+
+	gauge, err := a.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, gauge.Value)
+	counters, err := a.GetAllCounterMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.CounterMetric{{Name: "PollCount", Value: 1}}, counters)
+
+	// изменения мимо кеша не видны, значит чтения идут из памяти
+	require.NoError(t, db.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 2}))
+	_, err = db.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+	require.NoError(t, err)
+	gauge, err = a.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, gauge.Value)
+	counters, err = a.GetAllCounterMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.CounterMetric{{Name: "PollCount", Value: 1}}, counters)
+
+	tests := []struct {
+		name  string
+		write func() error
+		check func(t *testing.T)
+	}{
+		{
+			name:  "gauge update",
+			write: func() error { return b.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 3}) },
+			check: func(t *testing.T) {
+				gauge, err := a.GetGaugeMetric(ctx, "Alloc")
+				require.NoError(t, err)
+				assert.Equal(t, 3.0, gauge.Value)
+			},
+		},
+		{
+			name: "counter batch",
+			write: func() error {
+				delta := int64(5)
+				return b.UpdateMetrics(ctx, model.MetricsData{{Name: "PollCount", Kind: model.Counter, Delta: &delta}})
+			},
+			check: func(t *testing.T) {
+				counters, err := a.GetAllCounterMetrics(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, []model.CounterMetric{{Name: "PollCount", Value: 7}}, counters)
+			},
+		},
+		{
+			name:  "delete",
+			write: func() error { return b.Delete(ctx, model.Gauge, "Alloc") },
+			check: func(t *testing.T) {
+				_, err := a.GetGaugeMetric(ctx, "Alloc")
+				assert.ErrorIs(t, err, storage.ErrNoSuchMetric)
+			},
+		},
+		{
+			name: "delete stale",
+			write: func() error {
+				_, err := b.DeleteStale(ctx, time.Now().Add(time.Second))
+				return err
+			},
+			check: func(t *testing.T) {
+				counters, err := a.GetAllCounterMetrics(ctx)
+				require.NoError(t, err)
+				assert.Empty(t, counters)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, test.write())
+			test.check(t)
+		})
+	}
+}
+
+func TestOwnWrites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := storage.NewMemStorage()
+	a := newListening(t, ctx, db, newMemBus())
+
+	value := 1.5
+	require.NoError(t, a.UpdateMetrics(ctx, model.MetricsData{{Name: "Alloc", Kind: model.Gauge, Value: &value}}))
+	require.NoError(t, a.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Other", Value: 2}))
+	gauges, err := a.GetAllGaugeMetrics(ctx)
+	require.NoError(t, err)
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].Name < gauges[j].Name })
+	assert.Equal(t, []model.GaugeMetric{{Name: "Alloc", Value: 1.5}, {Name: "Other", Value: 2}}, gauges)
+
+	deleted, err := a.DeleteByPrefix(ctx, model.Gauge, "Oth")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	gauges, err = a.GetAllGaugeMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.GaugeMetric{{Name: "Alloc", Value: 1.5}}, gauges)
+}
+
+func TestNotListening(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	db := storage.NewMemStorage()
+	a := newListening(t, ctx, db, newMemBus())
+
+	require.NoError(t, db.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1}))
+	_, err := a.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+
+	// без подписки чужие изменения не придут, поэтому кеш не используется
+	cancel()
+	require.Eventually(t, func() bool {
+		_, listening := a.current()
+		return !listening
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, db.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 2}))
+	gauge, err := a.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, gauge.Value)
+}
+
+func TestBigChange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var payloads []string
+	bus := &recordingBus{notify: func(payload string) { payloads = append(payloads, payload) }}
+	a := NewStorage(ctx, storage.NewMemStorage(), bus)
+
+	value := 1.0
+	data := make(model.MetricsData, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		data = append(data, model.MetricData{Name: fmt.Sprintf("SomeLongGaugeName%d", i), Kind: model.Gauge, Value: &value})
+	}
+	require.NoError(t, a.UpdateMetrics(ctx, data))
+
+	require.Len(t, payloads, 1)
+	assert.LessOrEqual(t, len(payloads[0]), maxPayload)
+	assert.Contains(t, payloads[0], `"all":true`)
+}
+
+// recordingBus Шина, которая только запоминает отправленные уведомления.
+type recordingBus struct {
+	notify func(string)
+}
+
+func (b *recordingBus) Notify(_ context.Context, payload string) error {
+	b.notify(payload)
+	return nil
+}
+
+func (b *recordingBus) Listen(ctx context.Context, ready func(), _ func(string)) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// slowStorage Хранилище, чтение gauge из которого ждет release, уже прочитав значение.
+type slowStorage struct {
+	storage.Storage
+	read    chan struct{}
+	release chan struct{}
+}
+
+func (s *slowStorage) GetGaugeMetric(ctx context.Context, name string) (model.GaugeMetric, error) {
+	m, err := s.Storage.GetGaugeMetric(ctx, name)
+	s.read <- struct{}{}
+	<-s.release
+	return m, err
+}
+
+func TestReadRacingWrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := storage.NewMemStorage()
+	require.NoError(t, db.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1}))
+	slow := &slowStorage{Storage: db, read: make(chan struct{}, 1), release: make(chan struct{})}
+	a := newListening(t, ctx, slow, newMemBus())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := a.GetGaugeMetric(ctx, "Alloc")
+		assert.NoError(t, err)
+	}()
+
+	// чтение уже получило старое значение, запись заканчивается раньше, чем оно попадет в кеш
+	<-slow.read
+	require.NoError(t, a.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 2}))
+	close(slow.release)
+	<-done
+
+	gauge, err := a.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, gauge.Value)
+}
+
+func TestConcurrentReadWrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := storage.NewMemStorage()
+	a := newListening(t, ctx, db, newMemBus())
+
+	const writers, readers, updates = 4, 4, 200
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				a.GetGaugeMetric(ctx, "Alloc")
+				a.GetAllCounterMetrics(ctx)
+			}
+		}()
+	}
+
+	var writersWG sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writersWG.Add(1)
+		go func(w int) {
+			defer writersWG.Done()
+			for i := 0; i < updates; i++ {
+				value, delta := float64(w*updates+i), int64(1)
+				assert.NoError(t, a.UpdateMetrics(ctx, model.MetricsData{
+					{Name: "Alloc", Kind: model.Gauge, Value: &value},
+					{Name: "PollCount", Kind: model.Counter, Delta: &delta},
+				}))
+			}
+		}(w)
+	}
+	writersWG.Wait()
+	close(stop)
+	wg.Wait()
+
+	want, err := db.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+	gauge, err := a.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, want, gauge)
+
+	counters, err := a.GetAllCounterMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.CounterMetric{{Name: "PollCount", Value: writers * updates}}, counters)
+}
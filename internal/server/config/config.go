@@ -3,32 +3,64 @@ package config
 import (
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"flag"
+	"fmt"
 	"net"
 	"os"
 
-	"github.com/caarlos0/env/v10"
+	"github.com/smakimka/mtrcscollector/internal/configloader"
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/storage"
 )
 
 type Config struct {
-	Addr                string `env:"ADDRESS" json:"addr"`
-	FileStoragePath     string `env:"FILE_STORAGE_PATH" json:"file_storage_path"`
-	DatabaseDSN         string `env:"DATABASE_DSN" json:"database_dsn"`
-	Key                 string `env:"KEY" json:"key"`
-	CryptoKeyPath       string `env:"CRYPTO_KEY" json:"crypto_key"`
-	CryptoKey           *rsa.PrivateKey
-	StoreInterval       int    `env:"STORE_INTERVAL" json:"store_interval"`
-	Restore             bool   `env:"RESTORE" json:"restore"`
-	TrustedSubnetString string `env:"TRUSTED_SUBNET" json:"trusted_subnet"`
-	TrustedSubnet       *net.IPNet
-	StartAsGRPC         bool `env:"GPRC" json:"grpc"`
+	Addr                string          `env:"ADDRESS" json:"addr" flag:"a" default:"localhost:8080" usage:"host:port to run on"`
+	FileStoragePath     string          `env:"FILE_STORAGE_PATH" json:"file_storage_path" flag:"f" default:"/tmp/metrics-db.json" usage:"temp file to save state to (if emtpy no saves are done)"`
+	DatabaseDSN         string          `env:"DATABASE_DSN" json:"database_dsn" flag:"d" usage:"database dsn string" loader:"secret"`
+	Key                 string          `env:"KEY" json:"key" flag:"k" usage:"auth key string" loader:"secret"`
+	CryptoKeyPath       string          `env:"CRYPTO_KEY" json:"crypto_key" flag:"crypto-key" usage:"path to a private key file"`
+	CryptoKey           *rsa.PrivateKey `json:"-"`
+	StoreInterval       int             `env:"STORE_INTERVAL" json:"store_interval" flag:"i" default:"300" usage:"state save interval (in seconds)"`
+	SnapshotKeep        int             `env:"SNAPSHOT_KEEP" json:"snapshot_keep" flag:"snapshot-keep" default:"3" usage:"number of state snapshots to keep, including the latest one"`
+	SnapshotFormat      string          `env:"SNAPSHOT_FORMAT" json:"snapshot_format" flag:"snapshot-format" default:"json" usage:"format to write state snapshots in: json or binary (both are read)"`
+	Restore             bool            `env:"RESTORE" json:"restore" flag:"r" default:"true" usage:"load with saved data or not"`
+	TrustedSubnetString string          `env:"TRUSTED_SUBNET" json:"trusted_subnet" flag:"t" usage:"trusted subnet (CIDR), only connections from it may name the agent in X-Real-IP"`
+	TrustedSubnet       *net.IPNet      `json:"-"`
+	StartAsGRPC         bool            `env:"GRPC" json:"grpc" flag:"g" usage:"start as grpc or not"`
+	AlertRulesPath      string          `env:"ALERT_RULES" json:"alert_rules" flag:"alert-rules" usage:"path to a json file with alert rules"`
+	AlertInterval       int             `env:"ALERT_INTERVAL" json:"alert_interval" flag:"alert-interval" default:"15" usage:"alert rules evaluation interval (in seconds)"`
+	AlertWebhooks       []string        `env:"ALERT_WEBHOOKS" json:"alert_webhooks" flag:"alert-webhooks" usage:"comma separated list of urls to send alert notifications to"`
+	AlertGroupWait      int             `env:"ALERT_GROUP_WAIT" json:"alert_group_wait" flag:"alert-group-wait" default:"10" usage:"period to group alert notifications in (in seconds)"`
+	AlertDedupWindow    int             `env:"ALERT_DEDUP_WINDOW" json:"alert_dedup_window" flag:"alert-dedup-window" default:"300" usage:"period to skip repeated alert notifications in (in seconds)"`
+	RecordingRulesPath  string          `env:"RECORDING_RULES" json:"recording_rules" flag:"recording-rules" usage:"path to a json file with recording rules"`
+	RecordingInterval   int             `env:"RECORDING_INTERVAL" json:"recording_interval" flag:"recording-interval" default:"10" usage:"recording rules evaluation interval (in seconds)"`
+	AgentStaleReports   int             `env:"AGENT_STALE_REPORTS" json:"agent_stale_reports" flag:"agent-stale-reports" default:"3" usage:"number of missed report intervals after which an agent is stale"`
+	AgentTTL            int             `env:"AGENT_TTL" json:"agent_ttl" flag:"agent-ttl" default:"3600" usage:"forget agents that sent nothing for this long (in seconds)"`
+	MaxAgents           int             `env:"MAX_AGENTS" json:"max_agents" flag:"max-agents" default:"1000" usage:"max number of tracked agents, the longest silent one is forgotten to make room"`
+	MetricTTL           int             `env:"METRIC_TTL" json:"metric_ttl" flag:"metric-ttl" usage:"delete metrics not updated for this long (in seconds, 0 keeps metrics forever)"`
+	MaxSeries           int             `env:"MAX_SERIES" json:"max_series" flag:"max-series" usage:"max number of distinct metrics on the server (0 is unlimited)"`
+	MaxSeriesPerSource  int             `env:"MAX_SERIES_PER_SOURCE" json:"max_series_per_source" flag:"max-series-per-source" usage:"max number of distinct metrics created by one agent (0 is unlimited)"`
+	WALPath             string          `env:"WAL_PATH" json:"wal_path" flag:"wal" usage:"path to a write-ahead log of in-memory storage updates, store_interval is then the compaction period"`
+	WALSync             string          `env:"WAL_SYNC" json:"wal_sync" flag:"wal-sync" default:"always" usage:"when to fsync the write-ahead log: always, interval or none"`
+	PGCache             bool            `env:"PG_CACHE" json:"pg_cache" flag:"pg-cache" usage:"cache database reads in memory, other servers on the same database invalidate it with LISTEN/NOTIFY"`
+	MemShards           int             `env:"MEM_SHARDS" json:"mem_shards" flag:"mem-shards" usage:"split in-memory storage into this many shards with separate locks (0 keeps one lock), needs store_interval > 0 and no wal"`
+	LogLevel            string          `env:"LOG_LEVEL" json:"log_level" flag:"log-level" default:"info" usage:"logging level (debug or info)"`
+	ConfigPath          string          `env:"CONFIG" json:"-" flag:"c,config" usage:"path to a json or yaml config file, reread on SIGHUP" loader:"path"`
+	MigrateOnly         bool            `env:"MIGRATE_ONLY" json:"-" flag:"migrate-only" usage:"apply database migrations and exit"`
+	PrintConfig         bool            `json:"-" flag:"print-config" usage:"print the effective config with secrets hidden and exit"`
+
+	// загрузчик нужен, чтобы перечитать конфиг с теми же флагами
+	loader *configloader.Loader
 }
 
 func NewConfig() *Config {
-	return parseFlags()
+	cfg, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		panic(err)
+	}
+	return cfg
 }
 
 var ErrNokey = errors.New("key file doesn't contain key'")
@@ -66,149 +98,96 @@ func (c *Config) ParseCIDR() error {
 	return nil
 }
 
-func parseFlags() *Config {
-	var flagRunAddr string
-	var flagStoreInterval int
-	var flagStoragePath string
-	var flagRestore bool
-	var flagDatabaseDSN string
-	var flagKey string
-	var flagCryptoKey string
-	var flagJsonConfig string
-	var flagTrustedSubnet string
-	var flagGRPC bool
-
-	flag.StringVar(&flagRunAddr, "a", "localhost:8080", "host:port to run on")
-	flag.IntVar(&flagStoreInterval, "i", 300, "state save interval (in seconds)")
-	flag.StringVar(&flagStoragePath, "f", "/tmp/metrics-db.json", "temp file to save state to (if emtpy no saves are done)")
-	flag.BoolVar(&flagRestore, "r", true, "load with saved data or not")
-	flag.StringVar(&flagDatabaseDSN, "d", "", "database dsn string")
-	flag.StringVar(&flagKey, "k", "", "auth key string")
-	flag.StringVar(&flagCryptoKey, "crypto-key", "", "path to a private key file")
-	flag.StringVar(&flagJsonConfig, "c", "{}", "config in json format")
-	flag.StringVar(&flagTrustedSubnet, "t", "", "trusted subnet (CIDR)")
-	flag.BoolVar(&flagGRPC, "g", false, "start as grpc or not")
-
-	flag.Parse()
-
-	cfg := &Config{}
-	err := env.Parse(cfg)
-	if err != nil {
-		panic(err)
+// Проверить значения конфига, ошибки собираются все сразу
+func (c *Config) Validate() error {
+	var logLevelErr error
+	if _, err := logger.ParseLevel(c.LogLevel); err != nil {
+		logLevelErr = fmt.Errorf("log_level: %w", err)
 	}
 
-	var jsonCfg Config
-	err = json.Unmarshal([]byte(flagJsonConfig), &jsonCfg)
-	if err != nil {
-		panic(err)
-	}
+	return errors.Join(
+		configloader.Required("addr", c.Addr),
+		configloader.NonNegative("store_interval", c.StoreInterval),
+		configloader.Positive("snapshot_keep", c.SnapshotKeep),
+		configloader.Readable("crypto_key", c.CryptoKeyPath),
+		configloader.CIDR("trusted_subnet", c.TrustedSubnetString),
+		configloader.Readable("alert_rules", c.AlertRulesPath),
+		configloader.Positive("alert_interval", c.AlertInterval),
+		configloader.NonNegative("alert_group_wait", c.AlertGroupWait),
+		configloader.NonNegative("alert_dedup_window", c.AlertDedupWindow),
+		configloader.Readable("recording_rules", c.RecordingRulesPath),
+		configloader.Positive("recording_interval", c.RecordingInterval),
+		configloader.Positive("agent_stale_reports", c.AgentStaleReports),
+		configloader.Positive("agent_ttl", c.AgentTTL),
+		configloader.Positive("max_agents", c.MaxAgents),
+		configloader.NonNegative("metric_ttl", c.MetricTTL),
+		configloader.NonNegative("max_series", c.MaxSeries),
+		configloader.NonNegative("max_series_per_source", c.MaxSeriesPerSource),
+		configloader.NonNegative("mem_shards", c.MemShards),
+		logLevelErr,
+		c.validateSnapshotFormat(),
+		c.validateWAL(),
+		c.validateMemShards(),
+		c.validateMigrateOnly(),
+		c.validatePGCache(),
+	)
+}
 
-	if cfg.Addr == "" {
-		if flagRunAddr != "localhost:8080" {
-			cfg.Addr = flagRunAddr
-		} else {
-			if jsonCfg.Addr != "" {
-				cfg.Addr = jsonCfg.Addr
-			} else {
-				cfg.Addr = flagRunAddr
-			}
-		}
+func (c *Config) validateSnapshotFormat() error {
+	if _, err := storage.ParseSnapshotFormat(c.SnapshotFormat); err != nil {
+		return fmt.Errorf("snapshot_format: %w", err)
 	}
+	return nil
+}
 
-	if os.Getenv("STORE_INTERVAL") == "" {
-		if flagStoreInterval != 300 {
-			cfg.StoreInterval = flagStoreInterval
-		} else {
-			if jsonCfg.StoreInterval != 0 {
-				cfg.StoreInterval = jsonCfg.StoreInterval
-			} else {
-				cfg.StoreInterval = flagStoreInterval
-			}
-		}
+func (c *Config) validateWAL() error {
+	if c.WALPath == "" {
+		return nil
 	}
-
-	if os.Getenv("FILE_STORAGE_PATH") == "" {
-		if flagStoragePath != "/tmp/metrics-db.json" {
-			cfg.FileStoragePath = flagStoragePath
-		} else {
-			if jsonCfg.FileStoragePath != "" {
-				cfg.FileStoragePath = jsonCfg.FileStoragePath
-			} else {
-				cfg.FileStoragePath = flagStoragePath
-			}
-		}
+	if _, err := storage.ParseWALSync(c.WALSync); err != nil {
+		return fmt.Errorf("wal_sync: %w", err)
 	}
-
-	if os.Getenv("RESTORE") == "" {
-		if !flagRestore {
-			cfg.Restore = flagRestore
-		} else {
-			if jsonCfg.Restore {
-				cfg.Restore = jsonCfg.Restore
-			} else {
-				cfg.Restore = flagRestore
-			}
-		}
+	if c.StoreInterval == 0 {
+		return errors.New("wal_path: store_interval must be positive to compact the log")
 	}
+	return nil
+}
 
-	if os.Getenv("DATABASE_DSN") == "" {
-		if flagDatabaseDSN != "" {
-			cfg.DatabaseDSN = flagDatabaseDSN
-		} else {
-			if jsonCfg.DatabaseDSN != "" {
-				cfg.DatabaseDSN = jsonCfg.DatabaseDSN
-			} else {
-				cfg.DatabaseDSN = flagDatabaseDSN
-			}
-		}
+func (c *Config) validateMemShards() error {
+	if c.MemShards > 0 && (c.WALPath != "" || c.StoreInterval == 0) {
+		return errors.New("mem_shards: sharded storage needs store_interval > 0 and no wal_path")
 	}
+	return nil
+}
 
-	if os.Getenv("KEY") == "" {
-		if flagKey != "" {
-			cfg.Key = flagKey
-		} else {
-			if jsonCfg.Key != "" {
-				cfg.Key = jsonCfg.Key
-			} else {
-				cfg.Key = flagKey
-			}
-		}
+func (c *Config) validateMigrateOnly() error {
+	if c.MigrateOnly && c.DatabaseDSN == "" {
+		return errors.New("migrate_only: database_dsn is required")
 	}
+	return nil
+}
 
-	if os.Getenv("CRYPTO_KEY") == "" {
-		if flagCryptoKey != "" {
-			cfg.CryptoKeyPath = flagCryptoKey
-		} else {
-			if jsonCfg.CryptoKeyPath != "" {
-				cfg.CryptoKeyPath = jsonCfg.CryptoKeyPath
-			} else {
-				cfg.CryptoKeyPath = flagCryptoKey
-			}
-		}
+func (c *Config) validatePGCache() error {
+	if c.PGCache && c.DatabaseDSN == "" {
+		return errors.New("pg_cache: database_dsn is required")
 	}
+	return nil
+}
 
-	if os.Getenv("TRUSTED_SUBNET") == "" {
-		if flagTrustedSubnet != "" {
-			cfg.TrustedSubnetString = flagTrustedSubnet
-		} else {
-			if jsonCfg.TrustedSubnetString != "" {
-				cfg.TrustedSubnetString = jsonCfg.TrustedSubnetString
-			} else {
-				cfg.TrustedSubnetString = flagTrustedSubnet
-			}
-		}
+// Итоговый конфиг в json для вывода, секреты скрыты
+func (c *Config) Dump() ([]byte, error) {
+	return configloader.Dump(c)
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) (*Config, error) {
+	loader := configloader.New(&Config{}, fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	if os.Getenv("GRPC") == "" {
-		if !flagGRPC {
-			cfg.StartAsGRPC = flagGRPC
-		} else {
-			if jsonCfg.StartAsGRPC {
-				cfg.StartAsGRPC = jsonCfg.StartAsGRPC
-			} else {
-				cfg.StartAsGRPC = flagGRPC
-			}
-		}
+	cfg := &Config{loader: loader}
+	if err := loader.Load(cfg); err != nil {
+		return nil, err
 	}
-	return cfg
+	return cfg, nil
 }
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/smakimka/mtrcscollector/internal/reload"
+)
+
+var (
+	ErrNoConfigFile    = errors.New("config file is not set")
+	ErrStoreModeChange = errors.New("switching store_interval to or from 0 requires restart")
+)
+
+// Reload Перечитать конфиг с теми же флагами и переменными окружения. Меняются только уровень логирования,
+// ключ подписи, доверенная подсеть и период сохранения, флаги и переменные окружения по-прежнему
+// приоритетнее файла. Текущий конфиг не меняется, возвращается новый вместе со списком изменений.
+func (c *Config) Reload() (*Config, reload.Changes, error) {
+	if c.ConfigPath == "" || c.loader == nil {
+		return nil, nil, ErrNoConfigFile
+	}
+
+	loaded := &Config{}
+	if err := c.loader.Load(loaded); err != nil {
+		return nil, nil, err
+	}
+
+	next := *c
+	next.LogLevel = loaded.LogLevel
+	next.Key = loaded.Key
+	next.TrustedSubnetString = loaded.TrustedSubnetString
+	next.StoreInterval = loaded.StoreInterval
+
+	if err := next.validateReload(c); err != nil {
+		return nil, nil, err
+	}
+
+	var changes reload.Changes
+	changes.Add("log_level", c.LogLevel, next.LogLevel)
+	changes.AddSecret("key", c.Key, next.Key)
+	changes.Add("trusted_subnet", c.TrustedSubnetString, next.TrustedSubnetString)
+	changes.Add("store_interval", c.StoreInterval, next.StoreInterval)
+
+	return &next, changes, nil
+}
+
+// Проверить, что перечитанные настройки можно применить на лету, заодно разбирается доверенная подсеть
+func (c *Config) validateReload(prev *Config) error {
+	c.TrustedSubnet = nil
+	if c.TrustedSubnetString != "" {
+		_, ipNet, err := net.ParseCIDR(c.TrustedSubnetString)
+		if err != nil {
+			return fmt.Errorf("trusted_subnet: %w", err)
+		}
+		c.TrustedSubnet = ipNet
+	}
+
+	if (c.StoreInterval == 0) != (prev.StoreInterval == 0) {
+		return fmt.Errorf("store_interval: %w", ErrStoreModeChange)
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/reload"
+)
+
+func TestReload(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		want    reload.Changes
+		wantErr bool
+	}{
+		{
+			name: "reloadable settings",
+			file: `{"log_level": "debug", "key": "secret", "trusted_subnet": "10.0.0.0/8", "store_interval": 60, "addr": "localhost:9090"}`,
+			want: reload.Changes{
+				{Name: "log_level", Old: "info", New: "debug"},
+				{Name: "key", Old: `""`, New: reload.Redacted},
+				{Name: "trusted_subnet", Old: "", New: "10.0.0.0/8"},
+				{Name: "store_interval", Old: "300", New: "60"},
+			},
+		},
+		{
+			name: "flags and env are kept",
+			args: []string{"-log-level", "info"},
+			env:  map[string]string{"STORE_INTERVAL": "300"},
+			file: `{"log_level": "debug", "store_interval": 60, "key": "secret"}`,
+			want: reload.Changes{
+				{Name: "key", Old: `""`, New: reload.Redacted},
+			},
+		},
+		{
+			name:    "invalid settings",
+			file:    `{"log_level": "trace", "trusted_subnet": "10.0.0.0"}`,
+			wantErr: true,
+		},
+		{
+			name:    "switch to sync storage",
+			file:    `{"store_interval": 0}`,
+			wantErr: true,
+		},
+		{
+			name:    "broken file",
+			file:    `{"log_level": `,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+
+			path := filepath.Join(t.TempDir(), "config.json")
+			require.NoError(t, os.WriteFile(path, []byte(`{}`), 0o600))
+
+			cfg, err := parseFlags(flag.NewFlagSet("test", flag.ContinueOnError), append([]string{"-c", path}, test.args...))
+			require.NoError(t, err)
+
+			require.NoError(t, os.WriteFile(path, []byte(test.file), 0o600))
+			next, changes, err := cfg.Reload()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, changes)
+			assert.Equal(t, "localhost:8080", next.Addr)
+			assert.Equal(t, "info", cfg.LogLevel)
+		})
+	}
+
+	_, _, err := (&Config{}).Reload()
+	assert.ErrorIs(t, err, ErrNoConfigFile)
+}
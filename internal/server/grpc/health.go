@@ -0,0 +1,34 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/smakimka/mtrcscollector/internal/storage"
+	pb "github.com/smakimka/mtrcscollector/protobuf/server"
+)
+
+// HealthServer Стандартный сервис проверки состояния grpc, статус обновляется по готовности хранилища при каждом Check,
+// подписчики Watch получают изменения статуса после таких проверок.
+type HealthServer struct {
+	*health.Server
+	s storage.Storage
+}
+
+func NewHealthServer(s storage.Storage) *HealthServer {
+	return &HealthServer{Server: health.NewServer(), s: s}
+}
+
+func (h *HealthServer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	status := healthpb.HealthCheckResponse_SERVING
+	if err := storage.CheckHealth(ctx, h.s); err != nil {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	h.SetServingStatus("", status)
+	h.SetServingStatus(pb.MetricsCollector_ServiceDesc.ServiceName, status)
+
+	return h.Server.Check(ctx, in)
+}
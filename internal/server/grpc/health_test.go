@@ -0,0 +1,69 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/smakimka/mtrcscollector/internal/server/config"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func healthClient(t *testing.T, cfg *config.Config, s storage.Storage) healthpb.HealthClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewServer(cfg, s)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := ggrpc.NewClient("passthrough:///bufnet",
+		ggrpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		ggrpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestHealth(t *testing.T) {
+	_, trustedSubnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		s    storage.Storage
+		want healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{
+			name: "memory storage",
+			s:    storage.NewMemStorage(),
+			want: healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name: "unwritable sync file",
+			s:    storage.NewSyncMemStorage(filepath.Join(t.TempDir(), "missing", "metrics.json")),
+			want: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// проверка состояния не требует X-Real-IP из доверенной подсети
+			client := healthClient(t, &config.Config{TrustedSubnet: trustedSubnet}, test.s)
+
+			resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+			assert.Equal(t, test.want, resp.Status)
+		})
+	}
+}
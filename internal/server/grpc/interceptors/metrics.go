@@ -0,0 +1,25 @@
+package interceptors
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/server/selfmetrics"
+)
+
+// Учет вызовов в метриках сервера по методу и коду ответа
+func Metrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	selfmetrics.Default.Observe("GRPCCall", model.Labels{
+		"method": info.FullMethod,
+		"code":   status.Code(err).String(),
+	}, time.Since(start))
+
+	return resp, err
+}
@@ -2,25 +2,38 @@ package interceptors
 
 import (
 	"context"
-	"net"
+	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+
+	"github.com/smakimka/mtrcscollector/internal/subnet"
 )
 
 type SubnetInterseptor struct {
-	TrustedSubnet *net.IPNet
+	TrustedSubnet *subnet.Trusted
 }
 
-func NewSubnetInterseptor(subnet *net.IPNet) *SubnetInterseptor {
-	return &SubnetInterseptor{TrustedSubnet: subnet}
+func NewSubnetInterseptor(trusted *subnet.Trusted) *SubnetInterseptor {
+	return &SubnetInterseptor{TrustedSubnet: trusted}
 }
 
 func (i *SubnetInterseptor) AllowTrusted(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	var err error
 
+	// подсеть не задана - доверяем всем
+	if i.TrustedSubnet.Get() == nil {
+		return handler(ctx, req)
+	}
+
+	// проверки состояния доступны из любой подсети, их вызывает оркестратор
+	if strings.HasPrefix(info.FullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+		return handler(ctx, req)
+	}
+
 	md, ok := metadata.FromIncomingContext(ctx)
 	if ok {
 		realIP := md.Get("X-Real-IP")
@@ -29,13 +42,7 @@ func (i *SubnetInterseptor) AllowTrusted(ctx context.Context, req interface{}, i
 			return nil, status.Error(codes.Unauthenticated, "unauthorized")
 		}
 
-		if realIP[0] == "" {
-			return nil, status.Error(codes.Unauthenticated, "unauthorized")
-		}
-
-		ip := net.ParseIP(realIP[0])
-
-		if !i.TrustedSubnet.Contains(ip) {
+		if !i.TrustedSubnet.Allowed(realIP[0]) {
 			return nil, status.Error(codes.Unauthenticated, "unauthorized")
 		}
 
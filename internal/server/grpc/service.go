@@ -1,35 +1,68 @@
 package grpc
 
 import (
+	"errors"
+	"strconv"
+	"time"
+
 	"golang.org/x/net/context"
 	ggrpc "google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 
 	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/server/agents"
 	"github.com/smakimka/mtrcscollector/internal/server/config"
 	"github.com/smakimka/mtrcscollector/internal/server/grpc/interceptors"
+	"github.com/smakimka/mtrcscollector/internal/server/limits"
 	"github.com/smakimka/mtrcscollector/internal/storage"
+	"github.com/smakimka/mtrcscollector/internal/subnet"
 	pb "github.com/smakimka/mtrcscollector/protobuf/server"
 )
 
-func NewServer(cfg *config.Config, storage storage.Storage) *ggrpc.Server {
-	inters := []ggrpc.UnaryServerInterceptor{}
+// Option Дополнительные подсистемы, которые использует сервис.
+type Option func(s *Service)
 
-	if cfg.TrustedSubnet != nil {
-		subnetInterseptor := interceptors.NewSubnetInterseptor(cfg.TrustedSubnet)
-		inters = append(inters, subnetInterseptor.AllowTrusted)
+// Учитывать агентов, присылающих метрики, и отдавать их в Agents
+func WithAgents(registry *agents.Registry) Option {
+	return func(s *Service) {
+		s.agents = registry
 	}
+}
 
-	s := ggrpc.NewServer(ggrpc.ChainUnaryInterceptor(inters...))
+// Проверять адреса по доверенной подсети, которую можно заменить без перезапуска сервера
+func WithTrustedSubnet(trusted *subnet.Trusted) Option {
+	return func(s *Service) {
+		s.trusted = trusted
+	}
+}
+
+func NewServer(cfg *config.Config, storage storage.Storage, opts ...Option) *ggrpc.Server {
 	service := &Service{s: storage}
+	for _, opt := range opts {
+		opt(service)
+	}
+	if service.trusted == nil {
+		service.trusted = subnet.NewTrusted(cfg.TrustedSubnet)
+	}
+
+	subnetInterseptor := interceptors.NewSubnetInterseptor(service.trusted)
+	inters := []ggrpc.UnaryServerInterceptor{interceptors.Metrics, subnetInterseptor.AllowTrusted}
+
+	s := ggrpc.NewServer(ggrpc.ChainUnaryInterceptor(inters...))
 
 	pb.RegisterMetricsCollectorServer(s, service)
+	healthpb.RegisterHealthServer(s, NewHealthServer(storage))
 
 	return s
 }
 
 type Service struct {
 	pb.UnimplementedMetricsCollectorServer
-	s storage.Storage
+	s       storage.Storage
+	agents  *agents.Registry
+	trusted *subnet.Trusted
 }
 
 func (s *Service) Update(ctx context.Context, in *pb.UpdateMetrics) (*pb.Response, error) {
@@ -45,13 +78,70 @@ func (s *Service) Update(ctx context.Context, in *pb.UpdateMetrics) (*pb.Respons
 		})
 	}
 
-	err := s.s.UpdateMetrics(ctx, data)
+	report := agentReport(ctx, len(in.Metrics), s.trusted)
+	err := s.s.UpdateMetrics(limits.WithSource(ctx, report.IP), data)
 	if err != nil {
-		response.Ok = false
 		response.Detail = err.Error()
-		return &response, nil
+
+		// как 207 в http: пачка записана частично, отклоненные метрики перечислены в Detail
+		var rejectedErr *limits.RejectedError
+		if !errors.As(err, &rejectedErr) || rejectedErr.Written == 0 {
+			response.Ok = false
+			return &response, nil
+		}
+	}
+
+	if s.agents != nil {
+		s.agents.Seen(report, time.Now())
 	}
 
 	response.Ok = true
 	return &response, nil
 }
+
+// Сведения об агенте из метаданных запроса
+func agentReport(ctx context.Context, metricsCount int, trusted *subnet.Trusted) agents.Report {
+	report := agents.Report{Transport: agents.GRPC, MetricsCount: metricsCount}
+
+	var realIP, remoteAddr string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("X-Real-IP"); len(values) > 0 {
+			realIP = values[0]
+		}
+		if values := md.Get(model.AgentVersionHeader); len(values) > 0 {
+			report.Version = values[0]
+		}
+		if values := md.Get(model.ReportIntervalHeader); len(values) > 0 {
+			reportInterval, _ := strconv.Atoi(values[0])
+			report.ReportInterval = time.Duration(reportInterval) * time.Second
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+	report.IP = agents.AgentIP(realIP, remoteAddr, trusted)
+
+	return report
+}
+
+func (s *Service) Agents(ctx context.Context, in *pb.AgentsRequest) (*pb.AgentsResponse, error) {
+	response := &pb.AgentsResponse{Agents: []*pb.Agent{}}
+	if s.agents == nil {
+		return response, nil
+	}
+
+	for _, agent := range s.agents.List(time.Now()) {
+		response.Agents = append(response.Agents, &pb.Agent{
+			Ip:             agent.IP,
+			Version:        agent.Version,
+			Transport:      string(agent.Transport),
+			LastSeen:       agent.LastSeen.Unix(),
+			ReportInterval: int64(agent.ReportInterval),
+			MetricsCount:   int64(agent.MetricsCount),
+			Reports:        agent.Reports,
+			Stale:          agent.Stale,
+		})
+	}
+
+	return response, nil
+}
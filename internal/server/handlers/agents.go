@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/smakimka/mtrcscollector/internal/server/agents"
+)
+
+type AgentsHandler struct {
+	registry *agents.Registry
+}
+
+func NewAgentsHandler(registry *agents.Registry) AgentsHandler {
+	return AgentsHandler{registry: registry}
+}
+
+// Agents godoc
+// @Tags Status
+// @Summary Запрос агентов, присылающих метрики
+// @ID Agents
+// @Accept  plain
+// @Produce json
+// @Success 200 {array} agents.Agent
+// @Router /agents [get]
+func (h AgentsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, h.registry.List(time.Now()))
+}
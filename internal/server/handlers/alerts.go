@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/smakimka/mtrcscollector/internal/server/alerts"
+)
+
+type AlertsHandler struct {
+	e *alerts.Engine
+}
+
+func NewAlertsHandler(e *alerts.Engine) AlertsHandler {
+	return AlertsHandler{e: e}
+}
+
+// Alerts godoc
+// @Tags Status
+// @Summary Запрос активных алертов
+// @ID Alerts
+// @Accept  plain
+// @Produce json
+// @Success 200 {array} alerts.Alert
+// @Router /alerts [get]
+func (h AlertsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, h.e.Active())
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	"github.com/smakimka/mtrcscollector/internal/ingest"
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+var ErrEmptyPrefix = errors.New("prefix query parameter is required")
+
+type DeleteMetricHandler struct {
+	s storage.Storage
+}
+
+func NewDeleteMetricHandler(s storage.Storage) DeleteMetricHandler {
+	return DeleteMetricHandler{s: s}
+}
+
+// DeleteMetric godoc
+// @Tags Update
+// @Summary Запрос для удаления метрики
+// @ID DeleteMetric
+// @Accept  plain
+// @Produce plain
+// @Param metricKind path string true "Тип метрики"
+// @Param metricName path string true "имя метрики"
+// @Param HashSHA256 header string false "HMAC-SHA256 пути запроса, обязателен вне доверенной подсети при заданном ключе"
+// @Success 200 {string} string ""
+// @Failure 500 {string} string "ошибка"
+// @Failure 404 {string} string ""
+// @Failure 403 {string} string ""
+// @Router /value/{metricKind}/{metricName} [delete]
+func (h DeleteMetricHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	err := h.s.Delete(ctx, chi.URLParam(r, "metricKind"), ingest.URLParam(r, "metricName"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNoSuchMetric) {
+			render.Status(r, http.StatusNotFound)
+			render.PlainText(w, r, err.Error())
+			return
+		}
+		logger.Log.Err(err).Msg("error deleting metric")
+		render.Status(r, http.StatusInternalServerError)
+		render.PlainText(w, r, err.Error())
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.PlainText(w, r, "")
+}
+
+type DeleteMetricsHandler struct {
+	s storage.Storage
+}
+
+func NewDeleteMetricsHandler(s storage.Storage) DeleteMetricsHandler {
+	return DeleteMetricsHandler{s: s}
+}
+
+// DeleteMetrics godoc
+// @Tags Update
+// @Summary Запрос для удаления всех метрик типа с именами, начинающимися с префикса
+// @ID DeleteMetrics
+// @Accept  plain
+// @Produce json
+// @Param metricKind path string true "Тип метрики"
+// @Param prefix query string true "Префикс имени метрики"
+// @Param HashSHA256 header string false "HMAC-SHA256 пути запроса с параметрами, обязателен вне доверенной подсети при заданном ключе"
+// @Success 200 {object} model.DeleteResponse
+// @Failure 400 {object} model.Response
+// @Failure 403 {string} string ""
+// @Failure 500 {object} model.Response
+// @Router /value/{metricKind}/ [delete]
+func (h DeleteMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// пустой префикс удалил бы все метрики типа, такое удаление надо запрашивать явно
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		render.Status(r, http.StatusBadRequest)
+		render.JSON(w, r, model.Response{Ok: false, Detail: ErrEmptyPrefix.Error()})
+		return
+	}
+
+	deleted, err := h.s.DeleteByPrefix(ctx, chi.URLParam(r, "metricKind"), prefix)
+	if err != nil {
+		logger.Log.Err(err).Msg("error deleting metrics")
+		render.Status(r, http.StatusInternalServerError)
+		render.JSON(w, r, model.Response{Ok: false, Detail: err.Error()})
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, model.DeleteResponse{Deleted: deleted})
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// HealthCheckTimeout Время на проверку готовности хранилища.
+const HealthCheckTimeout = 5 * time.Second
+
+type HealthzHandler struct{}
+
+func NewHealthzHandler() HealthzHandler {
+	return HealthzHandler{}
+}
+
+// Healthz godoc
+// @Tags Status
+// @Summary Проверка, что сервис запущен
+// @ID Healthz
+// @Accept  plain
+// @Produce json
+// @Success 200 {object} model.Response
+// @Router /healthz [get]
+func (h HealthzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, model.Response{Ok: true})
+}
+
+type ReadyzHandler struct {
+	s storage.Storage
+}
+
+func NewReadyzHandler(s storage.Storage) ReadyzHandler {
+	return ReadyzHandler{s: s}
+}
+
+// Readyz godoc
+// @Tags Status
+// @Summary Проверка, что хранилище готово обслуживать запросы
+// @ID Readyz
+// @Accept  plain
+// @Produce json
+// @Success 200 {object} model.Response
+// @Failure 503 {object} model.Response
+// @Router /readyz [get]
+func (h ReadyzHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), HealthCheckTimeout)
+	defer cancel()
+
+	if err := storage.CheckHealth(ctx, h.s); err != nil {
+		logger.Log.Warn().Err(err).Msg("storage is not ready")
+		render.Status(r, http.StatusServiceUnavailable)
+		render.JSON(w, r, model.Response{Ok: false, Detail: err.Error()})
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, model.Response{Ok: true})
+}
@@ -30,7 +30,7 @@ func (h PingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	switch s := h.s.(type) {
+	switch s := storage.Unwrap(h.s).(type) {
 	case storage.PGStorage:
 		err := s.Ping(ctx)
 		if err != nil {
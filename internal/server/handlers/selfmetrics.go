@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/smakimka/mtrcscollector/internal/server/selfmetrics"
+)
+
+type SelfMetricsHandler struct {
+	registry *selfmetrics.Registry
+}
+
+func NewSelfMetricsHandler(registry *selfmetrics.Registry) SelfMetricsHandler {
+	return SelfMetricsHandler{registry: registry}
+}
+
+// SelfMetrics godoc
+// @Tags Status
+// @Summary Запрос метрик сервера о себе
+// @ID SelfMetrics
+// @Accept  plain
+// @Produce json
+// @Success 200 {object} model.MetricsData
+// @Router /internal/metrics [get]
+func (h SelfMetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, h.registry.MetricsData())
+}
@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 
+	"github.com/smakimka/mtrcscollector/internal/ingest"
 	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/server/limits"
 	"github.com/smakimka/mtrcscollector/internal/storage"
 )
 
@@ -31,57 +33,34 @@ func NewUpdateMetricHandler(s storage.Storage) UpdateMetricHandler {
 // @Param metricValue path string true "Значение метрики"
 // @Success 200 {string} string "20"
 // @Failure 400 {string} string "ошибка"
+// @Failure 429 {string} string "превышено ограничение на количество метрик"
 // @Failure 500 {object} string "ошибка"
 // @Router /update/{metricKind}/{metricName}/{metricValue} [post]
 func (h UpdateMetricHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
 	defer cancel()
 
-	switch chi.URLParam(r, "metricKind") {
-	case model.Gauge:
-		value, err := strconv.ParseFloat(chi.URLParam(r, "metricValue"), 64)
-		if err != nil {
-			render.Status(r, http.StatusBadRequest)
-			render.PlainText(w, r, err.Error())
-			return
-		}
-
-		err = h.s.UpdateGaugeMetric(ctx, model.GaugeMetric{
-			Name:  chi.URLParam(r, "metricName"),
-			Value: value,
-		})
-
-		if err != nil {
-			render.Status(r, http.StatusInternalServerError)
-			render.PlainText(w, r, err.Error())
-			return
-		}
-
-		render.Status(r, http.StatusOK)
-		render.PlainText(w, r, "")
+	m, err := ingest.ParseURLMetric(chi.URLParam(r, "metricKind"), ingest.URLParam(r, "metricName"), chi.URLParam(r, "metricValue"))
+	if err != nil {
+		render.Status(r, http.StatusBadRequest)
+		render.PlainText(w, r, err.Error())
+		return
+	}
 
+	switch m.Kind {
+	case model.Gauge:
+		err = h.s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: m.Name, Value: *m.Value})
 	case model.Counter:
-		value, err := strconv.ParseInt(chi.URLParam(r, "metricValue"), 10, 64)
-		if err != nil {
-			render.Status(r, http.StatusBadRequest)
-			render.PlainText(w, r, err.Error())
-			return
-		}
-
-		_, err = h.s.UpdateCounterMetric(ctx, model.CounterMetric{
-			Name:  chi.URLParam(r, "metricName"),
-			Value: value,
-		})
-
-		if err != nil {
-			render.Status(r, http.StatusInternalServerError)
-			render.PlainText(w, r, err.Error())
-			return
-		}
-
-		render.Status(r, http.StatusOK)
-		render.PlainText(w, r, "")
+		_, err = h.s.UpdateCounterMetric(ctx, model.CounterMetric{Name: m.Name, Value: *m.Delta})
 	}
+	if err != nil {
+		render.Status(r, updateErrorStatus(err))
+		render.PlainText(w, r, err.Error())
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.PlainText(w, r, "")
 }
 
 type UpdateHandler struct {
@@ -100,6 +79,7 @@ func NewUpdateHandler(s storage.Storage) UpdateHandler {
 // @Produce json
 // @Param metric body model.MetricData true "Метрика для обновления"
 // @Success 200 {object} model.MetricData
+// @Failure 429 {object} model.Response
 // @Failure 500 {object} model.Response
 // @Router /update/ [post]
 func (h UpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -126,8 +106,8 @@ func (h UpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Value: *data.Value,
 		})
 		if err != nil {
-			render.Status(r, http.StatusInternalServerError)
-			render.JSON(w, r, model.Response{Ok: false, Detail: err.Error()})
+			render.Status(r, updateErrorStatus(err))
+			render.JSON(w, r, updateErrorResponse(err))
 			return
 		}
 	case model.Counter:
@@ -142,8 +122,8 @@ func (h UpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			Value: *data.Delta,
 		})
 		if err != nil {
-			render.Status(r, http.StatusInternalServerError)
-			render.JSON(w, r, model.Response{Ok: false, Detail: err.Error()})
+			render.Status(r, updateErrorStatus(err))
+			render.JSON(w, r, updateErrorResponse(err))
 			return
 		}
 		data.Delta = &newVal
@@ -152,3 +132,29 @@ func (h UpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	render.Status(r, http.StatusOK)
 	render.JSON(w, r, data)
 }
+
+// Статус ответа на ошибку записи: 429, если метрики не записаны из-за ограничений,
+// 207, если из-за ограничений не записана только часть метрик
+func updateErrorStatus(err error) int {
+	var rejectedErr *limits.RejectedError
+	if errors.As(err, &rejectedErr) && rejectedErr.Written > 0 {
+		return http.StatusMultiStatus
+	}
+	if errors.Is(err, limits.ErrLimitExceeded) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// Ответ на ошибку записи со списком отклоненных метрик
+func updateErrorResponse(err error) model.Response {
+	response := model.Response{Ok: false, Detail: err.Error()}
+
+	var rejectedErr *limits.RejectedError
+	if errors.As(err, &rejectedErr) {
+		response.Rejected = rejectedErr.Names
+		response.Written = rejectedErr.Written
+	}
+
+	return response
+}
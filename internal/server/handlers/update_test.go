@@ -13,8 +13,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/smakimka/mtrcscollector/internal/ingest"
 	"github.com/smakimka/mtrcscollector/internal/logger"
-	mw "github.com/smakimka/mtrcscollector/internal/server/middleware"
 	"github.com/smakimka/mtrcscollector/internal/storage"
 )
 
@@ -48,7 +48,7 @@ func getTestUpdateRouter() chi.Router {
 
 	r.Post("/update", updateHandler.ServeHTTP)
 	r.Route("/update/{metricKind}", func(r chi.Router) {
-		r.Use(mw.MetricKind)
+		r.Use(ingest.MetricKind)
 		r.Post("/{metricName}/{metricValue}", updateMetricHandler.ServeHTTP)
 	})
 
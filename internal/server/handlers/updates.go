@@ -29,6 +29,8 @@ func NewUpdatesHandler(s storage.Storage) UpdatesHandler {
 // @Success 200 {object} model.Response
 // @Failure 500 {object} model.Response
 // @Failure 400 {object} model.Response
+// @Success 207 {object} model.Response "метрики сверх ограничений не записаны, остальные записаны"
+// @Failure 429 {object} model.Response "ни одна метрика не записана из-за ограничений"
 // @Router /updates/ [post]
 func (h UpdatesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(r.Context())
@@ -44,8 +46,8 @@ func (h UpdatesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	err := h.s.UpdateMetrics(ctx, *data)
 	if err != nil {
 		logger.Log.Err(err).Msg("error updating metrics")
-		render.Status(r, http.StatusInternalServerError)
-		render.JSON(w, r, model.Response{Ok: false, Detail: err.Error()})
+		render.Status(r, updateErrorStatus(err))
+		render.JSON(w, r, updateErrorResponse(err))
 		return
 	}
 
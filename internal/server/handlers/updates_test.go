@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +15,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/server/limits"
 	"github.com/smakimka/mtrcscollector/internal/storage"
 )
 
@@ -134,3 +137,57 @@ func TestUpdatesHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdatesHandlerLimits(t *testing.T) {
+	logger.SetLevel(logger.Info)
+	ctx := context.Background()
+	s, err := limits.NewStorage(ctx, storage.NewMemStorage(), limits.Limits{MaxSeries: 2})
+	require.NoError(t, err)
+
+	r := chi.NewRouter()
+	r.Post("/updates/", NewUpdatesHandler(s).ServeHTTP)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		body         string
+		wantCode     int
+		wantRejected []string
+		wantWritten  int
+	}{
+		{
+			name:         "partially written",
+			body:         `[{"id":"A","type":"gauge","value":1},{"id":"B","type":"gauge","value":1},{"id":"C","type":"gauge","value":1}]`,
+			wantCode:     http.StatusMultiStatus,
+			wantRejected: []string{"C"},
+			wantWritten:  2,
+		},
+		{
+			name:         "nothing written",
+			body:         `[{"id":"D","type":"gauge","value":1}]`,
+			wantCode:     http.StatusTooManyRequests,
+			wantRejected: []string{"D"},
+		},
+		{
+			name:     "existing metrics only",
+			body:     `[{"id":"A","type":"gauge","value":2}]`,
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, body := testUpdatesRequest(t, ts, http.MethodPost, "/updates/", bytes.NewBufferString(test.body))
+			assert.Equal(t, test.wantCode, resp.StatusCode)
+			if test.wantCode == http.StatusOK {
+				return
+			}
+
+			var response model.Response
+			require.NoError(t, json.Unmarshal([]byte(body), &response))
+			assert.Equal(t, test.wantRejected, response.Rejected)
+			assert.Equal(t, test.wantWritten, response.Written)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 
+	"github.com/smakimka/mtrcscollector/internal/ingest"
 	"github.com/smakimka/mtrcscollector/internal/model"
 	"github.com/smakimka/mtrcscollector/internal/storage"
 )
@@ -36,7 +37,7 @@ func (h GetMetricValueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	defer cancel()
 	switch chi.URLParam(r, "metricKind") {
 	case model.Gauge:
-		metric, err := h.s.GetGaugeMetric(ctx, chi.URLParam(r, "metricName"))
+		metric, err := h.s.GetGaugeMetric(ctx, ingest.URLParam(r, "metricName"))
 
 		if err != nil {
 			if err == storage.ErrNoSuchMetric {
@@ -53,7 +54,7 @@ func (h GetMetricValueHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		render.PlainText(w, r, metric.GetStringValue())
 
 	case model.Counter:
-		metric, err := h.s.GetCounterMetric(ctx, chi.URLParam(r, "metricName"))
+		metric, err := h.s.GetCounterMetric(ctx, ingest.URLParam(r, "metricName"))
 
 		if err != nil {
 			if err == storage.ErrNoSuchMetric {
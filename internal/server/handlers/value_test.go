@@ -13,9 +13,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/smakimka/mtrcscollector/internal/ingest"
 	"github.com/smakimka/mtrcscollector/internal/logger"
 	"github.com/smakimka/mtrcscollector/internal/model"
-	mw "github.com/smakimka/mtrcscollector/internal/server/middleware"
 	"github.com/smakimka/mtrcscollector/internal/storage"
 )
 
@@ -51,7 +51,7 @@ func getTestValueRouter() chi.Router {
 
 	r.Post("/value", valueHandler.ServeHTTP)
 	r.Route("/value/{metricKind}", func(r chi.Router) {
-		r.Use(mw.MetricKind)
+		r.Use(ingest.MetricKind)
 		r.Get("/{metricName}", getMetricValueHandler.ServeHTTP)
 	})
 
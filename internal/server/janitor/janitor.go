@@ -0,0 +1,60 @@
+// Модуль janitor удаляет из хранилища метрики, которые давно не обновлялись
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// MaxCheckInterval Наибольший интервал между проверками, чтобы при большом ttl метрики не жили почти вдвое дольше.
+const MaxCheckInterval = time.Minute
+
+// Janitor Удаляет метрики, которые не обновлялись дольше ttl.
+type Janitor struct {
+	s   storage.Storage
+	ttl time.Duration
+}
+
+func NewJanitor(s storage.Storage, ttl time.Duration) *Janitor {
+	return &Janitor{s: s, ttl: ttl}
+}
+
+// Интервал проверок: ttl, но не больше MaxCheckInterval
+func (j *Janitor) CheckInterval() time.Duration {
+	return min(j.ttl, MaxCheckInterval)
+}
+
+// Удаление устаревших метрик раз в CheckInterval до отмены контекста
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.CheckInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := j.Clean(ctx, now); err != nil {
+				logger.Log.Err(err).Msg("error deleting stale metrics")
+			}
+		}
+	}
+}
+
+// Удаление метрик, не обновлявшихся с now - ttl, возвращает количество удаленных
+func (j *Janitor) Clean(ctx context.Context, now time.Time) (int64, error) {
+	deleted, err := j.s.DeleteStale(ctx, now.Add(-j.ttl))
+	if err != nil {
+		return 0, err
+	}
+
+	if deleted > 0 {
+		logger.Log.Info().Msg(fmt.Sprintf("deleted %d stale metrics", deleted))
+	}
+
+	return deleted, nil
+}
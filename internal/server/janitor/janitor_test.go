@@ -0,0 +1,37 @@
+package janitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func TestJanitorClean(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1}))
+
+	j := NewJanitor(s, time.Hour)
+
+	deleted, err := j.Clean(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+
+	deleted, err = j.Clean(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = s.GetGaugeMetric(ctx, "Alloc")
+	assert.ErrorIs(t, err, storage.ErrNoSuchMetric)
+}
+
+func TestJanitorCheckInterval(t *testing.T) {
+	assert.Equal(t, 10*time.Second, NewJanitor(nil, 10*time.Second).CheckInterval())
+	assert.Equal(t, MaxCheckInterval, NewJanitor(nil, time.Hour).CheckInterval())
+}
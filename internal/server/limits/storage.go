@@ -0,0 +1,303 @@
+// Модуль limits ограничивает количество различных метрик на сервере, всего и для каждого источника
+package limits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+var (
+	ErrLimitExceeded                 = errors.New("series limit exceeded")
+	_                storage.Storage = (*Storage)(nil)
+)
+
+// RejectedError Метрики, которые не были записаны из-за ограничений, остальные Written метрик записаны.
+type RejectedError struct {
+	Names   []string
+	Written int
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%s, rejected: %s, written: %d", ErrLimitExceeded, strings.Join(e.Names, ", "), e.Written)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// Limits Ограничения на количество различных метрик, 0 - без ограничения.
+type Limits struct {
+	MaxSeries          int
+	MaxSeriesPerSource int
+}
+
+type sourceKey struct{}
+
+// Контекст с источником записи (IP агента), по нему считается ограничение на источник
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func sourceFrom(ctx context.Context) string {
+	source, _ := ctx.Value(sourceKey{}).(string)
+	return source
+}
+
+// Storage Обертка над хранилищем, которая не дает создавать новые метрики сверх ограничений.
+// Записи без источника (например, от правил записи) ограничены только общим количеством.
+type Storage struct {
+	storage.Storage
+	limits Limits
+
+	// метрика (тип/имя) -> источник, который ее создал, для метрик, загруженных из хранилища, источник пустой
+	series    map[string]string
+	perSource map[string]int
+	rejected  atomic.Int64
+	mutex     sync.Mutex
+}
+
+func NewStorage(ctx context.Context, s storage.Storage, limits Limits) (*Storage, error) {
+	ls := &Storage{
+		Storage:   s,
+		limits:    limits,
+		series:    make(map[string]string),
+		perSource: make(map[string]int),
+	}
+
+	if err := ls.sync(ctx); err != nil {
+		return nil, err
+	}
+
+	return ls, nil
+}
+
+// Хранилище, которое оборачивает ограничение
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+// Количество различных метрик
+func (s *Storage) Series() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.series)
+}
+
+// Количество отклоненных записей с момента запуска
+func (s *Storage) Rejected() int64 {
+	return s.rejected.Load()
+}
+
+func seriesKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// Перечитать метрики из хранилища, источники сохраняются для метрик, которые остались
+func (s *Storage) sync(ctx context.Context) error {
+	gaugeMetrics, err := s.Storage.GetAllGaugeMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	counterMetrics, err := s.Storage.GetAllCounterMetrics(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	series := make(map[string]string, len(gaugeMetrics)+len(counterMetrics))
+	for _, m := range gaugeMetrics {
+		key := seriesKey(model.Gauge, m.Name)
+		series[key] = s.series[key]
+	}
+	for _, m := range counterMetrics {
+		key := seriesKey(model.Counter, m.Name)
+		series[key] = s.series[key]
+	}
+
+	s.series = series
+	s.perSource = make(map[string]int)
+	for _, source := range series {
+		if source != "" {
+			s.perSource[source]++
+		}
+	}
+
+	return nil
+}
+
+// Зарезервировать метрики под запись, возвращает новые метрики и индексы отклоненных
+func (s *Storage) reserve(source string, keys []string) ([]string, []int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var added []string
+	var rejected []int
+	for i, key := range keys {
+		if _, ok := s.series[key]; ok {
+			continue
+		}
+
+		if s.limits.MaxSeries > 0 && len(s.series) >= s.limits.MaxSeries {
+			rejected = append(rejected, i)
+			continue
+		}
+		if source != "" && s.limits.MaxSeriesPerSource > 0 && s.perSource[source] >= s.limits.MaxSeriesPerSource {
+			rejected = append(rejected, i)
+			continue
+		}
+
+		s.series[key] = source
+		if source != "" {
+			s.perSource[source]++
+		}
+		added = append(added, key)
+	}
+
+	s.rejected.Add(int64(len(rejected)))
+	return added, rejected
+}
+
+// Снять резерв с метрик, запись которых не удалась
+func (s *Storage) release(keys []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, key := range keys {
+		s.forget(key)
+	}
+}
+
+// Удалить метрику из учета, вызывается под блокировкой
+func (s *Storage) forget(key string) {
+	source, ok := s.series[key]
+	if !ok {
+		return
+	}
+
+	delete(s.series, key)
+	if source != "" {
+		s.perSource[source]--
+		if s.perSource[source] <= 0 {
+			delete(s.perSource, source)
+		}
+	}
+}
+
+func (s *Storage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMetric) error {
+	added, rejected := s.reserve(sourceFrom(ctx), []string{seriesKey(model.Gauge, m.Name)})
+	if len(rejected) > 0 {
+		return &RejectedError{Names: []string{m.Name}}
+	}
+
+	if err := s.Storage.UpdateGaugeMetric(ctx, m); err != nil {
+		s.release(added)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) UpdateCounterMetric(ctx context.Context, m model.CounterMetric) (int64, error) {
+	added, rejected := s.reserve(sourceFrom(ctx), []string{seriesKey(model.Counter, m.Name)})
+	if len(rejected) > 0 {
+		return 0, &RejectedError{Names: []string{m.Name}}
+	}
+
+	value, err := s.Storage.UpdateCounterMetric(ctx, m)
+	if err != nil {
+		s.release(added)
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// Записывает метрики, которые укладываются в ограничения, об остальных сообщает RejectedError
+func (s *Storage) UpdateMetrics(ctx context.Context, metricsData model.MetricsData) error {
+	keys := make([]string, len(metricsData))
+	for i, metricData := range metricsData {
+		keys[i] = seriesKey(metricData.Kind, metricData.Name)
+	}
+
+	added, rejected := s.reserve(sourceFrom(ctx), keys)
+	if len(rejected) == 0 {
+		if err := s.Storage.UpdateMetrics(ctx, metricsData); err != nil {
+			s.release(added)
+			return err
+		}
+		return nil
+	}
+
+	rejectedErr := &RejectedError{}
+	allowed := make(model.MetricsData, 0, len(metricsData)-len(rejected))
+	for i, j := 0, 0; i < len(metricsData); i++ {
+		if j < len(rejected) && rejected[j] == i {
+			rejectedErr.Names = append(rejectedErr.Names, metricsData[i].Name)
+			j++
+			continue
+		}
+		allowed = append(allowed, metricsData[i])
+	}
+
+	if len(allowed) > 0 {
+		if err := s.Storage.UpdateMetrics(ctx, allowed); err != nil {
+			s.release(added)
+			return err
+		}
+	}
+
+	rejectedErr.Written = len(allowed)
+	return rejectedErr
+}
+
+func (s *Storage) Delete(ctx context.Context, kind, name string) error {
+	if err := s.Storage.Delete(ctx, kind, name); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.forget(seriesKey(kind, name))
+	return nil
+}
+
+func (s *Storage) DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error) {
+	deleted, err := s.Storage.DeleteByPrefix(ctx, kind, prefix)
+	if err != nil {
+		return deleted, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keyPrefix := seriesKey(kind, prefix)
+	for key := range s.series {
+		if strings.HasPrefix(key, keyPrefix) {
+			s.forget(key)
+		}
+	}
+
+	return deleted, nil
+}
+
+// Какие метрики устарели, знает только хранилище, поэтому после удаления учет перечитывается
+func (s *Storage) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	deleted, err := s.Storage.DeleteStale(ctx, before)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	return deleted, s.sync(ctx)
+}
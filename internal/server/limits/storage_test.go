@@ -0,0 +1,119 @@
+package limits
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func gauge(name string, value float64) model.MetricData {
+	return model.MetricData{Name: name, Kind: model.Gauge, Value: &value}
+}
+
+func TestStorageLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		limits       Limits
+		source       string
+		batch        model.MetricsData
+		wantRejected []string
+	}{
+		{
+			name:   "existing metrics are always updated",
+			limits: Limits{MaxSeries: 1},
+			source: "10.0.0.1",
+			batch:  model.MetricsData{gauge("Existing", 2)},
+		},
+		{
+			name:         "global limit",
+			limits:       Limits{MaxSeries: 2},
+			source:       "10.0.0.1",
+			batch:        model.MetricsData{gauge("A", 1), gauge("B", 1), gauge("Existing", 2)},
+			wantRejected: []string{"B"},
+		},
+		{
+			name:         "per source limit",
+			limits:       Limits{MaxSeriesPerSource: 2},
+			source:       "10.0.0.1",
+			batch:        model.MetricsData{gauge("A", 1), gauge("A", 2), gauge("B", 1), gauge("C", 1)},
+			wantRejected: []string{"C"},
+		},
+		{
+			name:   "per source limit does not apply without source",
+			limits: Limits{MaxSeriesPerSource: 1},
+			batch:  model.MetricsData{gauge("A", 1), gauge("B", 1)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			memStorage := storage.NewMemStorage()
+			require.NoError(t, memStorage.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Existing", Value: 1}))
+
+			s, err := NewStorage(ctx, memStorage, test.limits)
+			require.NoError(t, err)
+
+			err = s.UpdateMetrics(WithSource(ctx, test.source), test.batch)
+			if len(test.wantRejected) == 0 {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, ErrLimitExceeded)
+				var rejectedErr *RejectedError
+				require.ErrorAs(t, err, &rejectedErr)
+				assert.Equal(t, test.wantRejected, rejectedErr.Names)
+				assert.Equal(t, len(test.batch)-len(test.wantRejected), rejectedErr.Written)
+			}
+			assert.Equal(t, int64(len(test.wantRejected)), s.Rejected())
+
+			// все не отклоненные метрики записаны
+			for _, m := range test.batch {
+				_, err = memStorage.GetGaugeMetric(ctx, m.Name)
+				if contains(test.wantRejected, m.Name) {
+					assert.ErrorIs(t, err, storage.ErrNoSuchMetric, m.Name)
+				} else {
+					assert.NoError(t, err, m.Name)
+				}
+			}
+		})
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStorageDeleteFreesQuota(t *testing.T) {
+	ctx := WithSource(context.Background(), "10.0.0.1")
+	memStorage := storage.NewMemStorage()
+
+	s, err := NewStorage(ctx, memStorage, Limits{MaxSeries: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "A", Value: 1}))
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "B", Value: 1})
+	require.ErrorIs(t, err, ErrLimitExceeded)
+
+	require.NoError(t, s.Delete(ctx, model.Gauge, "A"))
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "B", Value: 1})
+	require.NoError(t, err)
+
+	_, err = s.DeleteByPrefix(ctx, model.Counter, "B")
+	require.NoError(t, err)
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "C", Value: 1}))
+
+	_, err = s.DeleteStale(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.Series())
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/server/agents"
+	"github.com/smakimka/mtrcscollector/internal/subnet"
+)
+
+type AgentsMiddleware struct {
+	Registry      *agents.Registry
+	TrustedSubnet *subnet.Trusted
+}
+
+func NewAgentsMiddleware(registry *agents.Registry, trusted *subnet.Trusted) *AgentsMiddleware {
+	return &AgentsMiddleware{Registry: registry, TrustedSubnet: trusted}
+}
+
+// Учитывает агента после успешной, в том числе частичной (207), обработки запроса на обновление метрик
+func (m *AgentsMiddleware) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsCount := 1
+		if strings.HasPrefix(r.URL.Path, "/updates") {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+			var metrics []json.RawMessage
+			if err = json.Unmarshal(body, &metrics); err == nil {
+				metricsCount = len(metrics)
+			}
+		}
+
+		statusWriter := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(statusWriter, r)
+		if statusWriter.status != http.StatusOK && statusWriter.status != http.StatusMultiStatus {
+			return
+		}
+
+		reportInterval, _ := strconv.Atoi(r.Header.Get(model.ReportIntervalHeader))
+		m.Registry.Seen(agents.Report{
+			IP:             agents.AgentIP(r.Header.Get("X-Real-IP"), r.RemoteAddr, m.TrustedSubnet),
+			Version:        r.Header.Get(model.AgentVersionHeader),
+			Transport:      agents.HTTP,
+			ReportInterval: time.Duration(reportInterval) * time.Second,
+			MetricsCount:   metricsCount,
+		}, time.Now())
+	})
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	w.status = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
@@ -2,12 +2,15 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"hash"
 	"io"
 	"net/http"
 
 	"github.com/smakimka/mtrcscollector/internal/auth"
+	"github.com/smakimka/mtrcscollector/internal/logger"
 )
 
 type HashingResponseWriter struct {
@@ -24,6 +27,25 @@ func (w *HashingResponseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+var ErrLegacySign = errors.New("HashSHA256 is in the old format (data followed by HMAC of an empty string), sign the request with HMAC-SHA256 of the body")
+
+type signedKey struct{}
+
+// Прошел ли запрос проверку подписи в Auth
+func Signed(ctx context.Context) bool {
+	signed, _ := ctx.Value(signedKey{}).(bool)
+	return signed
+}
+
+// Данные, которые подписывает клиент: тело запроса, а у DELETE без тела путь с параметрами,
+// иначе одна подпись пустого тела подошла бы к любому удалению
+func signedData(r *http.Request, body []byte) []byte {
+	if r.Method == http.MethodDelete && len(body) == 0 {
+		return []byte(r.URL.RequestURI())
+	}
+	return body
+}
+
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !auth.Enabled() {
@@ -50,7 +72,7 @@ func Auth(next http.Handler) http.Handler {
 			return
 		}
 
-		ok, err := auth.Check(decodedSign, body)
+		ok, err := auth.Check(decodedSign, signedData(r, body))
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -58,12 +80,16 @@ func Auth(next http.Handler) http.Handler {
 
 		if !ok {
 			w.WriteHeader(http.StatusBadRequest)
+			if auth.IsLegacy(decodedSign, signedData(r, body)) {
+				logger.Log.Warn().Msg("rejected request signed in the old format, the agent must be upgraded")
+				w.Write([]byte(ErrLegacySign.Error()))
+			}
 			return
 		}
 
 		hashingWriter := &HashingResponseWriter{w, auth.GetHasher()}
 		r.Body = io.NopCloser(bytes.NewBuffer(body))
-		next.ServeHTTP(hashingWriter, r)
+		next.ServeHTTP(hashingWriter, r.WithContext(context.WithValue(r.Context(), signedKey{}, true)))
 		sign = string(hashingWriter.hasher.Sum(nil))
 		w.Header().Add("HashSHA256", sign)
 	})
@@ -2,9 +2,14 @@ package middleware
 
 import (
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
 	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/server/selfmetrics"
 )
 
 type (
@@ -42,6 +47,7 @@ func Logger(next http.Handler) http.Handler {
 		next.ServeHTTP(&lw, r)
 
 		duration := time.Since(start)
+		observeRequest(r, responseData.status, duration)
 
 		logger.Log.Info().
 			Str("url", url).
@@ -53,3 +59,22 @@ func Logger(next http.Handler) http.Handler {
 	})
 
 }
+
+// Учет запроса в метриках сервера, запросы группируются по шаблону роута, а не по url
+func observeRequest(r *http.Request, status int, duration time.Duration) {
+	// обработчик, который не вызвал WriteHeader, отвечает 200
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	route := "unmatched"
+	if routeCtx := chi.RouteContext(r.Context()); routeCtx != nil && routeCtx.RoutePattern() != "" {
+		route = routeCtx.RoutePattern()
+	}
+
+	selfmetrics.Default.Observe("HTTPRequest", model.Labels{
+		"method": r.Method,
+		"route":  route,
+		"status": strconv.Itoa(status),
+	}, duration)
+}
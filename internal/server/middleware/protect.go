@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/smakimka/mtrcscollector/internal/auth"
+	"github.com/smakimka/mtrcscollector/internal/server/agents"
+	"github.com/smakimka/mtrcscollector/internal/subnet"
+)
+
+type ProtectMiddleware struct {
+	TrustedSubnet *subnet.Trusted
+}
+
+func NewProtectMiddleware(trusted *subnet.Trusted) *ProtectMiddleware {
+	return &ProtectMiddleware{TrustedSubnet: trusted}
+}
+
+// Пропускает разрушающие запросы (удаление метрик) только с проверенной подписью или из доверенной подсети.
+// Auth пропускает запросы без подписи, поэтому здесь отсутствие подписи тоже отказ. Если не задан
+// ни ключ, ни подсеть, сервер открыт целиком и запрос пропускается
+func (m *ProtectMiddleware) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.Enabled() && m.TrustedSubnet.Get() == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		source := agents.AgentIP(r.Header.Get("X-Real-IP"), r.RemoteAddr, m.TrustedSubnet)
+		if !Signed(r.Context()) && !m.TrustedSubnet.Contains(source) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
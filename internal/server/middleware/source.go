@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/smakimka/mtrcscollector/internal/server/agents"
+	"github.com/smakimka/mtrcscollector/internal/server/limits"
+	"github.com/smakimka/mtrcscollector/internal/subnet"
+)
+
+type SourceMiddleware struct {
+	TrustedSubnet *subnet.Trusted
+}
+
+func NewSourceMiddleware(trusted *subnet.Trusted) *SourceMiddleware {
+	return &SourceMiddleware{TrustedSubnet: trusted}
+}
+
+// Кладет в контекст источник запроса (IP агента) для ограничений на количество метрик
+func (m *SourceMiddleware) Source(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := agents.AgentIP(r.Header.Get("X-Real-IP"), r.RemoteAddr, m.TrustedSubnet)
+		next.ServeHTTP(w, r.WithContext(limits.WithSource(r.Context(), source)))
+	})
+}
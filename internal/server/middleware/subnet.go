@@ -1,30 +1,22 @@
 package middleware
 
 import (
-	"net"
 	"net/http"
+
+	"github.com/smakimka/mtrcscollector/internal/subnet"
 )
 
 type SubnetMiddleware struct {
-	TrustedSubnet *net.IPNet
+	TrustedSubnet *subnet.Trusted
 }
 
-func NewSubnetMiddleware(subnet *net.IPNet) *SubnetMiddleware {
-	return &SubnetMiddleware{TrustedSubnet: subnet}
+func NewSubnetMiddleware(trusted *subnet.Trusted) *SubnetMiddleware {
+	return &SubnetMiddleware{TrustedSubnet: trusted}
 }
 
 func (m *SubnetMiddleware) AllowTrusted(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-		realIP := r.Header.Get("X-Real-IP")
-		if realIP == "" {
-			w.WriteHeader(http.StatusForbidden)
-			return
-		}
-
-		ip := net.ParseIP(realIP)
-
-		if !m.TrustedSubnet.Contains(ip) {
+		if !m.TrustedSubnet.Allowed(r.Header.Get("X-Real-IP")) {
 			w.WriteHeader(http.StatusForbidden)
 			return
 		}
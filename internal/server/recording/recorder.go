@@ -0,0 +1,206 @@
+package recording
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+// rateState Значение счетчика при прошлом вычислении rate.
+type rateState struct {
+	at    time.Time
+	value float64
+}
+
+// ruleState Состояние правила между вычислениями, rate хранится отдельно для левого и правого операнда.
+type ruleState struct {
+	rule  Rule
+	rates [2]*rateState
+}
+
+// Recorder Периодически вычисляет правила записи и сохраняет результаты как gauge метрики.
+type Recorder struct {
+	s      storage.Storage
+	states []*ruleState
+	mutex  sync.Mutex
+}
+
+func NewRecorder(s storage.Storage, rules []Rule) *Recorder {
+	r := &Recorder{s: s}
+	for _, rule := range rules {
+		r.states = append(r.states, &ruleState{rule: rule})
+	}
+
+	return r
+}
+
+// Вычисление правил раз в interval до отмены контекста
+func (r *Recorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := r.Evaluate(ctx, now); err != nil {
+				logger.Log.Err(err).Msg("error evaluating recording rules")
+			}
+		}
+	}
+}
+
+// snapshot Все метрики хранилища, читаются один раз за вычисление и только если нужны для sum.
+type snapshot struct {
+	gauges   []model.GaugeMetric
+	counters []model.CounterMetric
+	loaded   bool
+}
+
+// Вычисление всех правил на момент now и запись результатов одним обновлением.
+// Правила, для которых нет данных (нет метрики, первое вычисление rate, деление на 0), пропускаются
+func (r *Recorder) Evaluate(ctx context.Context, now time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	metricsData := model.MetricsData{}
+	snap := &snapshot{}
+	var errs []error
+
+	for _, rs := range r.states {
+		value, ok, err := r.evaluateRule(ctx, rs, snap, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rs.rule.Record, err))
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		metricsData = append(metricsData, model.MetricData{
+			Name:  rs.rule.Record,
+			Kind:  model.Gauge,
+			Value: &value,
+		})
+	}
+
+	if len(metricsData) > 0 {
+		if err := r.s.UpdateMetrics(ctx, metricsData); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (r *Recorder) evaluateRule(ctx context.Context, rs *ruleState, snap *snapshot, now time.Time) (float64, bool, error) {
+	left, leftOk, err := r.evaluateTerm(ctx, rs.rule.left, &rs.rates[0], snap, now)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if rs.rule.right == nil {
+		return left, leftOk, nil
+	}
+
+	// правый операнд вычисляется даже без левого, чтобы rate не пропускал вычисления
+	right, rightOk, err := r.evaluateTerm(ctx, *rs.rule.right, &rs.rates[1], snap, now)
+	if err != nil {
+		return 0, false, err
+	}
+	if !leftOk || !rightOk {
+		return 0, false, nil
+	}
+
+	value, ok := operators[rs.rule.operator](left, right)
+	return value, ok, nil
+}
+
+func (r *Recorder) evaluateTerm(ctx context.Context, t term, rate **rateState, snap *snapshot, now time.Time) (float64, bool, error) {
+	if t.kind == "" {
+		return t.number, true, nil
+	}
+
+	if t.fn == funcSum {
+		return r.sum(ctx, t, snap)
+	}
+
+	value, ok, err := r.get(ctx, t)
+	if err != nil || !ok || t.fn != funcRate {
+		return value, ok, err
+	}
+
+	last := *rate
+	*rate = &rateState{at: now, value: value}
+	if last == nil || !now.After(last.at) {
+		return 0, false, nil
+	}
+
+	delta := value - last.value
+	if delta < 0 {
+		// счетчик сбросился, например после перезапуска агента
+		delta = value
+	}
+
+	return delta / now.Sub(last.at).Seconds(), true, nil
+}
+
+func (r *Recorder) get(ctx context.Context, t term) (float64, bool, error) {
+	switch t.kind {
+	case model.Gauge:
+		m, err := r.s.GetGaugeMetric(ctx, t.metric)
+		if errors.Is(err, storage.ErrNoSuchMetric) {
+			return 0, false, nil
+		}
+		return m.Value, err == nil, err
+	default:
+		m, err := r.s.GetCounterMetric(ctx, t.metric)
+		if errors.Is(err, storage.ErrNoSuchMetric) {
+			return 0, false, nil
+		}
+		return float64(m.Value), err == nil, err
+	}
+}
+
+// Сумма всех метрик с базовым именем t.metric, независимо от меток
+func (r *Recorder) sum(ctx context.Context, t term, snap *snapshot) (float64, bool, error) {
+	if !snap.loaded {
+		var err error
+		if snap.gauges, err = r.s.GetAllGaugeMetrics(ctx); err != nil {
+			return 0, false, err
+		}
+		if snap.counters, err = r.s.GetAllCounterMetrics(ctx); err != nil {
+			return 0, false, err
+		}
+		snap.loaded = true
+	}
+
+	var total float64
+	found := false
+
+	switch t.kind {
+	case model.Gauge:
+		for _, m := range snap.gauges {
+			if name, _ := model.ParseLabeledName(m.Name); name == t.metric {
+				total += m.Value
+				found = true
+			}
+		}
+	default:
+		for _, m := range snap.counters {
+			if name, _ := model.ParseLabeledName(m.Name); name == t.metric {
+				total += float64(m.Value)
+				found = true
+			}
+		}
+	}
+
+	return total, found, nil
+}
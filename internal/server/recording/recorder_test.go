@@ -0,0 +1,77 @@
+package recording
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func mustParseRule(t *testing.T, record, expr string) Rule {
+	rule, err := ParseRule(record, expr)
+	require.NoError(t, err)
+	return rule
+}
+
+func TestRecorderRatioAndSum(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+
+	heapInuse, heapSys, zero := 25.0, 100.0, 0.0
+	rootUsed, homeUsed := 10.0, 5.0
+	require.NoError(t, s.UpdateMetrics(ctx, model.MetricsData{
+		{Name: "HeapInuse", Kind: model.Gauge, Value: &heapInuse},
+		{Name: "HeapSys", Kind: model.Gauge, Value: &heapSys},
+		{Name: "Zero", Kind: model.Gauge, Value: &zero},
+		{Name: model.LabeledName("DiskUsed", model.Labels{"mount": "/"}), Kind: model.Gauge, Value: &rootUsed},
+		{Name: model.LabeledName("DiskUsed", model.Labels{"mount": "/home"}), Kind: model.Gauge, Value: &homeUsed},
+	}))
+
+	r := NewRecorder(s, []Rule{
+		mustParseRule(t, "HeapInuseRatio", "gauge HeapInuse / gauge HeapSys"),
+		mustParseRule(t, "DiskUsedTotal", "sum(gauge DiskUsed)"),
+		mustParseRule(t, "DivByZero", "gauge HeapInuse / gauge Zero"),
+		mustParseRule(t, "Missing", "gauge NoSuchMetric + 1"),
+	})
+	require.NoError(t, r.Evaluate(ctx, time.Now()))
+
+	ratio, err := s.GetGaugeMetric(ctx, "HeapInuseRatio")
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, ratio.Value)
+
+	total, err := s.GetGaugeMetric(ctx, "DiskUsedTotal")
+	require.NoError(t, err)
+	assert.Equal(t, 15.0, total.Value)
+
+	_, err = s.GetGaugeMetric(ctx, "DivByZero")
+	assert.ErrorIs(t, err, storage.ErrNoSuchMetric)
+	_, err = s.GetGaugeMetric(ctx, "Missing")
+	assert.ErrorIs(t, err, storage.ErrNoSuchMetric)
+}
+
+func TestRecorderRate(t *testing.T) {
+	ctx := context.Background()
+	s := storage.NewMemStorage()
+	r := NewRecorder(s, []Rule{mustParseRule(t, "PollRate", "rate(counter PollCount)")})
+	start := time.Now()
+
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 10})
+	require.NoError(t, err)
+	require.NoError(t, r.Evaluate(ctx, start))
+
+	_, err = s.GetGaugeMetric(ctx, "PollRate")
+	assert.ErrorIs(t, err, storage.ErrNoSuchMetric)
+
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 20})
+	require.NoError(t, err)
+	require.NoError(t, r.Evaluate(ctx, start.Add(10*time.Second)))
+
+	rate, err := s.GetGaugeMetric(ctx, "PollRate")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, rate.Value)
+}
@@ -0,0 +1,203 @@
+// Модуль recording вычисляет правила, записывающие производные метрики обратно в хранилище
+package recording
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+var (
+	ErrWrongExpr       = errors.New("expression must look like \"<term> [<op> <term>]\", term is \"<kind> <name>\", \"rate(counter <name>)\", \"sum(<kind> <name>)\" or a number")
+	ErrEmptyRecordName = errors.New("rule must have a record name")
+	ErrUnclosedLabels  = errors.New("metric labels must be closed with } and label values with \"")
+)
+
+const (
+	funcRate = "rate"
+	funcSum  = "sum"
+)
+
+var operators = map[string]func(a, b float64) (float64, bool){
+	"+": func(a, b float64) (float64, bool) { return a + b, true },
+	"-": func(a, b float64) (float64, bool) { return a - b, true },
+	"*": func(a, b float64) (float64, bool) { return a * b, true },
+	"/": func(a, b float64) (float64, bool) { return a / b, b != 0 },
+}
+
+// RuleConfig Правило в том виде, в котором оно задается в файле правил.
+type RuleConfig struct {
+	Record string `json:"record"`
+	Expr   string `json:"expr"`
+}
+
+// term Операнд выражения: метрика, функция от метрики или число.
+type term struct {
+	fn     string
+	kind   string
+	metric string
+	number float64
+}
+
+// Rule Разобранное правило записи, результат записывается как gauge с именем Record.
+type Rule struct {
+	Record   string
+	Expr     string
+	left     term
+	operator string
+	right    *term
+}
+
+// Разбор выражения правила, например "gauge HeapInuse / gauge HeapSys", "rate(counter PollCount)"
+// или "sum(gauge DiskUsed)", sum складывает метрики с одинаковым базовым именем и любыми метками.
+// Имя может быть с метками, например "gauge DiskUsed{path=\"/my disk\"}"
+func ParseRule(record, expr string) (Rule, error) {
+	rule := Rule{Record: record, Expr: expr}
+	if record == "" {
+		return rule, ErrEmptyRecordName
+	}
+
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return rule, err
+	}
+
+	left, rest, err := parseTerm(tokens)
+	if err != nil {
+		return rule, err
+	}
+	rule.left = left
+
+	if len(rest) == 0 {
+		return rule, nil
+	}
+
+	if _, ok := operators[rest[0]]; !ok {
+		return rule, fmt.Errorf("%w: unknown operator %s", ErrWrongExpr, rest[0])
+	}
+	rule.operator = rest[0]
+
+	right, rest, err := parseTerm(rest[1:])
+	if err != nil {
+		return rule, err
+	}
+	if len(rest) != 0 {
+		return rule, ErrWrongExpr
+	}
+	rule.right = &right
+
+	return rule, nil
+}
+
+// Разбиение выражения на токены по пробелам, скобки функций отдельные токены.
+// Метки метрики {key="value",...} остаются частью имени, даже если в значениях есть пробелы и скобки
+func tokenize(expr string) ([]string, error) {
+	var (
+		tokens   []string
+		token    strings.Builder
+		inLabels bool
+		inQuotes bool
+		escaped  bool
+	)
+	flush := func() {
+		if token.Len() > 0 {
+			tokens = append(tokens, token.String())
+			token.Reset()
+		}
+	}
+
+	for _, c := range expr {
+		switch {
+		case escaped:
+			escaped = false
+		case inQuotes:
+			escaped = c == '\\'
+			inQuotes = c != '"'
+		case inLabels:
+			inQuotes = c == '"'
+			inLabels = c != '}'
+		case c == '{':
+			inLabels = true
+		case c == '(' || c == ')':
+			flush()
+			tokens = append(tokens, string(c))
+			continue
+		case unicode.IsSpace(c):
+			flush()
+			continue
+		}
+		token.WriteRune(c)
+	}
+	if inLabels {
+		return nil, fmt.Errorf("%w: %s", ErrUnclosedLabels, expr)
+	}
+	flush()
+
+	return tokens, nil
+}
+
+func parseTerm(tokens []string) (term, []string, error) {
+	if len(tokens) == 0 {
+		return term{}, nil, ErrWrongExpr
+	}
+
+	if number, err := strconv.ParseFloat(tokens[0], 64); err == nil {
+		return term{number: number}, tokens[1:], nil
+	}
+
+	t := term{}
+	if tokens[0] == funcRate || tokens[0] == funcSum {
+		if len(tokens) < 5 || tokens[1] != "(" || tokens[4] != ")" {
+			return t, nil, ErrWrongExpr
+		}
+		t.fn, t.kind, t.metric = tokens[0], tokens[2], tokens[3]
+		tokens = tokens[5:]
+	} else {
+		if len(tokens) < 2 {
+			return t, nil, ErrWrongExpr
+		}
+		t.kind, t.metric = tokens[0], tokens[1]
+		tokens = tokens[2:]
+	}
+
+	if t.kind != model.Gauge && t.kind != model.Counter {
+		return t, nil, model.ErrWrongMetricKind
+	}
+	if t.fn == funcRate && t.kind != model.Counter {
+		return t, nil, fmt.Errorf("%w: rate is defined only for counters", ErrWrongExpr)
+	}
+	if t.fn == funcSum && strings.ContainsRune(t.metric, '{') {
+		return t, nil, fmt.Errorf("%w: sum takes a base name without labels", ErrWrongExpr)
+	}
+
+	return t, tokens, nil
+}
+
+// Загрузка правил из json файла со списком RuleConfig
+func LoadRules(filePath string) ([]Rule, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var ruleConfigs []RuleConfig
+	if err = json.Unmarshal(data, &ruleConfigs); err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, len(ruleConfigs))
+	for i, ruleConfig := range ruleConfigs {
+		rules[i], err = ParseRule(ruleConfig.Record, ruleConfig.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", ruleConfig.Record, err)
+		}
+	}
+
+	return rules, nil
+}
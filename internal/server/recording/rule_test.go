@@ -0,0 +1,149 @@
+package recording
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{
+			name: "ratio",
+			expr: "gauge HeapInuse / gauge HeapSys",
+		},
+		{
+			name: "rate",
+			expr: "rate(counter PollCount)",
+		},
+		{
+			name: "sum multiplied by number",
+			expr: "sum( gauge DiskUsed ) * 100",
+		},
+		{
+			name: "single metric",
+			expr: "counter PollCount",
+		},
+		{
+			name:    "rate of gauge",
+			expr:    "rate(gauge Alloc)",
+			wantErr: true,
+		},
+		{
+			name:    "unknown function",
+			expr:    "avg(gauge Alloc)",
+			wantErr: true,
+		},
+		{
+			name:    "unknown operator",
+			expr:    "gauge HeapInuse % gauge HeapSys",
+			wantErr: true,
+		},
+		{
+			name:    "trailing tokens",
+			expr:    "gauge HeapInuse / gauge HeapSys / 2",
+			wantErr: true,
+		},
+		{
+			name:    "unclosed function",
+			expr:    "sum(gauge DiskUsed",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseRule("test", test.expr)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseRuleLabeledName(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		left    string
+		right   string
+		wantErr error
+	}{
+		{
+			name: "space in label value",
+			expr: `gauge DiskUsed{path="/my disk"}`,
+			left: `DiskUsed{path="/my disk"}`,
+		},
+		{
+			name:  "labels on both sides",
+			expr:  `gauge DiskUsed{mount="/",path="/my disk"} / gauge DiskTotal{path="/my disk"}`,
+			left:  `DiskUsed{mount="/",path="/my disk"}`,
+			right: `DiskTotal{path="/my disk"}`,
+		},
+		{
+			name: "parentheses and escaped quote in label value",
+			expr: `rate(counter Requests{handler="get(\"x\") }"})`,
+			left: `Requests{handler="get(\"x\") }"}`,
+		},
+		{
+			name:    "sum of labeled name",
+			expr:    `sum(gauge DiskUsed{path="/my disk"}) * 100`,
+			wantErr: ErrWrongExpr,
+		},
+		{
+			name:    "unclosed labels",
+			expr:    `gauge DiskUsed{path="/my disk"`,
+			wantErr: ErrUnclosedLabels,
+		},
+		{
+			name:    "unclosed label value",
+			expr:    `gauge DiskUsed{path="/my disk}`,
+			wantErr: ErrUnclosedLabels,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := ParseRule("test", test.expr)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, test.left, rule.left.metric)
+			if test.right != "" {
+				require.NotNil(t, rule.right)
+				assert.Equal(t, test.right, rule.right.metric)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	rulesPath := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(rulesPath, []byte(`[
+		{"record": "HeapInuseRatio", "expr": "gauge HeapInuse / gauge HeapSys"},
+		{"record": "PollRate", "expr": "rate(counter PollCount)"}
+	]`), 0644)
+	require.NoError(t, err)
+
+	rules, err := LoadRules(rulesPath)
+	require.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	err = os.WriteFile(rulesPath, []byte(`[{"expr": "gauge HeapAlloc"}]`), 0644)
+	require.NoError(t, err)
+
+	_, err = LoadRules(rulesPath)
+	assert.ErrorIs(t, err, ErrEmptyRecordName)
+}
@@ -2,14 +2,19 @@ package router
 
 import (
 	"crypto/rsa"
-	"net"
+	"net/http"
 	"net/http/pprof"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/smakimka/mtrcscollector/internal/ingest"
+	"github.com/smakimka/mtrcscollector/internal/server/agents"
+	"github.com/smakimka/mtrcscollector/internal/server/alerts"
 	"github.com/smakimka/mtrcscollector/internal/server/handlers"
 	"github.com/smakimka/mtrcscollector/internal/server/middleware"
+	"github.com/smakimka/mtrcscollector/internal/server/selfmetrics"
 	"github.com/smakimka/mtrcscollector/internal/storage"
+	"github.com/smakimka/mtrcscollector/internal/subnet"
 )
 
 // @Title mtrcscollector API
@@ -27,7 +32,50 @@ import (
 // @Tag.name Status
 // @Tag.description "Группа запросов статуса сервиса"
 
-func GetRouter(s storage.Storage, key *rsa.PrivateKey, trustedSubnet *net.IPNet) chi.Router {
+// options Дополнительные обработчики и middleware запросов обновления метрик.
+type options struct {
+	// доверенная подсеть из GetRouter, задается до применения опций
+	trusted           *subnet.Trusted
+	routes            []func(r chi.Router)
+	updateMiddlewares []func(http.Handler) http.Handler
+}
+
+// Option Дополнительные обработчики, которые подключаются к роутеру при наличии соответствующих подсистем.
+type Option func(o *options)
+
+// Подключить GET /alerts с активными алертами
+func WithAlerts(e *alerts.Engine) Option {
+	return func(o *options) {
+		alertsHandler := handlers.NewAlertsHandler(e)
+		o.routes = append(o.routes, func(r chi.Router) {
+			r.Get("/alerts", alertsHandler.ServeHTTP)
+		})
+	}
+}
+
+// Учитывать агентов, присылающих метрики, и подключить GET /agents
+func WithAgents(registry *agents.Registry) Option {
+	return func(o *options) {
+		agentsHandler := handlers.NewAgentsHandler(registry)
+		agentsMiddleware := middleware.NewAgentsMiddleware(registry, o.trusted)
+		o.routes = append(o.routes, func(r chi.Router) {
+			r.Get("/agents", agentsHandler.ServeHTTP)
+		})
+		o.updateMiddlewares = append(o.updateMiddlewares, agentsMiddleware.Track)
+	}
+}
+
+// Подключить GET /internal/metrics с метриками сервера о себе
+func WithSelfMetrics(registry *selfmetrics.Registry) Option {
+	return func(o *options) {
+		selfMetricsHandler := handlers.NewSelfMetricsHandler(registry)
+		o.routes = append(o.routes, func(r chi.Router) {
+			r.Get("/internal/metrics", selfMetricsHandler.ServeHTTP)
+		})
+	}
+}
+
+func GetRouter(s storage.Storage, key *rsa.PrivateKey, trustedSubnet *subnet.Trusted, opts ...Option) chi.Router {
 	getAllMetricsHandler := handlers.NewGetAllMetricsHandler(s)
 	updateMetricHandler := handlers.NewUpdateMetricHandler(s)
 	getMetricValueHandler := handlers.NewGetMetricValueHandler(s)
@@ -35,52 +83,78 @@ func GetRouter(s storage.Storage, key *rsa.PrivateKey, trustedSubnet *net.IPNet)
 	valueHandler := handlers.NewValueHandler(s)
 	pingHandler := handlers.NewPingHandler(s)
 	updatesHandler := handlers.NewUpdatesHandler(s)
+	deleteMetricHandler := handlers.NewDeleteMetricHandler(s)
+	deleteMetricsHandler := handlers.NewDeleteMetricsHandler(s)
+	healthzHandler := handlers.NewHealthzHandler()
+	readyzHandler := handlers.NewReadyzHandler(s)
+
+	o := &options{trusted: trustedSubnet}
+	for _, opt := range opts {
+		opt(o)
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 
-	if trustedSubnet != nil {
+	// проверки состояния доступны без подписи и из любой подсети, их вызывает оркестратор
+	r.Get("/healthz", healthzHandler.ServeHTTP)
+	r.Get("/readyz", readyzHandler.ServeHTTP)
+
+	r.Group(func(r chi.Router) {
+		// подсеть может поменяться при перечитывании конфига, поэтому проверка подключена всегда
 		subnetMiddleware := middleware.NewSubnetMiddleware(trustedSubnet)
 		r.Use(subnetMiddleware.AllowTrusted)
-	}
-
-	r.Use(middleware.Auth)
-	r.Use(middleware.Gzip)
 
-	if key != nil {
-		decryptMiddleware := middleware.NewDecryptMiddleware(key)
-		r.Use(decryptMiddleware.Decrypt)
-	}
-
-	r.Get("/ping", pingHandler.ServeHTTP)
-
-	r.Route("/", func(r chi.Router) {
-		r.HandleFunc("/debug/pprof/", pprof.Index)
-		r.HandleFunc("/debug/pprof/cmdline/", pprof.Cmdline)
-		r.HandleFunc("/debug/pprof/profile/", pprof.Profile)
-		r.HandleFunc("/debug/pprof/symbol/", pprof.Symbol)
-		r.HandleFunc("/debug/pprof/trace/", pprof.Trace)
-
-		r.Handle("/debug/pprof/allocs/", pprof.Handler("allocs"))
-		r.Handle("/debug/pprof/block/", pprof.Handler("block"))
-		r.Handle("/debug/pprof/goroutine/", pprof.Handler("goroutine"))
-		r.Handle("/debug/pprof/heap/", pprof.Handler("heap"))
-		r.Handle("/debug/pprof/mutex/", pprof.Handler("mutex"))
-		r.Handle("/debug/pprof/threadcreate/", pprof.Handler("threadcreate"))
-
-		r.Get("/", getAllMetricsHandler.ServeHTTP)
-		r.Post("/update/", updateHandler.ServeHTTP)
-		r.Post("/updates/", updatesHandler.ServeHTTP)
-		r.Post("/value/", valueHandler.ServeHTTP)
-	})
-
-	r.Route("/update/{metricKind}", func(r chi.Router) {
-		r.Use(middleware.MetricKind)
-		r.Post("/{metricName}/{metricValue}", updateMetricHandler.ServeHTTP)
-	})
-	r.Route("/value/{metricKind}", func(r chi.Router) {
-		r.Use(middleware.MetricKind)
-		r.Get("/{metricName}", getMetricValueHandler.ServeHTTP)
+		r.Use(middleware.Auth)
+		r.Use(ingest.Gzip)
+
+		if key != nil {
+			decryptMiddleware := middleware.NewDecryptMiddleware(key)
+			r.Use(decryptMiddleware.Decrypt)
+		}
+
+		sourceMiddleware := middleware.NewSourceMiddleware(trustedSubnet)
+		r.Use(sourceMiddleware.Source)
+
+		r.Get("/ping", pingHandler.ServeHTTP)
+
+		for _, route := range o.routes {
+			route(r)
+		}
+
+		r.Route("/", func(r chi.Router) {
+			r.HandleFunc("/debug/pprof/", pprof.Index)
+			r.HandleFunc("/debug/pprof/cmdline/", pprof.Cmdline)
+			r.HandleFunc("/debug/pprof/profile/", pprof.Profile)
+			r.HandleFunc("/debug/pprof/symbol/", pprof.Symbol)
+			r.HandleFunc("/debug/pprof/trace/", pprof.Trace)
+
+			r.Handle("/debug/pprof/allocs/", pprof.Handler("allocs"))
+			r.Handle("/debug/pprof/block/", pprof.Handler("block"))
+			r.Handle("/debug/pprof/goroutine/", pprof.Handler("goroutine"))
+			r.Handle("/debug/pprof/heap/", pprof.Handler("heap"))
+			r.Handle("/debug/pprof/mutex/", pprof.Handler("mutex"))
+			r.Handle("/debug/pprof/threadcreate/", pprof.Handler("threadcreate"))
+
+			r.Get("/", getAllMetricsHandler.ServeHTTP)
+			r.With(o.updateMiddlewares...).Post("/update/", updateHandler.ServeHTTP)
+			r.With(o.updateMiddlewares...).Post("/updates/", updatesHandler.ServeHTTP)
+			r.Post("/value/", valueHandler.ServeHTTP)
+		})
+
+		r.Route("/update/{metricKind}", func(r chi.Router) {
+			r.Use(ingest.MetricKind)
+			r.Use(o.updateMiddlewares...)
+			r.Post("/{metricName}/{metricValue}", updateMetricHandler.ServeHTTP)
+		})
+		r.Route("/value/{metricKind}", func(r chi.Router) {
+			r.Use(ingest.MetricKind)
+			r.Get("/{metricName}", getMetricValueHandler.ServeHTTP)
+
+			protectMiddleware := middleware.NewProtectMiddleware(trustedSubnet)
+			r.With(protectMiddleware.Protect).Delete("/{metricName}", deleteMetricHandler.ServeHTTP)
+			r.With(protectMiddleware.Protect).Delete("/", deleteMetricsHandler.ServeHTTP)
+		})
 	})
 
 	return r
@@ -1,14 +1,29 @@
 package router
 
 import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/smakimka/mtrcscollector/internal/auth"
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/server/agents"
+	"github.com/smakimka/mtrcscollector/internal/server/alerts"
+	"github.com/smakimka/mtrcscollector/internal/server/limits"
+	"github.com/smakimka/mtrcscollector/internal/server/selfmetrics"
 	"github.com/smakimka/mtrcscollector/internal/storage"
+	"github.com/smakimka/mtrcscollector/internal/subnet"
 )
 
 func testRequest(t *testing.T, ts *httptest.Server, url, method string) *http.Response {
@@ -114,6 +129,51 @@ func TestRouter(t *testing.T) {
 				contentType: "text/plain; charset=utf-8",
 			},
 		},
+		{
+			name:   "delete gauge",
+			method: http.MethodDelete,
+			url:    "/value/gauge/test",
+			want: want{
+				code:        http.StatusOK,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "delete deleted gauge",
+			method: http.MethodDelete,
+			url:    "/value/gauge/test",
+			want: want{
+				code:        http.StatusNotFound,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
+		{
+			name:   "delete counters by prefix",
+			method: http.MethodDelete,
+			url:    "/value/counter/?prefix=te",
+			want: want{
+				code:        http.StatusOK,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "delete without prefix",
+			method: http.MethodDelete,
+			url:    "/value/counter/",
+			want: want{
+				code:        http.StatusBadRequest,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "get deleted counter value",
+			method: http.MethodGet,
+			url:    "/value/counter/test",
+			want: want{
+				code:        http.StatusNotFound,
+				contentType: "text/plain; charset=utf-8",
+			},
+		},
 	}
 
 	s := storage.NewMemStorage()
@@ -130,3 +190,320 @@ func TestRouter(t *testing.T) {
 		})
 	}
 }
+
+func TestRouterAlerts(t *testing.T) {
+	s := storage.NewMemStorage()
+	rule, err := alerts.ParseRule("no alloc", "gauge Alloc absent for 0s")
+	require.NoError(t, err)
+
+	e := alerts.NewEngine(s, []alerts.Rule{rule})
+	_, err = e.Evaluate(context.Background(), time.Now())
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(GetRouter(s, nil, nil, WithAlerts(e)))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/alerts")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var active []alerts.Alert
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&active))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, active, 1)
+	assert.Equal(t, alerts.Firing, active[0].State)
+}
+
+func TestRouterAgents(t *testing.T) {
+	s := storage.NewMemStorage()
+	registry := agents.NewRegistry(3, time.Hour, 10)
+
+	// X-Real-IP учитывается только от соединений из доверенной подсети
+	_, trustedSubnet, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	ts := httptest.NewServer(GetRouter(s, nil, subnet.NewTrusted(trustedSubnet), WithAgents(registry)))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(
+		`[{"id": "Alloc", "type": "gauge", "value": 1}, {"id": "PollCount", "type": "counter", "delta": 1}]`,
+	))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Real-IP", "127.0.0.2")
+	req.Header.Set(model.AgentVersionHeader, "v1.2.3")
+	req.Header.Set(model.ReportIntervalHeader, "5")
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// неуспешное обновление не учитывается
+	req, err = http.NewRequest(http.MethodPost, ts.URL+"/update/unknown/test/1", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Real-IP", "127.0.0.3")
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/agents", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Real-IP", "127.0.0.3")
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var list []agents.Agent
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, list, 1)
+	assert.Equal(t, "127.0.0.2", list[0].IP)
+	assert.Equal(t, "v1.2.3", list[0].Version)
+	assert.Equal(t, agents.HTTP, list[0].Transport)
+	assert.Equal(t, 5, list[0].ReportInterval)
+	assert.Equal(t, 2, list[0].MetricsCount)
+	assert.False(t, list[0].Stale)
+}
+
+func TestRouterSeriesLimit(t *testing.T) {
+	s, err := limits.NewStorage(context.Background(), storage.NewMemStorage(), limits.Limits{MaxSeriesPerSource: 1})
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(GetRouter(s, nil, nil))
+	defer ts.Close()
+
+	resp := testRequest(t, ts, "/update/gauge/first/1", http.MethodPost)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = testRequest(t, ts, "/update/gauge/second/1", http.MethodPost)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	resp, err = ts.Client().Post(ts.URL+"/updates/", "application/json", strings.NewReader(
+		`[{"id": "first", "type": "gauge", "value": 2}, {"id": "third", "type": "gauge", "value": 1}]`,
+	))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var response model.Response
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+	// first записана, поэтому запрос не отклонен целиком
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Equal(t, []string{"third"}, response.Rejected)
+	assert.Equal(t, 1, response.Written)
+	assert.Equal(t, int64(2), s.Rejected())
+}
+
+func TestRouterSelfMetrics(t *testing.T) {
+	s := selfmetrics.NewStorage(storage.NewMemStorage(), selfmetrics.Default)
+	ts := httptest.NewServer(GetRouter(s, nil, nil, WithSelfMetrics(selfmetrics.Default)))
+	defer ts.Close()
+
+	resp := testRequest(t, ts, "/update/gauge/Alloc/1", http.MethodPost)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err := ts.Client().Get(ts.URL + "/internal/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var data model.MetricsData
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&data))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	names := []string{}
+	for _, m := range data {
+		names = append(names, m.Name)
+	}
+	assert.Contains(t, names, `ServerHTTPRequestCount{method="POST",route="/update/{metricKind}/{metricName}/{metricValue}",status="200"}`)
+	assert.Contains(t, names, `ServerStorageOpCount{backend="memory",op="update_gauge"}`)
+
+	// метрики сервера доступны и через обычное чтение метрик
+	resp = testRequest(t, ts, `/value/counter/ServerStorageOpCount{backend="memory",op="update_gauge"}`, http.MethodGet)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRouterLabeledName(t *testing.T) {
+	ctx := context.Background()
+	name := model.LabeledName("DiskUsed", model.Labels{"mount": "/"})
+	url := "/value/gauge/DiskUsed%7Bmount=%22%2F%22%7D"
+
+	s := storage.NewMemStorage()
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: name, Value: 1.5}))
+	ts := httptest.NewServer(GetRouter(s, nil, nil))
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + url)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1.5", string(body))
+
+	resp = testRequest(t, ts, url, http.MethodDelete)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = s.GetGaugeMetric(ctx, name)
+	assert.ErrorIs(t, err, storage.ErrNoSuchMetric)
+}
+
+func TestRouterDeleteAuth(t *testing.T) {
+	auth.Init("key")
+	defer auth.Init("")
+
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	_, private, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		trusted *net.IPNet
+		url     string
+		realIP  string
+		sign    []byte
+		want    int
+	}{
+		{
+			name: "unsigned",
+			url:  "/value/gauge/test",
+			want: http.StatusForbidden,
+		},
+		{
+			name: "unsigned by prefix",
+			url:  "/value/gauge/?prefix=te",
+			want: http.StatusForbidden,
+		},
+		{
+			name: "signed empty body",
+			url:  "/value/gauge/test",
+			sign: auth.Sign(nil),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "signed other metric",
+			url:  "/value/gauge/test",
+			sign: auth.Sign([]byte("/value/gauge/other")),
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "signed",
+			url:  "/value/gauge/test",
+			sign: auth.Sign([]byte("/value/gauge/test")),
+			want: http.StatusOK,
+		},
+		{
+			name: "signed by prefix",
+			url:  "/value/gauge/?prefix=te",
+			sign: auth.Sign([]byte("/value/gauge/?prefix=te")),
+			want: http.StatusOK,
+		},
+		{
+			name:    "unsigned from trusted subnet",
+			trusted: loopback,
+			url:     "/value/gauge/test",
+			realIP:  "127.0.0.1",
+			want:    http.StatusOK,
+		},
+		{
+			name:    "unsigned with spoofed X-Real-IP",
+			trusted: private,
+			url:     "/value/gauge/test",
+			realIP:  "10.0.0.1",
+			want:    http.StatusForbidden,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := storage.NewMemStorage()
+			require.NoError(t, s.UpdateGaugeMetric(context.Background(), model.GaugeMetric{Name: "test", Value: 1}))
+			ts := httptest.NewServer(GetRouter(s, nil, subnet.NewTrusted(test.trusted)))
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+test.url, nil)
+			require.NoError(t, err)
+			if test.realIP != "" {
+				req.Header.Set("X-Real-IP", test.realIP)
+			}
+			if test.sign != nil {
+				req.Header.Set("HashSHA256", hex.EncodeToString(test.sign))
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, test.want, resp.StatusCode)
+
+			_, err = s.GetGaugeMetric(context.Background(), "test")
+			if test.want == http.StatusOK {
+				assert.ErrorIs(t, err, storage.ErrNoSuchMetric)
+			} else {
+				assert.NoError(t, err, "metric must survive a rejected delete")
+			}
+		})
+	}
+}
+
+func TestRouterLegacySign(t *testing.T) {
+	auth.Init("key")
+	defer auth.Init("")
+
+	s := storage.NewMemStorage()
+	ts := httptest.NewServer(GetRouter(s, nil, nil))
+	defer ts.Close()
+
+	body := `[{"id": "Alloc", "type": "gauge", "value": 1}]`
+	tests := []struct {
+		name     string
+		sign     []byte
+		want     int
+		wantBody string
+	}{
+		{name: "hmac", sign: auth.Sign([]byte(body)), want: http.StatusOK},
+		{name: "old format", sign: auth.GetHasher().Sum([]byte(body)), want: http.StatusBadRequest, wantBody: "old format"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("HashSHA256", hex.EncodeToString(test.sign))
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			respBody, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, test.want, resp.StatusCode)
+			assert.Contains(t, string(respBody), test.wantBody)
+		})
+	}
+}
+
+func TestRouterHealth(t *testing.T) {
+	_, trustedSubnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	s := storage.NewSyncMemStorage(filepath.Join(t.TempDir(), "missing", "metrics.json"))
+	ts := httptest.NewServer(GetRouter(s, nil, subnet.NewTrusted(trustedSubnet)))
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		code int
+	}{
+		{name: "liveness without trusted ip", url: "/healthz", code: http.StatusOK},
+		{name: "readiness with unwritable storage", url: "/readyz", code: http.StatusServiceUnavailable},
+		{name: "other routes need trusted ip", url: "/", code: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := testRequest(t, ts, test.url, http.MethodGet)
+			assert.Equal(t, test.code, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Модуль selfmetrics собирает метрики о работе самого сервера
+package selfmetrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+// Prefix Префикс имен всех метрик сервера о себе.
+const Prefix = "Server"
+
+// Default Реестр, в который пишут middleware, интерсепторы и обертка над хранилищем.
+var Default = NewRegistry()
+
+// Registry Метрики сервера о себе, имена хранятся вместе с метками (см. model.LabeledName).
+// Значения функций вычисляются при каждом чтении.
+type Registry struct {
+	counters     map[string]int64
+	gauges       map[string]float64
+	counterFuncs map[string]func() int64
+	gaugeFuncs   map[string]func() float64
+	mutex        sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:     make(map[string]int64),
+		gauges:       make(map[string]float64),
+		counterFuncs: make(map[string]func() int64),
+		gaugeFuncs:   make(map[string]func() float64),
+	}
+}
+
+// Увеличить counter метрику на delta
+func (r *Registry) Add(name string, labels model.Labels, delta int64) {
+	fullName := model.LabeledName(Prefix+name, labels)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counters[fullName] += delta
+}
+
+// Установить значение gauge метрики
+func (r *Registry) Set(name string, labels model.Labels, value float64) {
+	fullName := model.LabeledName(Prefix+name, labels)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.gauges[fullName] = value
+}
+
+// Учесть длительность операции: counter <name>Count и gauge <name>Seconds с суммой длительностей,
+// средняя длительность - их отношение
+func (r *Registry) Observe(name string, labels model.Labels, d time.Duration) {
+	countName := model.LabeledName(Prefix+name+"Count", labels)
+	secondsName := model.LabeledName(Prefix+name+"Seconds", labels)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counters[countName]++
+	r.gauges[secondsName] += d.Seconds()
+}
+
+// Counter метрика, значение которой берется из fn при чтении
+func (r *Registry) CounterFunc(name string, labels model.Labels, fn func() int64) {
+	fullName := model.LabeledName(Prefix+name, labels)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counterFuncs[fullName] = fn
+}
+
+// Gauge метрика, значение которой берется из fn при чтении
+func (r *Registry) GaugeFunc(name string, labels model.Labels, fn func() float64) {
+	fullName := model.LabeledName(Prefix+name, labels)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.gaugeFuncs[fullName] = fn
+}
+
+// Gauge метрика по полному имени
+func (r *Registry) Gauge(fullName string) (model.GaugeMetric, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if fn, ok := r.gaugeFuncs[fullName]; ok {
+		return model.GaugeMetric{Name: fullName, Value: fn()}, true
+	}
+	value, ok := r.gauges[fullName]
+	return model.GaugeMetric{Name: fullName, Value: value}, ok
+}
+
+// Counter метрика по полному имени
+func (r *Registry) Counter(fullName string) (model.CounterMetric, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if fn, ok := r.counterFuncs[fullName]; ok {
+		return model.CounterMetric{Name: fullName, Value: fn()}, true
+	}
+	value, ok := r.counters[fullName]
+	return model.CounterMetric{Name: fullName, Value: value}, ok
+}
+
+// Все gauge метрики, отсортированные по имени
+func (r *Registry) GaugeMetrics() []model.GaugeMetric {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	metrics := make([]model.GaugeMetric, 0, len(r.gauges)+len(r.gaugeFuncs))
+	for name, value := range r.gauges {
+		metrics = append(metrics, model.GaugeMetric{Name: name, Value: value})
+	}
+	for name, fn := range r.gaugeFuncs {
+		metrics = append(metrics, model.GaugeMetric{Name: name, Value: fn()})
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Name < metrics[j].Name
+	})
+	return metrics
+}
+
+// Все counter метрики, отсортированные по имени
+func (r *Registry) CounterMetrics() []model.CounterMetric {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	metrics := make([]model.CounterMetric, 0, len(r.counters)+len(r.counterFuncs))
+	for name, value := range r.counters {
+		metrics = append(metrics, model.CounterMetric{Name: name, Value: value})
+	}
+	for name, fn := range r.counterFuncs {
+		metrics = append(metrics, model.CounterMetric{Name: name, Value: fn()})
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Name < metrics[j].Name
+	})
+	return metrics
+}
+
+// Все метрики в формате запросов /updates/
+func (r *Registry) MetricsData() model.MetricsData {
+	data := model.MetricsData{}
+	for _, m := range r.GaugeMetrics() {
+		value := m.Value
+		data = append(data, model.MetricData{Name: m.Name, Kind: model.Gauge, Value: &value})
+	}
+	for _, m := range r.CounterMetrics() {
+		delta := m.Value
+		data = append(data, model.MetricData{Name: m.Name, Kind: model.Counter, Delta: &delta})
+	}
+
+	return data
+}
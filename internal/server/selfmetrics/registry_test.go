@@ -0,0 +1,50 @@
+package selfmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	labels := model.Labels{"route": "/ping"}
+
+	r.Observe("HTTPRequest", labels, 2*time.Second)
+	r.Observe("HTTPRequest", labels, time.Second)
+	r.Add("Errors", nil, 2)
+	r.Set("LastSaveSeconds", nil, 0.5)
+	r.GaugeFunc("Series", nil, func() float64 { return 10 })
+	r.CounterFunc("Rejected", nil, func() int64 { return 3 })
+
+	count, ok := r.Counter(`ServerHTTPRequestCount{route="/ping"}`)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), count.Value)
+
+	seconds, ok := r.Gauge(`ServerHTTPRequestSeconds{route="/ping"}`)
+	require.True(t, ok)
+	assert.Equal(t, 3.0, seconds.Value)
+
+	series, ok := r.Gauge("ServerSeries")
+	require.True(t, ok)
+	assert.Equal(t, 10.0, series.Value)
+
+	_, ok = r.Gauge("ServerUnknown")
+	assert.False(t, ok)
+
+	assert.Equal(t, []model.GaugeMetric{
+		{Name: `ServerHTTPRequestSeconds{route="/ping"}`, Value: 3},
+		{Name: "ServerLastSaveSeconds", Value: 0.5},
+		{Name: "ServerSeries", Value: 10},
+	}, r.GaugeMetrics())
+	assert.Equal(t, []model.CounterMetric{
+		{Name: "ServerErrors", Value: 2},
+		{Name: `ServerHTTPRequestCount{route="/ping"}`, Value: 2},
+		{Name: "ServerRejected", Value: 3},
+	}, r.CounterMetrics())
+	assert.Len(t, r.MetricsData(), 6)
+}
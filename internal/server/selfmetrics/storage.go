@@ -0,0 +1,273 @@
+package selfmetrics
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+var _ storage.Storage = (*Storage)(nil)
+
+// Storage Обертка над хранилищем, которая учитывает длительность и ошибки операций
+// и отдает метрики сервера о себе через обычные методы чтения.
+type Storage struct {
+	storage.Storage
+	registry *Registry
+	backend  string
+}
+
+func NewStorage(s storage.Storage, registry *Registry) *Storage {
+	return &Storage{
+		Storage:  s,
+		registry: registry,
+		backend:  BackendName(s),
+	}
+}
+
+// Название хранилища для меток метрик
+func BackendName(s storage.Storage) string {
+	switch storage.Unwrap(s).(type) {
+	case *storage.MemStorage:
+		return "memory"
+	case *storage.SyncMemStorage:
+		return "sync_memory"
+	case *storage.WALMemStorage:
+		return "wal_memory"
+	case *storage.ShardedMemStorage:
+		return "sharded_memory"
+	case storage.PGStorage:
+		return "postgres"
+	default:
+		return "unknown"
+	}
+}
+
+// Хранилище, которое оборачивает учет метрик
+func (s *Storage) Unwrap() storage.Storage {
+	return s.Storage
+}
+
+// Учесть операцию хранилища, отсутствие метрики ошибкой не считается
+func (s *Storage) observe(op string, start time.Time, err error) {
+	labels := model.Labels{"backend": s.backend, "op": op}
+	s.registry.Observe("StorageOp", labels, time.Since(start))
+	if err != nil && !errors.Is(err, storage.ErrNoSuchMetric) {
+		s.registry.Add("StorageErrors", labels, 1)
+	}
+}
+
+func (s *Storage) UpdateCounterMetric(ctx context.Context, m model.CounterMetric) (int64, error) {
+	start := time.Now()
+	value, err := s.Storage.UpdateCounterMetric(ctx, m)
+	s.observe("update_counter", start, err)
+	return value, err
+}
+
+func (s *Storage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMetric) error {
+	start := time.Now()
+	err := s.Storage.UpdateGaugeMetric(ctx, m)
+	s.observe("update_gauge", start, err)
+	return err
+}
+
+func (s *Storage) UpdateMetrics(ctx context.Context, metricsData model.MetricsData) error {
+	start := time.Now()
+	err := s.Storage.UpdateMetrics(ctx, metricsData)
+	s.observe("update_metrics", start, err)
+	return err
+}
+
+func (s *Storage) GetGaugeMetric(ctx context.Context, name string) (model.GaugeMetric, error) {
+	if m, ok := s.registry.Gauge(name); ok {
+		return m, nil
+	}
+
+	start := time.Now()
+	m, err := s.Storage.GetGaugeMetric(ctx, name)
+	s.observe("get_gauge", start, err)
+	return m, err
+}
+
+func (s *Storage) GetCounterMetric(ctx context.Context, name string) (model.CounterMetric, error) {
+	if m, ok := s.registry.Counter(name); ok {
+		return m, nil
+	}
+
+	start := time.Now()
+	m, err := s.Storage.GetCounterMetric(ctx, name)
+	s.observe("get_counter", start, err)
+	return m, err
+}
+
+// Метрики хранилища вместе с метриками сервера, при совпадении имен остается метрика сервера
+func (s *Storage) GetAllGaugeMetrics(ctx context.Context) ([]model.GaugeMetric, error) {
+	start := time.Now()
+	stored, err := s.Storage.GetAllGaugeMetrics(ctx)
+	s.observe("get_all_gauges", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	own := s.registry.GaugeMetrics()
+	names := make(map[string]struct{}, len(own))
+	for _, m := range own {
+		names[m.Name] = struct{}{}
+	}
+
+	metrics := make([]model.GaugeMetric, 0, len(stored)+len(own))
+	for _, m := range stored {
+		if _, ok := names[m.Name]; !ok {
+			metrics = append(metrics, m)
+		}
+	}
+
+	return append(metrics, own...), nil
+}
+
+// Метрики хранилища вместе с метриками сервера, при совпадении имен остается метрика сервера
+func (s *Storage) GetAllCounterMetrics(ctx context.Context) ([]model.CounterMetric, error) {
+	start := time.Now()
+	stored, err := s.Storage.GetAllCounterMetrics(ctx)
+	s.observe("get_all_counters", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	own := s.registry.CounterMetrics()
+	names := make(map[string]struct{}, len(own))
+	for _, m := range own {
+		names[m.Name] = struct{}{}
+	}
+
+	metrics := make([]model.CounterMetric, 0, len(stored)+len(own))
+	for _, m := range stored {
+		if _, ok := names[m.Name]; !ok {
+			metrics = append(metrics, m)
+		}
+	}
+
+	return append(metrics, own...), nil
+}
+
+func (s *Storage) SearchByPrefix(ctx context.Context, kind, prefix string) (model.MetricsData, error) {
+	start := time.Now()
+	stored, err := s.Storage.SearchByPrefix(ctx, kind, prefix)
+	s.observe("search_by_prefix", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.withOwn(kind, stored, func(name string) bool { return strings.HasPrefix(name, prefix) }), nil
+}
+
+func (s *Storage) SearchByGlob(ctx context.Context, kind, pattern string) (model.MetricsData, error) {
+	start := time.Now()
+	stored, err := s.Storage.SearchByGlob(ctx, kind, pattern)
+	s.observe("search_by_glob", start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// шаблон уже проверен хранилищем
+	return s.withOwn(kind, stored, func(name string) bool {
+		ok, _ := storage.MatchGlob(pattern, name)
+		return ok
+	}), nil
+}
+
+// Найденные метрики вместе с подходящими метриками сервера, при совпадении имен остается метрика сервера
+func (s *Storage) withOwn(kind string, stored model.MetricsData, match func(string) bool) model.MetricsData {
+	var own model.MetricsData
+	switch kind {
+	case model.Gauge:
+		for _, m := range s.registry.GaugeMetrics() {
+			if match(m.Name) {
+				value := m.Value
+				own = append(own, model.MetricData{Name: m.Name, Kind: kind, Value: &value})
+			}
+		}
+	case model.Counter:
+		for _, m := range s.registry.CounterMetrics() {
+			if match(m.Name) {
+				value := m.Value
+				own = append(own, model.MetricData{Name: m.Name, Kind: kind, Delta: &value})
+			}
+		}
+	}
+	if len(own) == 0 {
+		return stored
+	}
+
+	names := make(map[string]struct{}, len(own))
+	for _, m := range own {
+		names[m.Name] = struct{}{}
+	}
+
+	metrics := make(model.MetricsData, 0, len(stored)+len(own))
+	for _, m := range stored {
+		if _, ok := names[m.Name]; !ok {
+			metrics = append(metrics, m)
+		}
+	}
+	metrics = append(metrics, own...)
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Name < metrics[j].Name
+	})
+	return metrics
+}
+
+func (s *Storage) Delete(ctx context.Context, kind, name string) error {
+	start := time.Now()
+	err := s.Storage.Delete(ctx, kind, name)
+	s.observe("delete", start, err)
+	return err
+}
+
+func (s *Storage) DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error) {
+	start := time.Now()
+	deleted, err := s.Storage.DeleteByPrefix(ctx, kind, prefix)
+	s.observe("delete_by_prefix", start, err)
+	return deleted, err
+}
+
+func (s *Storage) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	start := time.Now()
+	deleted, err := s.Storage.DeleteStale(ctx, before)
+	s.observe("delete_stale", start, err)
+	return deleted, err
+}
+
+// Сохранение хранилища в файл с учетом длительности и ошибок
+func (r *Registry) Save(s storage.SyncStorage, filePath string) error {
+	start := time.Now()
+	err := s.Save(filePath)
+
+	d := time.Since(start)
+	r.Observe("StorageSave", nil, d)
+	r.Set("StorageLastSaveSeconds", nil, d.Seconds())
+	if err != nil {
+		r.Add("StorageSaveErrors", nil, 1)
+	}
+
+	return err
+}
+
+// Метрики пула соединений postgres
+func (r *Registry) RegisterPGPool(pool *pgxpool.Pool) {
+	r.GaugeFunc("PGPoolTotalConns", nil, func() float64 { return float64(pool.Stat().TotalConns()) })
+	r.GaugeFunc("PGPoolIdleConns", nil, func() float64 { return float64(pool.Stat().IdleConns()) })
+	r.GaugeFunc("PGPoolAcquiredConns", nil, func() float64 { return float64(pool.Stat().AcquiredConns()) })
+	r.GaugeFunc("PGPoolMaxConns", nil, func() float64 { return float64(pool.Stat().MaxConns()) })
+	r.GaugeFunc("PGPoolAcquireSeconds", nil, func() float64 { return pool.Stat().AcquireDuration().Seconds() })
+	r.CounterFunc("PGPoolAcquireCount", nil, func() int64 { return pool.Stat().AcquireCount() })
+	r.CounterFunc("PGPoolEmptyAcquireCount", nil, func() int64 { return pool.Stat().EmptyAcquireCount() })
+	r.CounterFunc("PGPoolCanceledAcquireCount", nil, func() int64 { return pool.Stat().CanceledAcquireCount() })
+}
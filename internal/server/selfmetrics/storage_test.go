@@ -0,0 +1,75 @@
+package selfmetrics
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+	"github.com/smakimka/mtrcscollector/internal/storage"
+)
+
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	r := NewRegistry()
+	r.Set("Uptime", nil, 42)
+
+	s := NewStorage(storage.NewMemStorage(), r)
+	assert.Equal(t, "memory", BackendName(s))
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1}))
+	_, err := s.GetGaugeMetric(ctx, "Missing")
+	require.ErrorIs(t, err, storage.ErrNoSuchMetric)
+	require.Error(t, s.Delete(ctx, "unknown", "Alloc"))
+
+	// метрики сервера читаются через обычные методы хранилища
+	uptime, err := s.GetGaugeMetric(ctx, "ServerUptime")
+	require.NoError(t, err)
+	assert.Equal(t, 42.0, uptime.Value)
+
+	gauges, err := s.GetAllGaugeMetrics(ctx)
+	require.NoError(t, err)
+	names := []string{}
+	for _, m := range gauges {
+		names = append(names, m.Name)
+	}
+	assert.Contains(t, names, "Alloc")
+	assert.Contains(t, names, "ServerUptime")
+
+	// и находятся поиском вместе с метриками хранилища
+	found, err := s.SearchByGlob(ctx, model.Gauge, "*U*")
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "ServerUptime", found[0].Name)
+	found, err = s.SearchByPrefix(ctx, model.Gauge, "Al")
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "Alloc", found[0].Name)
+
+	updates, ok := r.Counter(`ServerStorageOpCount{backend="memory",op="update_gauge"}`)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), updates.Value)
+
+	// отсутствие метрики не ошибка, неверный тип - ошибка
+	_, ok = r.Counter(`ServerStorageErrors{backend="memory",op="get_gauge"}`)
+	assert.False(t, ok)
+	deleteErrors, ok := r.Counter(`ServerStorageErrors{backend="memory",op="delete"}`)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), deleteErrors.Value)
+}
+
+func TestRegistrySave(t *testing.T) {
+	r := NewRegistry()
+	s := storage.NewMemStorage()
+
+	require.NoError(t, r.Save(s, filepath.Join(t.TempDir(), "metrics.json")))
+
+	saves, ok := r.Counter("ServerStorageSaveCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), saves.Value)
+	_, ok = r.Gauge("ServerStorageLastSaveSeconds")
+	assert.True(t, ok)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Двоичный формат данных снимка. Все числа - varint, кроме значений gauge, они пишутся как есть (8 байт float64):
+//
+//	wal_seq
+//	число gauge, для каждой: длина имени, имя, значение, время обновления
+//	число counter, для каждой: длина имени, имя, значение (со знаком), время обновления
+//
+// Время обновления - 0, если его нет, иначе 1 и unix время в наносекундах.
+
+var ErrBrokenBinarySnapshot = errors.New("broken binary snapshot")
+
+// Закодировать данные в двоичный формат
+func (d SaveData) MarshalBinary() ([]byte, error) {
+	size := 2 * binary.MaxVarintLen64
+	for name := range d.GaugeMetrics {
+		size += len(name) + 8 + 3*binary.MaxVarintLen64
+	}
+	for name := range d.CounterMetrics {
+		size += len(name) + 4*binary.MaxVarintLen64
+	}
+
+	buf := make([]byte, 0, size)
+	buf = binary.AppendUvarint(buf, d.WALSeq)
+
+	buf = binary.AppendUvarint(buf, uint64(len(d.GaugeMetrics)))
+	for name, value := range d.GaugeMetrics {
+		buf = appendString(buf, name)
+		buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(value))
+		buf = appendTime(buf, d.GaugeUpdated, name)
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(d.CounterMetrics)))
+	for name, value := range d.CounterMetrics {
+		buf = appendString(buf, name)
+		buf = binary.AppendVarint(buf, value)
+		buf = appendTime(buf, d.CounterUpdated, name)
+	}
+
+	return buf, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendTime(buf []byte, updated map[string]time.Time, name string) []byte {
+	t, ok := updated[name]
+	if !ok {
+		return append(buf, 0)
+	}
+	buf = append(buf, 1)
+	return binary.AppendVarint(buf, t.UnixNano())
+}
+
+// Раскодировать данные из двоичного формата
+func (d *SaveData) UnmarshalBinary(data []byte) error {
+	r := binaryReader{r: bytes.NewReader(data)}
+	res := SaveData{
+		GaugeUpdated:   make(map[string]time.Time),
+		CounterUpdated: make(map[string]time.Time),
+	}
+
+	res.WALSeq = r.uvarint()
+
+	n := r.count()
+	res.GaugeMetrics = make(map[string]float64, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		name := r.string()
+		res.GaugeMetrics[name] = math.Float64frombits(r.uint64())
+		r.time(res.GaugeUpdated, name)
+	}
+
+	n = r.count()
+	res.CounterMetrics = make(map[string]int64, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		name := r.string()
+		res.CounterMetrics[name] = r.varint()
+		r.time(res.CounterUpdated, name)
+	}
+
+	if r.err == nil && r.r.Len() > 0 {
+		r.err = fmt.Errorf("%d trailing bytes", r.r.Len())
+	}
+	if r.err != nil {
+		return fmt.Errorf("%w: %w", ErrBrokenBinarySnapshot, r.err)
+	}
+
+	*d = res
+	return nil
+}
+
+// binaryReader Чтение двоичного снимка, первая ошибка запоминается и дальше ничего не читается.
+type binaryReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(r.r)
+	r.setErr(err)
+	return v
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(r.r)
+	r.setErr(err)
+	return v
+}
+
+func (r *binaryReader) uint64() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	var buf [8]byte
+	_, err := io.ReadFull(r.r, buf[:])
+	r.setErr(err)
+	return binary.LittleEndian.Uint64(buf[:])
+}
+
+// Длина или число записей, не больше оставшихся байт, чтобы битый файл не приводил к огромным выделениям памяти
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if r.err == nil && n > uint64(r.r.Len()) {
+		r.err = fmt.Errorf("length %d is out of range", n)
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) string() string {
+	n := r.count()
+	if r.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r.r, buf)
+	r.setErr(err)
+	return string(buf)
+}
+
+func (r *binaryReader) time(updated map[string]time.Time, name string) {
+	if r.err != nil {
+		return
+	}
+	ok, err := r.r.ReadByte()
+	if err != nil {
+		r.setErr(err)
+		return
+	}
+	if ok == 0 {
+		return
+	}
+	if ok != 1 {
+		r.err = fmt.Errorf("bad update time flag %d", ok)
+		return
+	}
+	if nanos := r.varint(); r.err == nil {
+		updated[name] = time.Unix(0, nanos)
+	}
+}
+
+func (r *binaryReader) setErr(err error) {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	r.err = err
+}
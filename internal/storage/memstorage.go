@@ -2,22 +2,34 @@ package storage
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/smakimka/mtrcscollector/internal/logger"
 	"github.com/smakimka/mtrcscollector/internal/model"
 )
 
-// MemStorage Реализация интерфейса storage для хранения данных в памяти (в 2 хешмапах).
+// MemStorage Реализация интерфейса storage для хранения данных в памяти (в 2 хешмапах),
+// время последнего обновления каждой метрики хранится в отдельных хешмапах.
 type MemStorage struct {
 	gaugeMetrics   map[string]float64
 	counterMetrics map[string]int64
+	gaugeUpdated   map[string]time.Time
+	counterUpdated map[string]time.Time
 	mutex          sync.RWMutex
+
+	// номер последней примененной записи журнала, сохраняется вместе с данными
+	walSeq uint64
+
+	// файл, в который последний раз сохранялись данные, и результат сохранения, для проверки готовности
+	saveFilePath string
+	lastSaveErr  error
+	snapshots    SnapshotOptions
+	saveMutex    sync.Mutex
 }
 
 func NewMemStorage() *MemStorage {
@@ -25,60 +37,162 @@ func NewMemStorage() *MemStorage {
 		mutex:          sync.RWMutex{},
 		gaugeMetrics:   make(map[string]float64),
 		counterMetrics: make(map[string]int64),
+		gaugeUpdated:   make(map[string]time.Time),
+		counterUpdated: make(map[string]time.Time),
+		snapshots:      DefaultSnapshotOptions,
 	}
 	return s
 }
 
 type SaveData struct {
-	GaugeMetrics   map[string]float64 `json:"gauge_metrics"`
-	CounterMetrics map[string]int64   `json:"counter_metrics"`
+	GaugeMetrics   map[string]float64   `json:"gauge_metrics"`
+	CounterMetrics map[string]int64     `json:"counter_metrics"`
+	GaugeUpdated   map[string]time.Time `json:"gauge_updated,omitempty"`
+	CounterUpdated map[string]time.Time `json:"counter_updated,omitempty"`
+	WALSeq         uint64               `json:"wal_seq,omitempty"`
 }
 
-// Функиця для сохранения данных в файл
+// Функиця для сохранения данных в файл, предыдущие снимки остаются рядом с номерами .1, .2 и т.д.
 func (s *MemStorage) Save(filePath string) error {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	return s.save(filePath, true)
+}
+
+// Сохранение после каждой записи в SyncMemStorage: только замена текущего снимка, без сдвига предыдущих
+// и fsync, иначе каждый запрос стоил бы нескольких переименований и сбросов на диск
+func (s *MemStorage) saveCurrent(filePath string) error {
+	return s.save(filePath, false)
+}
+
+func (s *MemStorage) save(filePath string, rotate bool) error {
+	// сохранения не пересекаются от снятия данных до записи, иначе снимок более старых данных
+	// мог бы записаться поверх более нового
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+	opts := s.snapshots
 
+	s.mutex.RLock()
 	data := SaveData{}
 
 	data.GaugeMetrics = s.gaugeMetrics
 	data.CounterMetrics = s.counterMetrics
-
-	byteData, err := json.Marshal(data)
-	if err != nil {
-		return err
+	data.GaugeUpdated = s.gaugeUpdated
+	data.CounterUpdated = s.counterUpdated
+	data.WALSeq = s.walSeq
+
+	byteData, err := encodeSnapshot(data, opts.Format)
+	s.mutex.RUnlock()
+
+	// запись на диск идет без блокировки данных
+	switch {
+	case err != nil:
+	case rotate:
+		err = writeSnapshot(filePath, byteData, opts.Keep)
+	default:
+		err = replaceSnapshot(filePath, byteData)
 	}
 
-	os.WriteFile(filePath, byteData, fs.FileMode(0644))
+	s.saveFilePath = filePath
+	s.lastSaveErr = err
 
-	return nil
+	return err
 }
 
-// Функиця для восстановления данных из файла
-func (s *MemStorage) Restore(filePath string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// Настройки снимков: формат и сколько снимков хранить вместе с текущим
+func (s *MemStorage) SetSnapshotOptions(opts SnapshotOptions) {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	s.snapshots = opts
+}
+
+func (s *MemStorage) snapshotOptions() SnapshotOptions {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	return s.snapshots
+}
+
+// Готовность: последнее сохранение прошло успешно и в папку файла сохранения можно писать
+func (s *MemStorage) CheckHealth(ctx context.Context) error {
+	s.saveMutex.Lock()
+	filePath, lastSaveErr := s.saveFilePath, s.lastSaveErr
+	s.saveMutex.Unlock()
 
-	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+	if lastSaveErr != nil {
+		return fmt.Errorf("last save failed: %w", lastSaveErr)
+	}
+	if filePath == "" {
 		return nil
 	}
 
-	data, err := os.ReadFile(filePath)
+	return checkWritable(filepath.Dir(filePath))
+}
+
+// Проверка, что в папке можно создать файл
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".healthcheck-*")
 	if err != nil {
-		return err
+		return fmt.Errorf("storage dir is not writable: %w", err)
 	}
+	f.Close()
 
-	metricsData := SaveData{}
-	if err = json.Unmarshal(data, &metricsData); err != nil {
+	return os.Remove(f.Name())
+}
+
+// Функиця для восстановления данных из самого свежего целого снимка
+func (s *MemStorage) Restore(filePath string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	metricsData, ok, err := readLatestSnapshot(filePath)
+	if err != nil || !ok {
 		return err
 	}
 
 	s.gaugeMetrics = metricsData.GaugeMetrics
 	s.counterMetrics = metricsData.CounterMetrics
+	s.gaugeUpdated = metricsData.GaugeUpdated
+	s.counterUpdated = metricsData.CounterUpdated
+	s.walSeq = metricsData.WALSeq
+	if s.gaugeMetrics == nil {
+		s.gaugeMetrics = make(map[string]float64)
+	}
+	if s.counterMetrics == nil {
+		s.counterMetrics = make(map[string]int64)
+	}
+
+	// в сохранениях старого формата нет времени обновления, такие метрики считаются обновленными сейчас
+	now := time.Now()
+	for name := range s.gaugeMetrics {
+		if _, ok := s.gaugeUpdated[name]; !ok {
+			s.touch(model.Gauge, name, now)
+		}
+	}
+	for name := range s.counterMetrics {
+		if _, ok := s.counterUpdated[name]; !ok {
+			s.touch(model.Counter, name, now)
+		}
+	}
 
 	return nil
 }
 
+// Запомнить время обновления метрики, вызывается под блокировкой на запись
+func (s *MemStorage) touch(kind, name string, now time.Time) {
+	switch kind {
+	case model.Gauge:
+		if s.gaugeUpdated == nil {
+			s.gaugeUpdated = make(map[string]time.Time)
+		}
+		s.gaugeUpdated[name] = now
+	case model.Counter:
+		if s.counterUpdated == nil {
+			s.counterUpdated = make(map[string]time.Time)
+		}
+		s.counterUpdated[name] = now
+	}
+}
+
 // Получение gauge метрики по имени
 func (s *MemStorage) GetGaugeMetric(ctx context.Context, name string) (model.GaugeMetric, error) {
 	s.mutex.RLock()
@@ -137,12 +251,56 @@ func (s *MemStorage) GetAllCounterMetrics(ctx context.Context) ([]model.CounterM
 	return metrics, nil
 }
 
+// Найти метрики типа kind, имя которых начинается с prefix
+func (s *MemStorage) SearchByPrefix(ctx context.Context, kind, prefix string) (model.MetricsData, error) {
+	return s.search(kind, func(name string) bool { return strings.HasPrefix(name, prefix) })
+}
+
+// Найти метрики типа kind, имя которых подходит под шаблон
+func (s *MemStorage) SearchByGlob(ctx context.Context, kind, pattern string) (model.MetricsData, error) {
+	match, err := globMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.search(kind, match)
+}
+
+func (s *MemStorage) search(kind string, match func(string) bool) (model.MetricsData, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	metrics := model.MetricsData{}
+	switch kind {
+	case model.Gauge:
+		for name, value := range s.gaugeMetrics {
+			if match(name) {
+				value := value
+				metrics = append(metrics, model.MetricData{Name: name, Kind: kind, Value: &value})
+			}
+		}
+	case model.Counter:
+		for name, value := range s.counterMetrics {
+			if match(name) {
+				value := value
+				metrics = append(metrics, model.MetricData{Name: name, Kind: kind, Delta: &value})
+			}
+		}
+	default:
+		return nil, model.ErrWrongMetricKind
+	}
+
+	sortByName(metrics)
+	return metrics, nil
+}
+
 // Обновить gauge метрику по имени, значение будет перезаписано
 func (s *MemStorage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMetric) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	s.gaugeMetrics[m.Name] = m.Value
+	s.touch(model.Gauge, m.Name, time.Now())
 	logger.Log.Debug().Msg(fmt.Sprintf("updated gauge metric \"%s\" to %f", m.Name, m.Value))
 
 	return nil
@@ -154,6 +312,7 @@ func (s *MemStorage) UpdateCounterMetric(ctx context.Context, m model.CounterMet
 	defer s.mutex.Unlock()
 
 	s.counterMetrics[m.Name] += m.Value
+	s.touch(model.Counter, m.Name, time.Now())
 	logger.Log.Debug().Msg(fmt.Sprintf("updated counter metric \"%s\" to %d", m.Name, s.counterMetrics[m.Name]))
 	return s.counterMetrics[m.Name], nil
 }
@@ -163,7 +322,15 @@ func (s *MemStorage) UpdateMetrics(ctx context.Context, metricsData model.Metric
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	s.updateMetrics(metricsData, time.Now())
+	return nil
+}
+
+// Обновить метрики со временем обновления now, вызывается под блокировкой на запись
+func (s *MemStorage) updateMetrics(metricsData model.MetricsData, now time.Time) {
 	for _, metricData := range metricsData {
+		s.touch(metricData.Kind, metricData.Name, now)
+
 		switch metricData.Kind {
 		case model.Gauge:
 			s.gaugeMetrics[metricData.Name] = *metricData.Value
@@ -174,6 +341,137 @@ func (s *MemStorage) UpdateMetrics(ctx context.Context, metricsData model.Metric
 			logger.Log.Debug().Msg(fmt.Sprintf("updated counter metric \"%s\" to %d", metricData.Name, newValue))
 		}
 	}
+}
+
+// Применить запись журнала, возвращает количество удаленных метрик для удалений
+func (s *MemStorage) apply(rec walRecord) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.walSeq = rec.Seq
+	switch rec.Op {
+	case walUpdate:
+		s.updateMetrics(rec.Metrics, rec.Time)
+	case walDelete:
+		if err := s.delete(rec.Kind, rec.Name); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	case walDeletePrefix:
+		return s.deleteByPrefix(rec.Kind, rec.Prefix)
+	case walDeleteStale:
+		if rec.Before != nil {
+			return s.deleteStale(*rec.Before), nil
+		}
+	}
+
+	return 0, nil
+}
+
+// Есть ли метрика такого типа с таким именем
+func (s *MemStorage) has(kind, name string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	switch kind {
+	case model.Gauge:
+		_, ok := s.gaugeMetrics[name]
+		return ok
+	case model.Counter:
+		_, ok := s.counterMetrics[name]
+		return ok
+	}
+	return false
+}
+
+// Удалить метрику по типу и имени
+func (s *MemStorage) Delete(ctx context.Context, kind, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.delete(kind, name)
+}
+
+func (s *MemStorage) delete(kind, name string) error {
+	switch kind {
+	case model.Gauge:
+		if _, ok := s.gaugeMetrics[name]; !ok {
+			return ErrNoSuchMetric
+		}
+		delete(s.gaugeMetrics, name)
+		delete(s.gaugeUpdated, name)
+	case model.Counter:
+		if _, ok := s.counterMetrics[name]; !ok {
+			return ErrNoSuchMetric
+		}
+		delete(s.counterMetrics, name)
+		delete(s.counterUpdated, name)
+	default:
+		return model.ErrWrongMetricKind
+	}
 
+	logger.Log.Debug().Msg(fmt.Sprintf("deleted %s metric \"%s\"", kind, name))
 	return nil
 }
+
+// Удалить все метрики типа kind, имя которых начинается с prefix, возвращает количество удаленных
+func (s *MemStorage) DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.deleteByPrefix(kind, prefix)
+}
+
+func (s *MemStorage) deleteByPrefix(kind, prefix string) (int64, error) {
+	var deleted int64
+	switch kind {
+	case model.Gauge:
+		for name := range s.gaugeMetrics {
+			if strings.HasPrefix(name, prefix) {
+				delete(s.gaugeMetrics, name)
+				delete(s.gaugeUpdated, name)
+				deleted++
+			}
+		}
+	case model.Counter:
+		for name := range s.counterMetrics {
+			if strings.HasPrefix(name, prefix) {
+				delete(s.counterMetrics, name)
+				delete(s.counterUpdated, name)
+				deleted++
+			}
+		}
+	default:
+		return 0, model.ErrWrongMetricKind
+	}
+
+	return deleted, nil
+}
+
+// Удалить метрики обоих типов, которые не обновлялись с момента before, возвращает количество удаленных
+func (s *MemStorage) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.deleteStale(before), nil
+}
+
+func (s *MemStorage) deleteStale(before time.Time) int64 {
+	var deleted int64
+	for name, updated := range s.gaugeUpdated {
+		if updated.Before(before) {
+			delete(s.gaugeMetrics, name)
+			delete(s.gaugeUpdated, name)
+			deleted++
+		}
+	}
+	for name, updated := range s.counterUpdated {
+		if updated.Before(before) {
+			delete(s.counterMetrics, name)
+			delete(s.counterUpdated, name)
+			deleted++
+		}
+	}
+
+	return deleted
+}
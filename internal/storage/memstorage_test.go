@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -328,3 +330,129 @@ func TestUpdateMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestDelete(t *testing.T) {
+	tests := []struct {
+		wantErr        error
+		gaugeMetrics   map[string]float64
+		counterMetrics map[string]int64
+		name           string
+		kind           string
+		metricName     string
+	}{
+		{
+			name:           "delete gauge",
+			kind:           model.Gauge,
+			metricName:     "test",
+			gaugeMetrics:   map[string]float64{"other": 2.0},
+			counterMetrics: map[string]int64{"test": 1},
+		},
+		{
+			name:           "delete counter",
+			kind:           model.Counter,
+			metricName:     "test",
+			gaugeMetrics:   map[string]float64{"test": 1.0, "other": 2.0},
+			counterMetrics: map[string]int64{},
+		},
+		{
+			name:           "delete missing",
+			kind:           model.Gauge,
+			metricName:     "missing",
+			wantErr:        ErrNoSuchMetric,
+			gaugeMetrics:   map[string]float64{"test": 1.0, "other": 2.0},
+			counterMetrics: map[string]int64{"test": 1},
+		},
+		{
+			name:           "delete wrong kind",
+			kind:           "unknown",
+			metricName:     "test",
+			wantErr:        model.ErrWrongMetricKind,
+			gaugeMetrics:   map[string]float64{"test": 1.0, "other": 2.0},
+			counterMetrics: map[string]int64{"test": 1},
+		},
+	}
+
+	ctx := context.Background()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &MemStorage{
+				mutex:          sync.RWMutex{},
+				gaugeMetrics:   map[string]float64{"test": 1.0, "other": 2.0},
+				counterMetrics: map[string]int64{"test": 1},
+			}
+
+			err := s.Delete(ctx, test.kind, test.metricName)
+			assert.ErrorIs(t, err, test.wantErr)
+			assert.Equal(t, test.gaugeMetrics, s.gaugeMetrics)
+			assert.Equal(t, test.counterMetrics, s.counterMetrics)
+		})
+	}
+}
+
+func TestDeleteByPrefix(t *testing.T) {
+	s := NewMemStorage()
+	ctx := context.Background()
+
+	for _, name := range []string{"DiskUsed{mount=\"/\"}", "DiskUsed{mount=\"/home\"}", "DiskFree", "Alloc"} {
+		require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: name, Value: 1}))
+	}
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "DiskReadCount", Value: 1})
+	require.NoError(t, err)
+
+	deleted, err := s.DeleteByPrefix(ctx, model.Gauge, "DiskUsed")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	deleted, err = s.DeleteByPrefix(ctx, model.Gauge, "Disk")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	assert.Equal(t, map[string]float64{"Alloc": 1}, s.gaugeMetrics)
+	assert.Equal(t, map[string]int64{"DiskReadCount": 1}, s.counterMetrics)
+}
+
+func TestDeleteStale(t *testing.T) {
+	s := NewMemStorage()
+	ctx := context.Background()
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "old", Value: 1}))
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "old", Value: 1})
+	require.NoError(t, err)
+
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	value := 2.0
+	require.NoError(t, s.UpdateMetrics(ctx, model.MetricsData{{Name: "fresh", Kind: model.Gauge, Value: &value}}))
+
+	deleted, err := s.DeleteStale(ctx, before.Add(time.Nanosecond))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.Equal(t, map[string]float64{"fresh": 2}, s.gaugeMetrics)
+	assert.Empty(t, s.counterMetrics)
+
+	// время обновления переживает сохранение и восстановление
+	testFilePath := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, s.Save(testFilePath))
+
+	restored := NewMemStorage()
+	require.NoError(t, restored.Restore(testFilePath))
+	assert.Equal(t, s.gaugeUpdated["fresh"].UnixNano(), restored.gaugeUpdated["fresh"].UnixNano())
+}
+
+func TestCheckHealth(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s := NewMemStorage()
+	require.NoError(t, CheckHealth(ctx, s))
+
+	require.NoError(t, s.Save(filepath.Join(dir, "metrics.json")))
+	require.NoError(t, CheckHealth(ctx, s))
+
+	require.Error(t, s.Save(filepath.Join(dir, "missing", "metrics.json")))
+	assert.Error(t, CheckHealth(ctx, s))
+
+	syncStorage := NewSyncMemStorage(filepath.Join(dir, "missing", "metrics.json"))
+	assert.Error(t, CheckHealth(ctx, syncStorage))
+}
@@ -0,0 +1,2 @@
+drop table if exists gauge_metrics;
+drop table if exists counter_metrics;
@@ -0,0 +1,13 @@
+create table if not exists counter_metrics (
+	id serial primary key,
+	name text,
+	value bigint,
+	constraint c_name_uq unique (name)
+);
+
+create table if not exists gauge_metrics (
+	id serial primary key,
+	name text,
+	value double precision,
+	constraint g_name_uq unique (name)
+);
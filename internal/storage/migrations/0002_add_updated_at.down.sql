@@ -0,0 +1,2 @@
+alter table gauge_metrics drop column if exists updated_at;
+alter table counter_metrics drop column if exists updated_at;
@@ -0,0 +1,3 @@
+-- время последнего обновления для удаления устаревших метрик
+alter table counter_metrics add column if not exists updated_at timestamptz not null default now();
+alter table gauge_metrics add column if not exists updated_at timestamptz not null default now();
@@ -0,0 +1,2 @@
+drop index if exists gauge_metrics_name_pattern_idx;
+drop index if exists counter_metrics_name_pattern_idx;
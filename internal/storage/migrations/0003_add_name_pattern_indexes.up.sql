@@ -0,0 +1,3 @@
+-- индекс уникальности не подходит для like с учетом collation, поиск по префиксу и шаблону идет по этим индексам
+create index if not exists counter_metrics_name_pattern_idx on counter_metrics (name text_pattern_ops);
+create index if not exists gauge_metrics_name_pattern_idx on gauge_metrics (name text_pattern_ops);
@@ -0,0 +1,224 @@
+// Модуль migrations хранит версии схемы БД и приводит к ним БД
+package migrations
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+)
+
+// Миграции лежат рядом парами файлов 0001_name.up.sql и 0001_name.down.sql, версии идут подряд с 1
+//
+//go:embed *.sql
+var files embed.FS
+
+// Ключ advisory блокировки, под которой идут миграции, чтобы несколько серверов не применяли их одновременно
+const lockKey int64 = 0x6d747263
+
+var (
+	ErrBadFileName    = errors.New("bad migration file name")
+	ErrBadMigrations  = errors.New("bad migrations")
+	ErrUnknownVersion = errors.New("unknown schema version")
+)
+
+var fileNameRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration Версия схемы: скрипт перехода на нее и скрипт отката.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Прочитать миграции из fsys, отсортированные по версии
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNameRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadFileName, entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("%w: version %d has names %s and %s", ErrBadMigrations, version, m.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%w: version %d needs both up and down scripts", ErrBadMigrations, m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("%w: expected version %d, got %d", ErrBadMigrations, i+1, m.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Migrator Применяет и откатывает миграции, примененные версии хранятся в таблице schema_migrations.
+type Migrator struct {
+	p          *pgxpool.Pool
+	migrations []Migration
+}
+
+// Мигратор со встроенными миграциями
+func New(p *pgxpool.Pool) (*Migrator, error) {
+	migrations, err := Load(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{p: p, migrations: migrations}, nil
+}
+
+// Последняя известная версия схемы
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Текущая версия схемы в БД, 0 если миграции еще не применялись
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	conn, err := m.p.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	if err = createMigrationsTable(ctx, conn.Conn()); err != nil {
+		return 0, err
+	}
+
+	return currentVersion(ctx, conn.Conn())
+}
+
+// Применить все новые миграции, возвращает примененные
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.MigrateTo(ctx, m.Latest())
+}
+
+// Привести схему к версии version: применить новые миграции или откатить лишние, возвращает выполненные
+func (m *Migrator) MigrateTo(ctx context.Context, version int) ([]Migration, error) {
+	if version < 0 || version > m.Latest() {
+		return nil, fmt.Errorf("%w: %d (latest is %d)", ErrUnknownVersion, version, m.Latest())
+	}
+
+	conn, err := m.p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	// блокировка сессии, поэтому все дальше идет в этом соединении
+	if _, err = conn.Exec(ctx, `select pg_advisory_lock($1)`, lockKey); err != nil {
+		return nil, err
+	}
+	defer conn.Exec(context.Background(), `select pg_advisory_unlock($1)`, lockKey)
+
+	if err = createMigrationsTable(ctx, conn.Conn()); err != nil {
+		return nil, err
+	}
+
+	current, err := currentVersion(ctx, conn.Conn())
+	if err != nil {
+		return nil, err
+	}
+	if current > m.Latest() {
+		return nil, fmt.Errorf("%w: database is at version %d, latest known is %d", ErrUnknownVersion, current, m.Latest())
+	}
+
+	var done []Migration
+	for current < version {
+		next := m.migrations[current]
+		if err = apply(ctx, conn.Conn(), next.Up, `insert into schema_migrations (version, name) values ($1, $2)`, next.Version, next.Name); err != nil {
+			return done, fmt.Errorf("migration %d %s: %w", next.Version, next.Name, err)
+		}
+		logger.Log.Info().Msg(fmt.Sprintf("applied migration %d %s", next.Version, next.Name))
+		done = append(done, next)
+		current++
+	}
+	for current > version {
+		prev := m.migrations[current-1]
+		if err = apply(ctx, conn.Conn(), prev.Down, `delete from schema_migrations where version = $1`, prev.Version); err != nil {
+			return done, fmt.Errorf("rollback of migration %d %s: %w", prev.Version, prev.Name, err)
+		}
+		logger.Log.Info().Msg(fmt.Sprintf("rolled back migration %d %s", prev.Version, prev.Name))
+		done = append(done, prev)
+		current--
+	}
+
+	return done, nil
+}
+
+func createMigrationsTable(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, `create table if not exists schema_migrations (
+		version integer primary key,
+		name text not null,
+		applied_at timestamptz not null default now()
+	)`)
+	return err
+}
+
+func currentVersion(ctx context.Context, conn *pgx.Conn) (int, error) {
+	var version int
+	err := conn.QueryRow(ctx, `select coalesce(max(version), 0) from schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Выполнить скрипт и записать это в schema_migrations в одной транзакции
+func apply(ctx context.Context, conn *pgx.Conn, script, record string, args ...any) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// без аргументов запрос идет простым протоколом, в нем можно несколько команд
+	if _, err = tx.Exec(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
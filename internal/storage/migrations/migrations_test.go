@@ -0,0 +1,77 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []Migration
+		wantErr error
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"0002_second.up.sql":   {Data: []byte("up 2")},
+				"0002_second.down.sql": {Data: []byte("down 2")},
+				"0001_first.up.sql":    {Data: []byte("up 1")},
+				"0001_first.down.sql":  {Data: []byte("down 1")},
+			},
+			want: []Migration{
+				{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+				{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+			},
+		},
+		{
+			name:    "bad file name",
+			fsys:    fstest.MapFS{"first.sql": {Data: []byte("up")}},
+			wantErr: ErrBadFileName,
+		},
+		{
+			name:    "no down script",
+			fsys:    fstest.MapFS{"0001_first.up.sql": {Data: []byte("up")}},
+			wantErr: ErrBadMigrations,
+		},
+		{
+			name: "gap in versions",
+			fsys: fstest.MapFS{
+				"0002_second.up.sql":   {Data: []byte("up")},
+				"0002_second.down.sql": {Data: []byte("down")},
+			},
+			wantErr: ErrBadMigrations,
+		},
+		{
+			name: "different names for one version",
+			fsys: fstest.MapFS{
+				"0001_first.up.sql":   {Data: []byte("up")},
+				"0001_other.down.sql": {Data: []byte("down")},
+			},
+			wantErr: ErrBadMigrations,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := Load(test.fsys)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, migrations)
+		})
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	migrations, err := Load(files)
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	assert.Equal(t, "create_metrics", migrations[0].Name)
+}
@@ -3,12 +3,16 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/smakimka/mtrcscollector/internal/model"
 	"github.com/smakimka/mtrcscollector/internal/retry"
+	"github.com/smakimka/mtrcscollector/internal/storage/migrations"
 )
 
 // PGStorage Реализация интерфейса storage для БД postgres.
@@ -21,7 +25,7 @@ func NewPGStorage(ctx context.Context, p *pgxpool.Pool) (PGStorage, error) {
 		p: p,
 	}
 
-	err := s.CreateSchemaIfNotExists(ctx)
+	err := s.Migrate(ctx)
 	if err != nil {
 		return s, err
 	}
@@ -34,29 +38,21 @@ func (s PGStorage) Ping(ctx context.Context) error {
 	return s.p.Ping(ctx)
 }
 
-// Создание БД при первом подключении, исполняется всегда при запуске сервиса
-func (s PGStorage) CreateSchemaIfNotExists(ctx context.Context) error {
-	_, err := retry.Exec(s.p.Exec, ctx, `create table if not exists counter_metrics (
-		id serial primary key,
-		name text,
-		value bigint,
-		constraint c_name_uq unique (name)
-	)`)
-	if err != nil {
-		return err
-	}
+// Готовность: БД доступна
+func (s PGStorage) CheckHealth(ctx context.Context) error {
+	return s.Ping(ctx)
+}
 
-	_, err = retry.Exec(s.p.Exec, ctx, `create table if not exists gauge_metrics (
-		id serial primary key,
-		name text,
-		value double precision,
-		constraint g_name_uq unique (name)
-	)`)
+// Привести схему БД к последней версии, исполняется всегда при запуске сервиса.
+// Старые БД, созданные до миграций, подхватываются: первые миграции ничего не делают с уже существующими таблицами
+func (s PGStorage) Migrate(ctx context.Context) error {
+	m, err := migrations.New(s.p)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = m.Up(ctx)
+	return err
 }
 
 func (s PGStorage) UpdateCounterMetric(ctx context.Context, m model.CounterMetric) (int64, error) {
@@ -67,7 +63,7 @@ func (s PGStorage) UpdateCounterMetric(ctx context.Context, m model.CounterMetri
 	defer tx.Rollback(ctx)
 
 	row := tx.QueryRow(ctx, `insert into counter_metrics as cm (name, value) values ($1, $2) 
-							on conflict on constraint c_name_uq do update set value = cm.value + $2
+							on conflict on constraint c_name_uq do update set value = cm.value + $2, updated_at = now()
 							returning cm.value`, m.Name, m.Value)
 
 	var value int64
@@ -91,7 +87,7 @@ func (s PGStorage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMetric) e
 	defer tx.Rollback(ctx)
 
 	_, err = retry.Exec(tx.Exec, ctx, `insert into gauge_metrics (name, value) values ($1, $2) 
-										  on conflict on constraint g_name_uq do update set value = $2`, m.Name, m.Value)
+										  on conflict on constraint g_name_uq do update set value = $2, updated_at = now()`, m.Name, m.Value)
 	if err != nil {
 		return err
 	}
@@ -105,7 +101,7 @@ func (s PGStorage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMetric) e
 
 func (s PGStorage) GetGaugeMetric(ctx context.Context, name string) (model.GaugeMetric, error) {
 	var m model.GaugeMetric
-	row := s.p.QueryRow(ctx, "select name, value from gauge_metrics where name like $1", name)
+	row := s.p.QueryRow(ctx, "select name, value from gauge_metrics where name = $1", name)
 
 	err := row.Scan(&m.Name, &m.Value)
 	if err != nil {
@@ -121,7 +117,7 @@ func (s PGStorage) GetGaugeMetric(ctx context.Context, name string) (model.Gauge
 
 func (s PGStorage) GetCounterMetric(ctx context.Context, name string) (model.CounterMetric, error) {
 	var m model.CounterMetric
-	row := s.p.QueryRow(ctx, "select name, value from counter_metrics where name like $1", name)
+	row := s.p.QueryRow(ctx, "select name, value from counter_metrics where name = $1", name)
 
 	err := row.Scan(&m.Name, &m.Value)
 	if err != nil {
@@ -185,57 +181,215 @@ func (s PGStorage) GetAllCounterMetrics(ctx context.Context) ([]model.CounterMet
 	return metrics, nil
 }
 
+// Найти метрики типа kind, имя которых начинается с prefix. Префикс экранируется, поиск идет по индексу с text_pattern_ops
+func (s PGStorage) SearchByPrefix(ctx context.Context, kind, prefix string) (model.MetricsData, error) {
+	return s.search(ctx, kind, escapeLike(prefix)+"%")
+}
+
+// Найти метрики типа kind, имя которых подходит под шаблон. Часть шаблона до первого * или ? ищется по индексу
+func (s PGStorage) SearchByGlob(ctx context.Context, kind, pattern string) (model.MetricsData, error) {
+	like, err := globToLike(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.search(ctx, kind, like)
+}
+
+func (s PGStorage) search(ctx context.Context, kind, like string) (model.MetricsData, error) {
+	table, err := metricsTable(kind)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := retry.Query(s.p.Query, ctx, searchQuery(table), like)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := model.MetricsData{}
+	for rows.Next() {
+		m := model.MetricData{Kind: kind}
+		if kind == model.Gauge {
+			m.Value = new(float64)
+			err = rows.Scan(&m.Name, m.Value)
+		} else {
+			m.Delta = new(int64)
+			err = rows.Scan(&m.Name, m.Delta)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics, rows.Err()
+}
+
+// Запрос поиска по таблице table. Сортировка побайтовая (collate "C"), как у хранилищ в памяти,
+// иначе порядок зависит от локали базы
+func searchQuery(table string) string {
+	return `select name, value from ` + table + ` where name like $1 escape '\' order by name collate "C"`
+}
+
+// Записать пачку метрик двумя запросами (по одному на тип) в одной транзакции, сколько бы метрик ни было в пачке
 func (s PGStorage) UpdateMetrics(ctx context.Context, metricsData model.MetricsData) error {
+	batch, err := newPGBatch(metricsData)
+	if err != nil {
+		return err
+	}
+
 	tx, err := s.p.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
+	if len(batch.gaugeNames) > 0 {
+		_, err = retry.Exec(tx.Exec, ctx, `insert into gauge_metrics (name, value)
+											select * from unnest($1::text[], $2::double precision[])
+											on conflict on constraint g_name_uq do update set value = excluded.value, updated_at = now()`,
+			batch.gaugeNames, batch.gaugeValues)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(batch.counterNames) > 0 {
+		_, err = retry.Exec(tx.Exec, ctx, `insert into counter_metrics as cm (name, value)
+											select * from unnest($1::text[], $2::bigint[])
+											on conflict on constraint c_name_uq do update set value = cm.value + excluded.value, updated_at = now()`,
+			batch.counterNames, batch.counterDeltas)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// pgBatch Пачка метрик, сведенная к массивам для unnest. Имена не повторяются (иначе upsert задел бы строку дважды)
+// и отсортированы, чтобы параллельные пачки блокировали строки в одном порядке и не попадали во взаимную блокировку.
+type pgBatch struct {
+	gaugeNames    []string
+	gaugeValues   []float64
+	counterNames  []string
+	counterDeltas []int64
+}
+
+// Свести пачку: для gauge остается последнее значение, counter складываются. Метрики неизвестного типа пропускаются
+func newPGBatch(metricsData model.MetricsData) (pgBatch, error) {
+	gauges := make(map[string]float64)
+	counters := make(map[string]int64)
 	for _, metricData := range metricsData {
 		switch metricData.Kind {
 		case model.Gauge:
-			err = txUpdateGaugeMetric(ctx, tx, metricData)
-			if err != nil {
-				return err
+			if metricData.Value == nil {
+				return pgBatch{}, fmt.Errorf("%w: gauge %s has no value", model.ErrMissingFields, metricData.Name)
 			}
+			gauges[metricData.Name] = *metricData.Value
 		case model.Counter:
-			_, err = txUpdateCounterMetric(ctx, tx, metricData)
-			if err != nil {
-				return err
+			if metricData.Delta == nil {
+				return pgBatch{}, fmt.Errorf("%w: counter %s has no delta", model.ErrMissingFields, metricData.Name)
 			}
+			counters[metricData.Name] += *metricData.Delta
 		}
 	}
 
-	if err = tx.Commit(ctx); err != nil {
-		return err
+	batch := pgBatch{
+		gaugeNames:    sortedKeys(gauges),
+		gaugeValues:   make([]float64, 0, len(gauges)),
+		counterNames:  sortedKeys(counters),
+		counterDeltas: make([]int64, 0, len(counters)),
+	}
+	for _, name := range batch.gaugeNames {
+		batch.gaugeValues = append(batch.gaugeValues, gauges[name])
+	}
+	for _, name := range batch.counterNames {
+		batch.counterDeltas = append(batch.counterDeltas, counters[name])
 	}
 
-	return nil
+	return batch, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Таблица для типа метрики
+func metricsTable(kind string) (string, error) {
+	switch kind {
+	case model.Gauge:
+		return "gauge_metrics", nil
+	case model.Counter:
+		return "counter_metrics", nil
+	default:
+		return "", model.ErrWrongMetricKind
+	}
 }
 
-// Обновить gauge метрику в рамках транзакции
-func txUpdateGaugeMetric(ctx context.Context, tx pgx.Tx, metricData model.MetricData) error {
-	_, err := retry.Exec(tx.Exec, ctx, `insert into gauge_metrics (name, value) values ($1, $2) 
-										  on conflict on constraint g_name_uq do update set value = $2`, metricData.Name, metricData.Value)
+func (s PGStorage) Delete(ctx context.Context, kind, name string) error {
+	table, err := metricsTable(kind)
+	if err != nil {
+		return err
+	}
+
+	tag, err := retry.Exec(s.p.Exec, ctx, `delete from `+table+` where name = $1`, name)
 	if err != nil {
 		return err
 	}
 
+	if tag.RowsAffected() == 0 {
+		return ErrNoSuchMetric
+	}
+
 	return nil
 }
 
-// Обновить counter метрику в рамках транзакции
-func txUpdateCounterMetric(ctx context.Context, tx pgx.Tx, metricData model.MetricData) (int64, error) {
-	row := tx.QueryRow(ctx, `insert into counter_metrics as cm (name, value) values ($1, $2) 
-							on conflict on constraint c_name_uq do update set value = cm.value + $2
-							returning cm.value`, metricData.Name, metricData.Delta)
+func (s PGStorage) DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error) {
+	table, err := metricsTable(kind)
+	if err != nil {
+		return 0, err
+	}
 
-	var value int64
-	err := row.Scan(&value)
+	tag, err := retry.Exec(s.p.Exec, ctx, `delete from `+table+` where name like $1 escape '\'`, escapeLike(prefix)+"%")
 	if err != nil {
 		return 0, err
 	}
 
-	return value, nil
+	return tag.RowsAffected(), nil
+}
+
+func (s PGStorage) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := s.p.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var deleted int64
+	for _, table := range []string{"counter_metrics", "gauge_metrics"} {
+		tag, err := retry.Exec(tx.Exec, ctx, `delete from `+table+` where updated_at < $1`, before)
+		if err != nil {
+			return 0, err
+		}
+		deleted += tag.RowsAffected()
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
 }
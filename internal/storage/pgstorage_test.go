@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+func TestNewPGBatch(t *testing.T) {
+	first, second, one, two := 1.5, 2.5, int64(1), int64(2)
+
+	tests := []struct {
+		name    string
+		data    model.MetricsData
+		want    pgBatch
+		wantErr error
+	}{
+		{
+			name: "duplicates are merged",
+			data: model.MetricsData{
+				{Name: "Zeta", Kind: model.Gauge, Value: &first},
+				{Name: "PollCount", Kind: model.Counter, Delta: &one},
+				{Name: "Alloc", Kind: model.Gauge, Value: &first},
+				{Name: "PollCount", Kind: model.Counter, Delta: &two},
+				{Name: "Zeta", Kind: model.Gauge, Value: &second},
+				{Name: "Unknown", Kind: "unknown"},
+			},
+			want: pgBatch{
+				gaugeNames:    []string{"Alloc", "Zeta"},
+				gaugeValues:   []float64{first, second},
+				counterNames:  []string{"PollCount"},
+				counterDeltas: []int64{3},
+			},
+		},
+		{
+			name: "empty batch",
+			want: pgBatch{
+				gaugeNames:    []string{},
+				gaugeValues:   []float64{},
+				counterNames:  []string{},
+				counterDeltas: []int64{},
+			},
+		},
+		{
+			name:    "counter without delta",
+			data:    model.MetricsData{{Name: "PollCount", Kind: model.Counter}},
+			wantErr: model.ErrMissingFields,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			batch, err := newPGBatch(test.data)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, batch)
+		})
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	query := searchQuery("gauge_metrics")
+
+	assert.Contains(t, query, "from gauge_metrics ")
+	assert.True(t, strings.HasSuffix(query, `order by name collate "C"`), query)
+}
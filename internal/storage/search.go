@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+// Шаблоны поиска по имени: * - любая последовательность символов, ? - один символ,
+// \ экранирует следующий символ. Остальные символы, включая % и _, обычные.
+
+var ErrBadPattern = errors.New("bad name pattern")
+
+// Подходит ли имя под шаблон
+func MatchGlob(pattern, name string) (bool, error) {
+	match, err := globMatcher(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	return match(name), nil
+}
+
+// globToken Символ шаблона: wildcard '*' или '?', либо обычный символ.
+type globToken struct {
+	r        rune
+	wildcard bool
+}
+
+func parseGlob(pattern string) ([]globToken, error) {
+	var tokens []globToken
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, ErrBadPattern
+			}
+			i++
+			tokens = append(tokens, globToken{r: runes[i]})
+		case '*', '?':
+			tokens = append(tokens, globToken{r: runes[i], wildcard: true})
+		default:
+			tokens = append(tokens, globToken{r: runes[i]})
+		}
+	}
+	return tokens, nil
+}
+
+// Сопоставление с откатом к последней звездочке, линейное по длине имени для каждой звездочки
+func matchTokens(tokens []globToken, name []rune) bool {
+	t, n := 0, 0
+	star, starN := -1, 0
+	for n < len(name) {
+		switch {
+		case t < len(tokens) && tokens[t].wildcard && tokens[t].r == '*':
+			star, starN = t, n
+			t++
+		case t < len(tokens) && (tokens[t].wildcard && tokens[t].r == '?' || !tokens[t].wildcard && tokens[t].r == name[n]):
+			t++
+			n++
+		case star >= 0:
+			starN++
+			t, n = star+1, starN
+		default:
+			return false
+		}
+	}
+	for t < len(tokens) && tokens[t].wildcard && tokens[t].r == '*' {
+		t++
+	}
+	return t == len(tokens)
+}
+
+// Экранировать строку для like с escape '\'
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Шаблон для like с escape '\'
+func globToLike(pattern string) (string, error) {
+	tokens, err := parseGlob(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, token := range tokens {
+		switch {
+		case token.wildcard && token.r == '*':
+			b.WriteByte('%')
+		case token.wildcard:
+			b.WriteByte('_')
+		default:
+			b.WriteString(escapeLike(string(token.r)))
+		}
+	}
+	return b.String(), nil
+}
+
+// Проверка имени по шаблону поиска
+func globMatcher(pattern string) (func(string) bool, error) {
+	tokens, err := parseGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(name string) bool {
+		return matchTokens(tokens, []rune(name))
+	}, nil
+}
+
+func sortByName(metrics model.MetricsData) {
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Name < metrics[j].Name
+	})
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+		wantErr error
+	}{
+		{pattern: "Disk*", name: "DiskUsed", want: true},
+		{pattern: "Disk*", name: "Alloc", want: false},
+		{pattern: "*Used{*}", name: `DiskUsed{mount="/"}`, want: true},
+		{pattern: "Gauge?", name: "Gauge1", want: true},
+		{pattern: "Gauge?", name: "Gauge10", want: false},
+		{pattern: "*a*b*c", name: "xaxbxbxc", want: true},
+		{pattern: "*a*b*c", name: "xaxbxbxcx", want: false},
+		{pattern: "Temp?", name: "TempЖ", want: true},
+		{pattern: "100%_*", name: "100%_used", want: true},
+		{pattern: "100%_*", name: "100ab", want: false},
+		{pattern: `Star\*`, name: "Star*", want: true},
+		{pattern: `Star\*`, name: "Stars", want: false},
+		{pattern: "", name: "", want: true},
+		{pattern: `bad\`, wantErr: ErrBadPattern},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern+" "+test.name, func(t *testing.T) {
+			ok, err := MatchGlob(test.pattern, test.name)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, ok)
+		})
+	}
+}
+
+func TestGlobToLike(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{pattern: "Disk*", want: "Disk%"},
+		{pattern: "Gauge?", want: "Gauge_"},
+		{pattern: "100%_*", want: `100\%\_%`},
+		{pattern: `Star\*\\`, want: `Star*\\`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			like, err := globToLike(test.pattern)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, like)
+		})
+	}
+
+	assert.Equal(t, `a\%b\_c\\d`, escapeLike(`a%b_c\d`))
+}
+
+func TestSearch(t *testing.T) {
+	storages := map[string]Storage{
+		"mem":     NewMemStorage(),
+		"sharded": NewShardedMemStorage(4),
+	}
+
+	ctx := context.Background()
+	for name, s := range storages {
+		t.Run(name, func(t *testing.T) {
+			for _, metric := range []string{`DiskUsed{mount="/"}`, `DiskUsed{mount="/home"}`, "DiskFree", "Disk_Free", "Alloc", "net_rx", "NetTx", "Net.rx", "Net-tx"} {
+				require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: metric, Value: 1}))
+			}
+			_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "DiskReadCount", Value: 2})
+			require.NoError(t, err)
+
+			tests := []struct {
+				name    string
+				search  func() (model.MetricsData, error)
+				want    []string
+				wantErr error
+			}{
+				{
+					name:   "prefix",
+					search: func() (model.MetricsData, error) { return s.SearchByPrefix(ctx, model.Gauge, "DiskUsed") },
+					want:   []string{`DiskUsed{mount="/"}`, `DiskUsed{mount="/home"}`},
+				},
+				{
+					name:   "prefix with like wildcard is literal",
+					search: func() (model.MetricsData, error) { return s.SearchByPrefix(ctx, model.Gauge, "Disk_") },
+					want:   []string{"Disk_Free"},
+				},
+				{
+					name:   "glob",
+					search: func() (model.MetricsData, error) { return s.SearchByGlob(ctx, model.Gauge, "Disk*Free") },
+					want:   []string{"DiskFree", "Disk_Free"},
+				},
+				{
+					name:   "mixed case and punctuation in byte order",
+					search: func() (model.MetricsData, error) { return s.SearchByGlob(ctx, model.Gauge, "*et*") },
+					want:   []string{"Net-tx", "Net.rx", "NetTx", "net_rx"},
+				},
+				{
+					name:   "glob for counters",
+					search: func() (model.MetricsData, error) { return s.SearchByGlob(ctx, model.Counter, "*Count") },
+					want:   []string{"DiskReadCount"},
+				},
+				{
+					name:   "nothing found",
+					search: func() (model.MetricsData, error) { return s.SearchByPrefix(ctx, model.Counter, "Alloc") },
+					want:   []string{},
+				},
+				{
+					name:    "wrong kind",
+					search:  func() (model.MetricsData, error) { return s.SearchByPrefix(ctx, "unknown", "") },
+					wantErr: model.ErrWrongMetricKind,
+				},
+				{
+					name:    "bad pattern",
+					search:  func() (model.MetricsData, error) { return s.SearchByGlob(ctx, model.Gauge, `Disk\`) },
+					wantErr: ErrBadPattern,
+				},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					metrics, err := test.search()
+					if test.wantErr != nil {
+						assert.ErrorIs(t, err, test.wantErr)
+						return
+					}
+					require.NoError(t, err)
+
+					names := []string{}
+					for _, m := range metrics {
+						names = append(names, m.Name)
+					}
+					assert.Equal(t, test.want, names)
+				})
+			}
+
+			metrics, err := s.SearchByPrefix(ctx, model.Counter, "Disk")
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			assert.Equal(t, int64(2), *metrics[0].Delta)
+			assert.Nil(t, metrics[0].Value)
+		})
+	}
+}
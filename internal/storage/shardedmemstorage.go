@@ -0,0 +1,471 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"hash/maphash"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+// DefaultShards Число частей ShardedMemStorage по умолчанию.
+const DefaultShards = 32
+
+// ShardedMemStorage Реализация интерфейса storage для хранения данных в памяти, разбитых на части по хешу имени.
+// Значения метрик хранятся в атомиках, поэтому чтение и обновление уже существующих метрик идут под блокировкой
+// своей части на чтение. Карты части меняются на месте под блокировкой на запись только при добавлении и удалении метрик.
+type ShardedMemStorage struct {
+	shards []memShard
+	seed   maphash.Seed
+
+	// файл, в который последний раз сохранялись данные, и результат сохранения, для проверки готовности
+	saveFilePath string
+	lastSaveErr  error
+	snapshots    SnapshotOptions
+	saveMutex    sync.Mutex
+}
+
+// memShard Часть метрик. Карты читаются под mutex.RLock, добавление и удаление записей идет под mutex.Lock.
+type memShard struct {
+	mutex   sync.RWMutex
+	metrics shardMetrics
+}
+
+type shardMetrics struct {
+	gauges   map[string]*metricEntry
+	counters map[string]*metricEntry
+}
+
+// metricEntry Значение метрики (биты float64 для gauge) и время обновления в наносекундах.
+type metricEntry struct {
+	value   atomic.Int64
+	updated atomic.Int64
+}
+
+func newMetricEntry(value int64, updated time.Time) *metricEntry {
+	entry := &metricEntry{}
+	entry.value.Store(value)
+	entry.updated.Store(updated.UnixNano())
+	return entry
+}
+
+func NewShardedMemStorage(shards int) *ShardedMemStorage {
+	s := &ShardedMemStorage{
+		shards:    make([]memShard, max(shards, 1)),
+		seed:      maphash.MakeSeed(),
+		snapshots: DefaultSnapshotOptions,
+	}
+	for i := range s.shards {
+		s.shards[i].metrics = shardMetrics{
+			gauges:   make(map[string]*metricEntry),
+			counters: make(map[string]*metricEntry),
+		}
+	}
+	return s
+}
+
+// Номер части метрики по хешу имени
+func (s *ShardedMemStorage) index(name string) int {
+	return int(maphash.String(s.seed, name) % uint64(len(s.shards)))
+}
+
+func (s *ShardedMemStorage) shard(name string) *memShard {
+	return &s.shards[s.index(name)]
+}
+
+// Карта метрик типа kind, nil для неизвестного типа
+func (m *shardMetrics) kind(kind string) map[string]*metricEntry {
+	switch kind {
+	case model.Gauge:
+		return m.gauges
+	case model.Counter:
+		return m.counters
+	}
+	return nil
+}
+
+func (sh *memShard) lookup(kind, name string) *metricEntry {
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	return sh.metrics.kind(kind)[name]
+}
+
+// Найти или создать записи для метрик names одного типа. Новые метрики добавляются в карту части на месте,
+// без ее копирования, поэтому создание метрики не дорожает с ростом их числа.
+// Новая запись видна сразу с нулевым значением, до того как вызывающий запишет в нее свое
+func (sh *memShard) getOrCreate(kind string, names []string, entries []*metricEntry) {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	current := sh.metrics.kind(kind)
+	for i, name := range names {
+		entry, ok := current[name]
+		if !ok {
+			entry = newMetricEntry(0, time.Now())
+			current[name] = entry
+		}
+		entries[i] = entry
+	}
+}
+
+// Вызвать f для каждой метрики типа kind под блокировкой части на чтение
+func (sh *memShard) each(kind string, f func(name string, entry *metricEntry)) {
+	sh.mutex.RLock()
+	defer sh.mutex.RUnlock()
+
+	for name, entry := range sh.metrics.kind(kind) {
+		f(name, entry)
+	}
+}
+
+// Запись метрики, создается при первом обновлении
+func (s *ShardedMemStorage) entry(kind, name string) *metricEntry {
+	sh := s.shard(name)
+	if entry := sh.lookup(kind, name); entry != nil {
+		return entry
+	}
+
+	entries := make([]*metricEntry, 1)
+	sh.getOrCreate(kind, []string{name}, entries)
+	return entries[0]
+}
+
+// Получение gauge метрики по имени
+func (s *ShardedMemStorage) GetGaugeMetric(ctx context.Context, name string) (model.GaugeMetric, error) {
+	entry := s.shard(name).lookup(model.Gauge, name)
+	if entry == nil {
+		return model.GaugeMetric{}, ErrNoSuchMetric
+	}
+
+	return model.GaugeMetric{Name: name, Value: math.Float64frombits(uint64(entry.value.Load()))}, nil
+}
+
+// Получение counter метрики по имени
+func (s *ShardedMemStorage) GetCounterMetric(ctx context.Context, name string) (model.CounterMetric, error) {
+	entry := s.shard(name).lookup(model.Counter, name)
+	if entry == nil {
+		return model.CounterMetric{}, ErrNoSuchMetric
+	}
+
+	return model.CounterMetric{Name: name, Value: entry.value.Load()}, nil
+}
+
+// Получение всех gauge метрик
+func (s *ShardedMemStorage) GetAllGaugeMetrics(ctx context.Context) ([]model.GaugeMetric, error) {
+	metrics := []model.GaugeMetric{}
+	for i := range s.shards {
+		s.shards[i].each(model.Gauge, func(name string, entry *metricEntry) {
+			metrics = append(metrics, model.GaugeMetric{Name: name, Value: math.Float64frombits(uint64(entry.value.Load()))})
+		})
+	}
+
+	return metrics, nil
+}
+
+// Получение всех counter метрик
+func (s *ShardedMemStorage) GetAllCounterMetrics(ctx context.Context) ([]model.CounterMetric, error) {
+	metrics := []model.CounterMetric{}
+	for i := range s.shards {
+		s.shards[i].each(model.Counter, func(name string, entry *metricEntry) {
+			metrics = append(metrics, model.CounterMetric{Name: name, Value: entry.value.Load()})
+		})
+	}
+
+	return metrics, nil
+}
+
+// Найти метрики типа kind, имя которых начинается с prefix
+func (s *ShardedMemStorage) SearchByPrefix(ctx context.Context, kind, prefix string) (model.MetricsData, error) {
+	return s.search(kind, func(name string) bool { return strings.HasPrefix(name, prefix) })
+}
+
+// Найти метрики типа kind, имя которых подходит под шаблон
+func (s *ShardedMemStorage) SearchByGlob(ctx context.Context, kind, pattern string) (model.MetricsData, error) {
+	match, err := globMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.search(kind, match)
+}
+
+func (s *ShardedMemStorage) search(kind string, match func(string) bool) (model.MetricsData, error) {
+	if kind != model.Gauge && kind != model.Counter {
+		return nil, model.ErrWrongMetricKind
+	}
+
+	metrics := model.MetricsData{}
+	for i := range s.shards {
+		s.shards[i].each(kind, func(name string, entry *metricEntry) {
+			if !match(name) {
+				return
+			}
+
+			m := model.MetricData{Name: name, Kind: kind}
+			if kind == model.Gauge {
+				value := math.Float64frombits(uint64(entry.value.Load()))
+				m.Value = &value
+			} else {
+				value := entry.value.Load()
+				m.Delta = &value
+			}
+			metrics = append(metrics, m)
+		})
+	}
+
+	sortByName(metrics)
+	return metrics, nil
+}
+
+// Обновить gauge метрику по имени, значение будет перезаписано
+func (s *ShardedMemStorage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMetric) error {
+	entry := s.entry(model.Gauge, m.Name)
+	entry.value.Store(int64(math.Float64bits(m.Value)))
+	entry.updated.Store(time.Now().UnixNano())
+	logger.Log.Debug().Str("name", m.Name).Float64("value", m.Value).Msg("updated gauge metric")
+
+	return nil
+}
+
+// Обновить counter метрику по имени, значение будет добавлено к текущему или к 0
+func (s *ShardedMemStorage) UpdateCounterMetric(ctx context.Context, m model.CounterMetric) (int64, error) {
+	entry := s.entry(model.Counter, m.Name)
+	newValue := entry.value.Add(m.Value)
+	entry.updated.Store(time.Now().UnixNano())
+	logger.Log.Debug().Str("name", m.Name).Int64("value", newValue).Msg("updated counter metric")
+
+	return newValue, nil
+}
+
+// Выполнить соответствующий update по всем метрикам по порядку. Лог пишется полями, чтобы без debug уровня не было аллокаций.
+// Сначала находятся записи всех метрик пачки, потом значения применяются в исходном порядке
+func (s *ShardedMemStorage) UpdateMetrics(ctx context.Context, metricsData model.MetricsData) error {
+	entries := make([]*metricEntry, len(metricsData))
+
+	// метрики, которых еще нет, по частям и типам
+	type missingKey struct {
+		shard *memShard
+		kind  string
+	}
+	var missing map[missingKey][]int
+	for i, metricData := range metricsData {
+		sh := s.shard(metricData.Name)
+		if entries[i] = sh.lookup(metricData.Kind, metricData.Name); entries[i] != nil {
+			continue
+		}
+		if metricData.Kind != model.Gauge && metricData.Kind != model.Counter {
+			continue
+		}
+		if missing == nil {
+			missing = make(map[missingKey][]int)
+		}
+		key := missingKey{shard: sh, kind: metricData.Kind}
+		missing[key] = append(missing[key], i)
+	}
+
+	for key, idx := range missing {
+		names := make([]string, len(idx))
+		for j, i := range idx {
+			names[j] = metricsData[i].Name
+		}
+		created := make([]*metricEntry, len(idx))
+		key.shard.getOrCreate(key.kind, names, created)
+		for j, i := range idx {
+			entries[i] = created[j]
+		}
+	}
+
+	now := time.Now().UnixNano()
+	for i, metricData := range metricsData {
+		entry := entries[i]
+		if entry == nil {
+			continue
+		}
+
+		switch metricData.Kind {
+		case model.Gauge:
+			entry.value.Store(int64(math.Float64bits(*metricData.Value)))
+			logger.Log.Debug().Str("name", metricData.Name).Float64("value", *metricData.Value).Msg("updated gauge metric")
+		case model.Counter:
+			newValue := entry.value.Add(*metricData.Delta)
+			logger.Log.Debug().Str("name", metricData.Name).Int64("value", newValue).Msg("updated counter metric")
+		}
+		entry.updated.Store(now)
+	}
+
+	return nil
+}
+
+// Удалить метрику по типу и имени.
+// Обновление, которое успело найти запись до удаления, считается выполненным до него
+func (s *ShardedMemStorage) Delete(ctx context.Context, kind, name string) error {
+	if kind != model.Gauge && kind != model.Counter {
+		return model.ErrWrongMetricKind
+	}
+
+	sh := s.shard(name)
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	current := sh.metrics.kind(kind)
+	if _, ok := current[name]; !ok {
+		return ErrNoSuchMetric
+	}
+
+	delete(current, name)
+	logger.Log.Debug().Str("kind", kind).Str("name", name).Msg("deleted metric")
+	return nil
+}
+
+// Удалить все метрики типа kind, имя которых начинается с prefix, возвращает количество удаленных
+func (s *ShardedMemStorage) DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error) {
+	if kind != model.Gauge && kind != model.Counter {
+		return 0, model.ErrWrongMetricKind
+	}
+
+	var deleted int64
+	for i := range s.shards {
+		deleted += s.shards[i].deleteKind(kind, func(name string, _ *metricEntry) bool {
+			return strings.HasPrefix(name, prefix)
+		})
+	}
+
+	return deleted, nil
+}
+
+// Удалить метрики обоих типов, которые не обновлялись с момента before, возвращает количество удаленных
+func (s *ShardedMemStorage) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	stale := func(_ string, entry *metricEntry) bool {
+		return entry.updated.Load() < before.UnixNano()
+	}
+
+	var deleted int64
+	for i := range s.shards {
+		deleted += s.shards[i].deleteKind(model.Gauge, stale)
+		deleted += s.shards[i].deleteKind(model.Counter, stale)
+	}
+
+	return deleted, nil
+}
+
+// Удалить метрики типа kind, подходящие под match
+func (sh *memShard) deleteKind(kind string, match func(string, *metricEntry) bool) int64 {
+	sh.mutex.Lock()
+	defer sh.mutex.Unlock()
+
+	current := sh.metrics.kind(kind)
+	var deleted int64
+	for name, entry := range current {
+		if match(name, entry) {
+			delete(current, name)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// Функиця для сохранения данных в файл. Части читаются по очереди без общей блокировки,
+// поэтому снимок согласован для каждой метрики, но не между метриками
+func (s *ShardedMemStorage) Save(filePath string) error {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	data := SaveData{
+		GaugeMetrics:   make(map[string]float64),
+		CounterMetrics: make(map[string]int64),
+		GaugeUpdated:   make(map[string]time.Time),
+		CounterUpdated: make(map[string]time.Time),
+	}
+	for i := range s.shards {
+		s.shards[i].each(model.Gauge, func(name string, entry *metricEntry) {
+			data.GaugeMetrics[name] = math.Float64frombits(uint64(entry.value.Load()))
+			data.GaugeUpdated[name] = time.Unix(0, entry.updated.Load())
+		})
+		s.shards[i].each(model.Counter, func(name string, entry *metricEntry) {
+			data.CounterMetrics[name] = entry.value.Load()
+			data.CounterUpdated[name] = time.Unix(0, entry.updated.Load())
+		})
+	}
+
+	byteData, err := encodeSnapshot(data, s.snapshots.Format)
+	if err == nil {
+		err = writeSnapshot(filePath, byteData, s.snapshots.Keep)
+	}
+
+	s.saveFilePath = filePath
+	s.lastSaveErr = err
+
+	return err
+}
+
+// Функиця для восстановления данных из самого свежего целого снимка, текущие метрики заменяются
+func (s *ShardedMemStorage) Restore(filePath string) error {
+	metricsData, ok, err := readLatestSnapshot(filePath)
+	if err != nil || !ok {
+		return err
+	}
+
+	next := make([]shardMetrics, len(s.shards))
+	for i := range next {
+		next[i] = shardMetrics{
+			gauges:   make(map[string]*metricEntry),
+			counters: make(map[string]*metricEntry),
+		}
+	}
+
+	// в сохранениях старого формата нет времени обновления, такие метрики считаются обновленными сейчас
+	now := time.Now()
+	updatedAt := func(updated map[string]time.Time, name string) time.Time {
+		if t, ok := updated[name]; ok {
+			return t
+		}
+		return now
+	}
+	for name, value := range metricsData.GaugeMetrics {
+		next[s.index(name)].gauges[name] = newMetricEntry(int64(math.Float64bits(value)), updatedAt(metricsData.GaugeUpdated, name))
+	}
+	for name, value := range metricsData.CounterMetrics {
+		next[s.index(name)].counters[name] = newMetricEntry(value, updatedAt(metricsData.CounterUpdated, name))
+	}
+
+	for i := range s.shards {
+		s.shards[i].mutex.Lock()
+		s.shards[i].metrics = next[i]
+		s.shards[i].mutex.Unlock()
+	}
+
+	return nil
+}
+
+// Настройки снимков: формат и сколько снимков хранить вместе с текущим
+func (s *ShardedMemStorage) SetSnapshotOptions(opts SnapshotOptions) {
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	s.snapshots = opts
+}
+
+// Готовность: последнее сохранение прошло успешно и в папку файла сохранения можно писать
+func (s *ShardedMemStorage) CheckHealth(ctx context.Context) error {
+	s.saveMutex.Lock()
+	filePath, lastSaveErr := s.saveFilePath, s.lastSaveErr
+	s.saveMutex.Unlock()
+
+	if lastSaveErr != nil {
+		return fmt.Errorf("last save failed: %w", lastSaveErr)
+	}
+	if filePath == "" {
+		return nil
+	}
+
+	return checkWritable(filepath.Dir(filePath))
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+func TestShardedUpdateMetrics(t *testing.T) {
+	s := NewShardedMemStorage(4)
+	ctx := context.Background()
+
+	first, second, delta := 1.5, 2.5, int64(2)
+	err := s.UpdateMetrics(ctx, model.MetricsData{
+		{Name: "Alloc", Kind: model.Gauge, Value: &first},
+		{Name: "PollCount", Kind: model.Counter, Delta: &delta},
+		{Name: "Alloc", Kind: model.Gauge, Value: &second},
+		{Name: "PollCount", Kind: model.Counter, Delta: &delta},
+		{Name: "Unknown", Kind: "unknown"},
+	})
+	require.NoError(t, err)
+
+	gauge, err := s.GetGaugeMetric(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, second, gauge.Value)
+
+	counter, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter)
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Other", Value: 3}))
+	gauges, err := s.GetAllGaugeMetrics(ctx)
+	require.NoError(t, err)
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].Name < gauges[j].Name })
+	assert.Equal(t, []model.GaugeMetric{{Name: "Alloc", Value: second}, {Name: "Other", Value: 3}}, gauges)
+
+	counters, err := s.GetAllCounterMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.CounterMetric{{Name: "PollCount", Value: 5}}, counters)
+
+	_, err = s.GetCounterMetric(ctx, "Alloc")
+	assert.ErrorIs(t, err, ErrNoSuchMetric)
+}
+
+func TestShardedDelete(t *testing.T) {
+	ctx := context.Background()
+	s := NewShardedMemStorage(4)
+
+	for _, name := range []string{"DiskUsed{mount=\"/\"}", "DiskUsed{mount=\"/home\"}", "DiskFree", "Alloc"} {
+		require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: name, Value: 1}))
+	}
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "DiskReadCount", Value: 1})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		delete  func() (int64, error)
+		want    int64
+		wantErr error
+	}{
+		{
+			name:   "by prefix",
+			delete: func() (int64, error) { return s.DeleteByPrefix(ctx, model.Gauge, "DiskUsed") },
+			want:   2,
+		},
+		{
+			name:    "by prefix with wrong kind",
+			delete:  func() (int64, error) { return s.DeleteByPrefix(ctx, "unknown", "Disk") },
+			wantErr: model.ErrWrongMetricKind,
+		},
+		{
+			name:   "by name",
+			delete: func() (int64, error) { return 1, s.Delete(ctx, model.Gauge, "DiskFree") },
+			want:   1,
+		},
+		{
+			name:    "missing",
+			delete:  func() (int64, error) { return 0, s.Delete(ctx, model.Counter, "DiskFree") },
+			wantErr: ErrNoSuchMetric,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deleted, err := test.delete()
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, deleted)
+		})
+	}
+
+	gauges, err := s.GetAllGaugeMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.GaugeMetric{{Name: "Alloc", Value: 1}}, gauges)
+}
+
+func TestShardedDeleteStale(t *testing.T) {
+	s := NewShardedMemStorage(4)
+	ctx := context.Background()
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "old", Value: 1}))
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "old", Value: 1})
+	require.NoError(t, err)
+
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "fresh", Value: 2}))
+
+	deleted, err := s.DeleteStale(ctx, before.Add(time.Nanosecond))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	gauges, err := s.GetAllGaugeMetrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []model.GaugeMetric{{Name: "fresh", Value: 2}}, gauges)
+}
+
+func TestShardedSaveRestore(t *testing.T) {
+	ctx := context.Background()
+	testFilePath := filepath.Join(t.TempDir(), "metrics.json")
+
+	// снимки совместимы с MemStorage в обе стороны
+	s := NewMemStorage()
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1.5}))
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 3})
+	require.NoError(t, err)
+	require.NoError(t, s.Save(testFilePath))
+
+	sharded := NewShardedMemStorage(4)
+	sharded.SetSnapshotOptions(SnapshotOptions{Keep: 1, Format: SnapshotBinary})
+	require.NoError(t, sharded.Restore(testFilePath))
+	_, err = sharded.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+	require.NoError(t, err)
+	require.NoError(t, sharded.Save(testFilePath))
+	require.NoError(t, sharded.CheckHealth(ctx))
+
+	restored := NewMemStorage()
+	require.NoError(t, restored.Restore(testFilePath))
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, restored.gaugeMetrics)
+	assert.Equal(t, map[string]int64{"PollCount": 4}, restored.counterMetrics)
+	assert.Equal(t, s.gaugeUpdated["Alloc"].UnixNano(), restored.gaugeUpdated["Alloc"].UnixNano())
+}
+
+func TestShardedConcurrentUpdates(t *testing.T) {
+	s := NewShardedMemStorage(4)
+	ctx := context.Background()
+
+	const workers, updates = 8, 100
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				assert.NoError(t, s.UpdateMetrics(ctx, updateBatch(10, i)))
+			}
+		}()
+	}
+	wg.Wait()
+
+	counters, err := s.GetAllCounterMetrics(ctx)
+	require.NoError(t, err)
+	require.Len(t, counters, 10)
+	for _, counter := range counters {
+		assert.Equal(t, int64(workers*updates), counter.Value)
+	}
+}
+
+// Пачка из n gauge и n counter метрик, как от агента
+func updateBatch(n, i int) model.MetricsData {
+	data := make(model.MetricsData, 0, 2*n)
+	delta := int64(1)
+	for j := 0; j < n; j++ {
+		value := float64(i * j)
+		data = append(data,
+			model.MetricData{Name: fmt.Sprintf("Gauge%d", j), Kind: model.Gauge, Value: &value},
+			model.MetricData{Name: fmt.Sprintf("Counter%d", j), Kind: model.Counter, Delta: &delta},
+		)
+	}
+	return data
+}
+
+func BenchmarkUpdateMetricsParallel(b *testing.B) {
+	storages := []struct {
+		name string
+		new  func() Storage
+	}{
+		{name: "mem", new: func() Storage { return NewMemStorage() }},
+		{name: "sharded", new: func() Storage { return NewShardedMemStorage(DefaultShards) }},
+	}
+
+	logger.SetLevel(logger.Info)
+	ctx := context.Background()
+	batch := updateBatch(50, 1)
+	for _, storage := range storages {
+		b.Run(storage.name, func(b *testing.B) {
+			s := storage.new()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := s.UpdateMetrics(ctx, batch); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkGetMetricParallel(b *testing.B) {
+	storages := []struct {
+		name string
+		new  func() Storage
+	}{
+		{name: "mem", new: func() Storage { return NewMemStorage() }},
+		{name: "sharded", new: func() Storage { return NewShardedMemStorage(DefaultShards) }},
+	}
+
+	logger.SetLevel(logger.Info)
+	ctx := context.Background()
+	for _, storage := range storages {
+		b.Run(storage.name, func(b *testing.B) {
+			s := storage.new()
+			if err := s.UpdateMetrics(ctx, updateBatch(50, 1)); err != nil {
+				b.Fatal(err)
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				// пока одни читают, другие пишут
+				for i := 0; pb.Next(); i++ {
+					if i%10 == 0 {
+						if err := s.UpdateMetrics(ctx, updateBatch(50, i)); err != nil {
+							b.Error(err)
+						}
+						continue
+					}
+					if _, err := s.GetGaugeMetric(ctx, "Gauge1"); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// Каждое обновление создает новую метрику, время на одну метрику не должно расти с их числом
+func BenchmarkNewSeries(b *testing.B) {
+	storages := []struct {
+		name string
+		new  func() Storage
+	}{
+		{name: "mem", new: func() Storage { return NewMemStorage() }},
+		{name: "sharded", new: func() Storage { return NewShardedMemStorage(DefaultShards) }},
+	}
+
+	logger.SetLevel(logger.Info)
+	ctx := context.Background()
+	for _, storage := range storages {
+		b.Run(storage.name+"/single", func(b *testing.B) {
+			s := storage.new()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: fmt.Sprintf("Gauge%d", i), Value: 1}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(storage.name+"/batch", func(b *testing.B) {
+			s := storage.new()
+			value := 1.0
+			batch := make(model.MetricsData, 10)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for j := range batch {
+					batch[j] = model.MetricData{Name: fmt.Sprintf("Gauge%d_%d", i, j), Kind: model.Gauge, Value: &value}
+				}
+				if err := s.UpdateMetrics(ctx, batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
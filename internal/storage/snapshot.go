@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+)
+
+// Версия формата снимка, старые снимки без версии читаются как раньше
+const SnapshotVersion = 2
+
+// DefaultSnapshotKeep Сколько снимков хранится по умолчанию вместе с текущим.
+const DefaultSnapshotKeep = 3
+
+// SnapshotFormat Формат, в котором снимок пишется на диск. Читаются снимки в любом формате.
+type SnapshotFormat string
+
+const (
+	SnapshotJSON SnapshotFormat = "json"
+	// компактнее и быстрее json, см. SaveData.MarshalBinary
+	SnapshotBinary SnapshotFormat = "binary"
+)
+
+// SnapshotOptions Настройки сохранения снимков.
+type SnapshotOptions struct {
+	Keep   int
+	Format SnapshotFormat
+}
+
+var DefaultSnapshotOptions = SnapshotOptions{Keep: DefaultSnapshotKeep, Format: SnapshotJSON}
+
+var (
+	ErrNoValidSnapshot            = errors.New("no valid snapshot")
+	ErrSnapshotChecksum           = errors.New("snapshot checksum mismatch")
+	ErrUnsupportedSnapshotVersion = errors.New("unsupported snapshot version")
+	ErrUnknownSnapshotFormat      = errors.New("unknown snapshot format")
+)
+
+func ParseSnapshotFormat(format string) (SnapshotFormat, error) {
+	switch SnapshotFormat(format) {
+	case SnapshotJSON, SnapshotBinary:
+		return SnapshotFormat(format), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownSnapshotFormat, format)
+	}
+}
+
+// Начало двоичного снимка, дальше версия (varint), sha256 данных и сами данные
+var binarySnapshotMagic = []byte("MTRCSNAP")
+
+// snapshotFile Снимок на диске: данные и контрольная сумма sha256 от них.
+type snapshotFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Имя снимка: 0 - текущий, дальше предыдущие по порядку
+func snapshotName(filePath string, n int) string {
+	if n == 0 {
+		return filePath
+	}
+	return fmt.Sprintf("%s.%d", filePath, n)
+}
+
+// Закодировать данные снимка вместе с версией и контрольной суммой
+func encodeSnapshot(data SaveData, format SnapshotFormat) ([]byte, error) {
+	switch format {
+	case SnapshotBinary:
+		payload, err := data.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(payload)
+
+		buf := make([]byte, 0, len(binarySnapshotMagic)+binary.MaxVarintLen64+len(sum)+len(payload))
+		buf = append(buf, binarySnapshotMagic...)
+		buf = binary.AppendUvarint(buf, SnapshotVersion)
+		buf = append(buf, sum[:]...)
+		return append(buf, payload...), nil
+	case SnapshotJSON, "":
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(payload)
+
+		return json.Marshal(snapshotFile{
+			Version:  SnapshotVersion,
+			Checksum: hex.EncodeToString(sum[:]),
+			Data:     payload,
+		})
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSnapshotFormat, format)
+	}
+}
+
+// Раскодировать и проверить снимок в любом формате
+func decodeSnapshot(byteData []byte) (SaveData, error) {
+	if bytes.HasPrefix(byteData, binarySnapshotMagic) {
+		return decodeBinarySnapshot(byteData[len(binarySnapshotMagic):])
+	}
+
+	metricsData := SaveData{}
+
+	var snapshot snapshotFile
+	if err := json.Unmarshal(byteData, &snapshot); err != nil {
+		return metricsData, err
+	}
+
+	switch {
+	case snapshot.Version == 0:
+		// старый формат, данные лежат прямо в файле
+		err := json.Unmarshal(byteData, &metricsData)
+		return metricsData, err
+	case snapshot.Version > SnapshotVersion:
+		return metricsData, fmt.Errorf("%w: %d", ErrUnsupportedSnapshotVersion, snapshot.Version)
+	}
+
+	sum := sha256.Sum256(snapshot.Data)
+	if hex.EncodeToString(sum[:]) != snapshot.Checksum {
+		return metricsData, ErrSnapshotChecksum
+	}
+
+	err := json.Unmarshal(snapshot.Data, &metricsData)
+	return metricsData, err
+}
+
+func decodeBinarySnapshot(byteData []byte) (SaveData, error) {
+	metricsData := SaveData{}
+
+	version, n := binary.Uvarint(byteData)
+	if n <= 0 {
+		return metricsData, fmt.Errorf("%w: bad version", ErrBrokenBinarySnapshot)
+	}
+	if version > SnapshotVersion {
+		return metricsData, fmt.Errorf("%w: %d", ErrUnsupportedSnapshotVersion, version)
+	}
+	byteData = byteData[n:]
+
+	if len(byteData) < sha256.Size {
+		return metricsData, fmt.Errorf("%w: no checksum", ErrBrokenBinarySnapshot)
+	}
+	checksum, payload := byteData[:sha256.Size], byteData[sha256.Size:]
+	if sum := sha256.Sum256(payload); !bytes.Equal(sum[:], checksum) {
+		return metricsData, ErrSnapshotChecksum
+	}
+
+	err := metricsData.UnmarshalBinary(payload)
+	return metricsData, err
+}
+
+// Записать снимок через временный файл и переименование, предыдущие снимки сдвигаются, остается keep штук.
+// Файл и папка сбрасываются на диск, чтобы после сбоя остался хотя бы один целый снимок
+func writeSnapshot(filePath string, byteData []byte, keep int) error {
+	tmp, err := writeTemp(filePath, byteData, true)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err = rotateSnapshots(filePath, keep); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filePath); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(filePath))
+}
+
+// Заменить текущий снимок через временный файл и переименование, без сдвига предыдущих и сброса на диск
+func replaceSnapshot(filePath string, byteData []byte) error {
+	tmp, err := writeTemp(filePath, byteData, false)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	return os.Rename(tmp, filePath)
+}
+
+// Записать данные во временный файл рядом с filePath, возвращает его имя
+func writeTemp(filePath string, byteData []byte, sync bool) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = tmp.Write(byteData); err == nil {
+		err = tmp.Chmod(0o644)
+	}
+	if err == nil && sync {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// Сдвинуть снимки на один назад, чтобы вместе с новым их осталось keep
+func rotateSnapshots(filePath string, keep int) error {
+	keep = max(keep, 1)
+
+	// лишние снимки могли остаться, если keep уменьшили
+	for n := keep; ; n++ {
+		err := os.Remove(snapshotName(filePath, n))
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for n := keep - 1; n > 0; n-- {
+		err := os.Rename(snapshotName(filePath, n-1), snapshotName(filePath, n))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Сбросить на диск переименования в папке
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Прочитать и проверить снимок
+func readSnapshot(filePath string) (SaveData, error) {
+	byteData, err := os.ReadFile(filePath)
+	if err != nil {
+		return SaveData{}, err
+	}
+
+	return decodeSnapshot(byteData)
+}
+
+// Переписать снимок src в формате format в файл dst, например чтобы перевести сохраненные данные в другой формат
+func ConvertSnapshot(src, dst string, format SnapshotFormat) error {
+	metricsData, err := readSnapshot(src)
+	if err != nil {
+		return err
+	}
+
+	byteData, err := encodeSnapshot(metricsData, format)
+	if err != nil {
+		return err
+	}
+
+	return writeSnapshot(dst, byteData, 1)
+}
+
+// Прочитать самый свежий целый снимок. Если снимков нет - пустые данные без ошибки
+func readLatestSnapshot(filePath string) (SaveData, bool, error) {
+	var errs []error
+	for n := 0; ; n++ {
+		name := snapshotName(filePath, n)
+		metricsData, err := readSnapshot(name)
+		if err == nil {
+			if len(errs) > 0 {
+				logger.Log.Warn().Msg(fmt.Sprintf("restored from older snapshot %s", name))
+			}
+			return metricsData, true, nil
+		}
+
+		if errors.Is(err, os.ErrNotExist) {
+			// текущего снимка может не быть, если сервер упал между сдвигом снимков и переименованием нового
+			if n == 0 {
+				continue
+			}
+			break
+		}
+
+		logger.Log.Warn().Msg(fmt.Sprintf("skipping broken snapshot %s: %v", name, err))
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+
+	if len(errs) > 0 {
+		return SaveData{}, false, fmt.Errorf("%w: %w", ErrNoValidSnapshot, errors.Join(errs...))
+	}
+	return SaveData{}, false, nil
+}
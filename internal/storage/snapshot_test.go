@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+func saveGauge(t *testing.T, filePath string, keep int, value float64) {
+	s := NewMemStorage()
+	s.SetSnapshotOptions(SnapshotOptions{Keep: keep, Format: SnapshotJSON})
+	s.gaugeMetrics["test"] = value
+	require.NoError(t, s.Save(filePath))
+}
+
+func restoredGauge(t *testing.T, filePath string) (float64, error) {
+	s := NewMemStorage()
+	err := s.Restore(filePath)
+	return s.gaugeMetrics["test"], err
+}
+
+func TestSnapshotRotation(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	for i := 1; i <= 5; i++ {
+		saveGauge(t, filePath, 3, float64(i))
+	}
+
+	for _, name := range []string{filePath, filePath + ".1", filePath + ".2"} {
+		assert.FileExists(t, name)
+	}
+	assert.NoFileExists(t, filePath+".3")
+
+	value, err := restoredGauge(t, filePath)
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, value)
+
+	// при уменьшении keep лишние снимки удаляются
+	saveGauge(t, filePath, 1, 6)
+	assert.NoFileExists(t, filePath+".1")
+	assert.NoFileExists(t, filePath+".2")
+}
+
+func TestSyncSaveKeepsRotatedSnapshots(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewSyncMemStorage(filePath)
+
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "test", Value: 1}))
+	require.NoError(t, s.Save(filePath))
+	for i := 2; i <= 5; i++ {
+		require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "test", Value: float64(i)}))
+	}
+
+	// запись заменяет только текущий снимок, предыдущий остается от явного сохранения
+	assert.FileExists(t, filePath+".1")
+	assert.NoFileExists(t, filePath+".2")
+	value, err := restoredGauge(t, filePath)
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, value)
+
+	matches, err := filepath.Glob(filePath + ".tmp-*")
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestSyncSaveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	s := NewSyncMemStorage(filePath)
+
+	const writers, updates = 20, 25
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+				assert.NoError(t, err)
+				assert.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: fmt.Sprintf("g%d", i), Value: float64(j)}))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// последний записанный снимок совпадает с данными в памяти
+	restored := NewMemStorage()
+	require.NoError(t, restored.Restore(filePath))
+	assert.Equal(t, s.s.gaugeMetrics, restored.gaugeMetrics)
+	assert.Equal(t, s.s.counterMetrics, restored.counterMetrics)
+	assert.Equal(t, int64(writers*updates), restored.counterMetrics["PollCount"])
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt map[string]string
+		want    float64
+		wantErr error
+	}{
+		{
+			name: "latest snapshot",
+			want: 3,
+		},
+		{
+			name:    "checksum mismatch falls back to previous snapshot",
+			corrupt: map[string]string{"": `{"version": 2, "checksum": "00", "data": {"gauge_metrics": {"test": 100}}}`},
+			want:    2,
+		},
+		{
+			name:    "torn write falls back to previous snapshot",
+			corrupt: map[string]string{"": `{"version": 2, "checksum": "`, ".1": `{"version": 2`},
+			want:    1,
+		},
+		{
+			name:    "unsupported version",
+			corrupt: map[string]string{"": `{"version": 100, "data": {}}`},
+			want:    2,
+		},
+		{
+			name:    "all snapshots are broken",
+			corrupt: map[string]string{"": "", ".1": "{", ".2": `{"version": 2, "checksum": "00", "data": {}}`},
+			wantErr: ErrNoValidSnapshot,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "metrics.json")
+			for i := 1; i <= 3; i++ {
+				saveGauge(t, filePath, 3, float64(i))
+			}
+			for suffix, data := range test.corrupt {
+				require.NoError(t, os.WriteFile(filePath+suffix, []byte(data), 0o644))
+			}
+
+			value, err := restoredGauge(t, filePath)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, value)
+		})
+	}
+}
+
+func TestSnapshotLegacyFormat(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(filePath, []byte(`{"gauge_metrics": {"test": 1.5}, "counter_metrics": {"count": 2}}`), 0o644))
+
+	s := NewMemStorage()
+	require.NoError(t, s.Restore(filePath))
+	assert.Equal(t, 1.5, s.gaugeMetrics["test"])
+	assert.Equal(t, int64(2), s.counterMetrics["count"])
+}
+
+func TestSnapshotMissing(t *testing.T) {
+	s := NewMemStorage()
+	require.NoError(t, s.Restore(filepath.Join(t.TempDir(), "metrics.json")))
+	assert.Empty(t, s.gaugeMetrics)
+}
+
+func testSaveData(n int) SaveData {
+	now := time.Now()
+	data := SaveData{
+		GaugeMetrics:   make(map[string]float64, n),
+		CounterMetrics: make(map[string]int64, n),
+		GaugeUpdated:   make(map[string]time.Time, n),
+		CounterUpdated: make(map[string]time.Time, n),
+		WALSeq:         uint64(n),
+	}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf(`Metric%d{host="host-%d"}`, i, i%10)
+		data.GaugeMetrics[name] = float64(i) * 1.5
+		data.CounterMetrics[name] = int64(i) - int64(n/2)
+		data.GaugeUpdated[name] = now.Add(-time.Duration(i) * time.Second)
+		if i%2 == 0 {
+			// у части метрик нет времени обновления, как в старых сохранениях
+			data.CounterUpdated[name] = now
+		}
+	}
+	return data
+}
+
+func TestBinarySaveData(t *testing.T) {
+	data := testSaveData(100)
+
+	byteData, err := data.MarshalBinary()
+	require.NoError(t, err)
+
+	var decoded SaveData
+	require.NoError(t, decoded.UnmarshalBinary(byteData))
+	assert.Equal(t, data.WALSeq, decoded.WALSeq)
+	assert.Equal(t, data.GaugeMetrics, decoded.GaugeMetrics)
+	assert.Equal(t, data.CounterMetrics, decoded.CounterMetrics)
+	require.Len(t, decoded.GaugeUpdated, len(data.GaugeUpdated))
+	require.Len(t, decoded.CounterUpdated, len(data.CounterUpdated))
+	for name, updated := range data.GaugeUpdated {
+		assert.True(t, updated.Equal(decoded.GaugeUpdated[name]))
+	}
+
+	for _, broken := range [][]byte{byteData[:len(byteData)-1], append(byteData, 0), {1, 200}} {
+		assert.ErrorIs(t, decoded.UnmarshalBinary(broken), ErrBrokenBinarySnapshot)
+	}
+}
+
+func TestConvertSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "metrics.json")
+	binaryPath := filepath.Join(dir, "metrics.bin")
+	backPath := filepath.Join(dir, "metrics-back.json")
+
+	s := NewMemStorage()
+	s.SetSnapshotOptions(SnapshotOptions{Keep: 1, Format: SnapshotJSON})
+	s.gaugeMetrics["test"] = 1.5
+	s.counterMetrics["count"] = -2
+	require.NoError(t, s.Save(jsonPath))
+
+	require.NoError(t, ConvertSnapshot(jsonPath, binaryPath, SnapshotBinary))
+	require.NoError(t, ConvertSnapshot(binaryPath, backPath, SnapshotJSON))
+
+	for _, filePath := range []string{binaryPath, backPath} {
+		restored := NewMemStorage()
+		require.NoError(t, restored.Restore(filePath))
+		assert.Equal(t, s.gaugeMetrics, restored.gaugeMetrics)
+		assert.Equal(t, s.counterMetrics, restored.counterMetrics)
+	}
+
+	byteData, err := os.ReadFile(binaryPath)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(byteData, binarySnapshotMagic))
+
+	// битый двоичный снимок не проходит проверку суммы
+	byteData[len(byteData)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(binaryPath, byteData, 0o644))
+	_, err = readSnapshot(binaryPath)
+	assert.ErrorIs(t, err, ErrSnapshotChecksum)
+
+	assert.ErrorIs(t, ConvertSnapshot(jsonPath, binaryPath, "xml"), ErrUnknownSnapshotFormat)
+}
+
+func BenchmarkSnapshotEncode(b *testing.B) {
+	data := testSaveData(10000)
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		b.Run(string(format), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				byteData, err := encodeSnapshot(data, format)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(len(byteData)))
+			}
+		})
+	}
+}
+
+func BenchmarkSnapshotDecode(b *testing.B) {
+	data := testSaveData(10000)
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		byteData, err := encodeSnapshot(data, format)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(string(format), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(byteData)))
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeSnapshot(byteData); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
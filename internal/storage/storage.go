@@ -4,6 +4,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/smakimka/mtrcscollector/internal/model"
 )
@@ -13,6 +14,14 @@ var (
 	_               Storage     = (*MemStorage)(nil)
 	_               Storage     = (*PGStorage)(nil)
 	_               SyncStorage = (*SyncMemStorage)(nil)
+	_               SyncStorage = (*WALMemStorage)(nil)
+	_               SyncStorage = (*ShardedMemStorage)(nil)
+
+	_ HealthChecker = (*MemStorage)(nil)
+	_ HealthChecker = (*PGStorage)(nil)
+	_ HealthChecker = (*SyncMemStorage)(nil)
+	_ HealthChecker = (*WALMemStorage)(nil)
+	_ HealthChecker = (*ShardedMemStorage)(nil)
 )
 
 type updater interface {
@@ -28,10 +37,30 @@ type getter interface {
 	GetAllCounterMetrics(ctx context.Context) ([]model.CounterMetric, error)
 }
 
+type deleter interface {
+	Delete(ctx context.Context, kind, name string) error
+	DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error)
+	DeleteStale(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Поиск метрик типа kind по имени, результат отсортирован по имени
+type searcher interface {
+	SearchByPrefix(ctx context.Context, kind, prefix string) (model.MetricsData, error)
+	// синтаксис шаблона см. MatchGlob
+	SearchByGlob(ctx context.Context, kind, pattern string) (model.MetricsData, error)
+}
+
 // Storage Основной интерфейс, который реализуют все хранилища.
 type Storage interface {
 	updater
 	getter
+	deleter
+	searcher
+}
+
+// HealthChecker Хранилище, которое умеет проверять, что готово обслуживать запросы.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
 }
 
 // SyncStorage Интерфейс для хранилищ, которым нужно переодически сохранять данные и потом восстанавливаться из сохранения.
@@ -39,4 +68,25 @@ type SyncStorage interface {
 	Storage
 	Restore(filePath string) error
 	Save(filePath string) error
+	SetSnapshotOptions(opts SnapshotOptions)
+}
+
+// Хранилище под всеми обертками (у оберток есть метод Unwrap)
+func Unwrap(s Storage) Storage {
+	for {
+		wrapper, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			return s
+		}
+		s = wrapper.Unwrap()
+	}
+}
+
+// Проверка готовности хранилища, хранилища без HealthChecker считаются готовыми всегда
+func CheckHealth(ctx context.Context, s Storage) error {
+	if checker, ok := Unwrap(s).(HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+
+	return nil
 }
@@ -2,11 +2,14 @@ package storage
 
 import (
 	"context"
+	"path/filepath"
+	"time"
 
 	"github.com/smakimka/mtrcscollector/internal/model"
 )
 
 // SyncMemStorage Реализация интерфейса storage для памяти с сохранением данных после каждой записи, использует MemStorage.
+// После записи заменяется только текущий снимок, предыдущие сдвигаются при явном Save (периодическом и при остановке).
 type SyncMemStorage struct {
 	s        *MemStorage
 	syncFile string
@@ -27,13 +30,29 @@ func (s *SyncMemStorage) Save(filePath string) error {
 	return s.s.Save(filePath)
 }
 
+func (s *SyncMemStorage) SetSnapshotOptions(opts SnapshotOptions) {
+	s.s.SetSnapshotOptions(opts)
+}
+
+// Готовность: в папку файла синхронизации можно писать, последнее сохранение прошло успешно
+func (s *SyncMemStorage) CheckHealth(ctx context.Context) error {
+	if err := s.s.CheckHealth(ctx); err != nil {
+		return err
+	}
+	if s.syncFile == "" {
+		return nil
+	}
+
+	return checkWritable(filepath.Dir(s.syncFile))
+}
+
 func (s *SyncMemStorage) UpdateCounterMetric(ctx context.Context, m model.CounterMetric) (int64, error) {
 	res, err := s.s.UpdateCounterMetric(ctx, m)
 	if err != nil {
 		return 0, err
 	}
 
-	if err = s.s.Save(s.syncFile); err != nil {
+	if err = s.s.saveCurrent(s.syncFile); err != nil {
 		return 0, err
 	}
 
@@ -46,7 +65,7 @@ func (s *SyncMemStorage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMet
 		return err
 	}
 
-	return s.s.Save(s.syncFile)
+	return s.s.saveCurrent(s.syncFile)
 }
 
 func (s *SyncMemStorage) GetGaugeMetric(ctx context.Context, name string) (model.GaugeMetric, error) {
@@ -57,6 +76,14 @@ func (s *SyncMemStorage) GetCounterMetric(ctx context.Context, name string) (mod
 	return s.s.GetCounterMetric(ctx, name)
 }
 
+func (s *SyncMemStorage) SearchByPrefix(ctx context.Context, kind, prefix string) (model.MetricsData, error) {
+	return s.s.SearchByPrefix(ctx, kind, prefix)
+}
+
+func (s *SyncMemStorage) SearchByGlob(ctx context.Context, kind, pattern string) (model.MetricsData, error) {
+	return s.s.SearchByGlob(ctx, kind, pattern)
+}
+
 func (s *SyncMemStorage) GetAllGaugeMetrics(ctx context.Context) ([]model.GaugeMetric, error) {
 	return s.s.GetAllGaugeMetrics(ctx)
 }
@@ -71,9 +98,36 @@ func (s *SyncMemStorage) UpdateMetrics(ctx context.Context, metricsData model.Me
 		return err
 	}
 
-	if err = s.s.Save(s.syncFile); err != nil {
+	if err = s.s.saveCurrent(s.syncFile); err != nil {
 		return err
 	}
 
 	return err
 }
+
+func (s *SyncMemStorage) Delete(ctx context.Context, kind, name string) error {
+	err := s.s.Delete(ctx, kind, name)
+	if err != nil {
+		return err
+	}
+
+	return s.s.saveCurrent(s.syncFile)
+}
+
+func (s *SyncMemStorage) DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error) {
+	deleted, err := s.s.DeleteByPrefix(ctx, kind, prefix)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	return deleted, s.s.saveCurrent(s.syncFile)
+}
+
+func (s *SyncMemStorage) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	deleted, err := s.s.DeleteStale(ctx, before)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+
+	return deleted, s.s.saveCurrent(s.syncFile)
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+// WALSync Когда записи журнала сбрасываются на диск.
+type WALSync string
+
+const (
+	// после каждой записи, ничего не теряется
+	WALSyncAlways WALSync = "always"
+	// раз в WALSyncPeriod, при падении машины теряется не больше периода
+	WALSyncInterval WALSync = "interval"
+	// когда решит ОС, переживает падение процесса, но не машины
+	WALSyncNone WALSync = "none"
+)
+
+const WALSyncPeriod = time.Second
+
+var ErrUnknownWALSync = errors.New("unknown wal sync policy")
+
+func ParseWALSync(policy string) (WALSync, error) {
+	switch WALSync(policy) {
+	case WALSyncAlways, WALSyncInterval, WALSyncNone:
+		return WALSync(policy), nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnknownWALSync, policy)
+	}
+}
+
+const (
+	walUpdate       = "update"
+	walDelete       = "delete"
+	walDeletePrefix = "delete_prefix"
+	walDeleteStale  = "delete_stale"
+)
+
+// walRecord Запись журнала: примененная пачка метрик или удаление.
+type walRecord struct {
+	Seq     uint64            `json:"seq"`
+	Op      string            `json:"op"`
+	Time    time.Time         `json:"time"`
+	Metrics model.MetricsData `json:"metrics,omitempty"`
+	Kind    string            `json:"kind,omitempty"`
+	Name    string            `json:"name,omitempty"`
+	Prefix  string            `json:"prefix,omitempty"`
+	Before  *time.Time        `json:"before,omitempty"`
+}
+
+// wal Журнал записей в файле, по записи в json на строку.
+type wal struct {
+	f      *os.File
+	policy WALSync
+	stop   chan struct{}
+	done   sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func openWAL(path string, policy WALSync) (*wal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wal{f: f, policy: policy, stop: make(chan struct{})}
+	if policy == WALSyncInterval {
+		w.done.Add(1)
+		go w.syncLoop()
+	}
+
+	return w, nil
+}
+
+func (w *wal) syncLoop() {
+	defer w.done.Done()
+
+	ticker := time.NewTicker(WALSyncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.f.Sync(); err != nil {
+				logger.Log.Err(err).Msg("error syncing wal")
+			}
+		}
+	}
+}
+
+// Дописать запись в конец журнала
+func (w *wal) append(rec walRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	if w.policy == WALSyncAlways {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+// Прочитать все записи с начала журнала. Недописанный при падении хвост отрезается
+func (w *wal) replay(apply func(rec walRecord)) error {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(w.f)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			return nil
+		}
+
+		var rec walRecord
+		if err == nil {
+			err = json.Unmarshal(line, &rec)
+		}
+		if err != nil {
+			logger.Log.Warn().Msg(fmt.Sprintf("wal is broken after %d bytes, dropping the rest: %v", offset, err))
+			return w.f.Truncate(offset)
+		}
+
+		apply(rec)
+		offset += int64(len(line))
+	}
+}
+
+// Оставить в журнале только записи новее after. Журнал переписывается на месте: при падении посередине
+// теряются только записи, которые уже есть в последнем снимке
+func (w *wal) compact(after uint64) error {
+	var kept []walRecord
+	err := w.replay(func(rec walRecord) {
+		if rec.Seq > after {
+			kept = append(kept, rec)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = w.f.Truncate(0); err != nil {
+		return err
+	}
+	for _, rec := range kept {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if _, err = w.f.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+	return w.f.Sync()
+}
+
+// Очистить журнал, все записи уже есть в снимке
+func (w *wal) reset() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+// Сбросить журнал на диск и закрыть, повторные вызовы ничего не делают
+func (w *wal) close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		w.done.Wait()
+
+		w.closeErr = errors.Join(w.f.Sync(), w.f.Close())
+	})
+	return w.closeErr
+}
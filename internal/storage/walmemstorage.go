@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+// WALMemStorage Реализация интерфейса storage для памяти с журналом: каждая запись сначала дописывается в журнал,
+// а Save сохраняет снимок и убирает из журнала записи, которые есть в предыдущем снимке. Restore восстанавливает
+// снимок и доигрывает журнал поверх него, так что и откат на предыдущий снимок при битом последнем ничего не теряет.
+type WALMemStorage struct {
+	s       *MemStorage
+	wal     *wal
+	walPath string
+
+	// порядок записей в журнале совпадает с порядком применения
+	mutex    sync.Mutex
+	seq      uint64
+	restored bool
+	// номер последней записи в последнем сохраненном или восстановленном снимке
+	snapshotSeq uint64
+}
+
+func NewWALMemStorage(walPath string, policy WALSync) (*WALMemStorage, error) {
+	w, err := openWAL(walPath, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WALMemStorage{
+		s:       NewMemStorage(),
+		wal:     w,
+		walPath: walPath,
+	}, nil
+}
+
+// Восстановить снимок и применить записи журнала, которых в нем еще нет
+func (s *WALMemStorage) Restore(filePath string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.s.Restore(filePath); err != nil {
+		return err
+	}
+
+	s.seq = s.s.walSeq
+	s.snapshotSeq = s.seq
+	gap := false
+	err := s.wal.replay(func(rec walRecord) {
+		if rec.Seq <= s.seq {
+			return
+		}
+		if rec.Seq != s.seq+1 && !gap {
+			// журнал не продолжает снимок, например после отката на снимок старше предыдущего
+			logger.Log.Error().Msg(fmt.Sprintf("wal does not continue the restored snapshot: snapshot ends at record %d, "+
+				"wal continues from record %d, updates in between are lost", s.seq, rec.Seq))
+			gap = true
+		}
+		// операции проверены до записи в журнал, ошибка при повторе означает только, что удалять нечего
+		s.s.apply(rec)
+		s.seq = rec.Seq
+	})
+	if err != nil {
+		return err
+	}
+
+	s.restored = true
+	return nil
+}
+
+// Сохранить снимок и убрать из журнала записи, которые уже есть в предыдущем снимке
+func (s *WALMemStorage) Save(filePath string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.s.Save(filePath); err != nil {
+		return err
+	}
+
+	// записи старше снимка при восстановлении пропускаются, так что падение до очистки журнала не страшно
+	after := s.snapshotSeq
+	if s.s.snapshotOptions().Keep <= 1 {
+		// предыдущих снимков не остается, откатываться некуда
+		after = s.seq
+	}
+	s.snapshotSeq = s.seq
+
+	return s.wal.compact(after)
+}
+
+func (s *WALMemStorage) SetSnapshotOptions(opts SnapshotOptions) {
+	s.s.SetSnapshotOptions(opts)
+}
+
+// Закрыть журнал
+func (s *WALMemStorage) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.wal.close()
+}
+
+// Готовность: последнее сохранение прошло успешно, в папки снимка и журнала можно писать
+func (s *WALMemStorage) CheckHealth(ctx context.Context) error {
+	if err := s.s.CheckHealth(ctx); err != nil {
+		return err
+	}
+
+	return checkWritable(filepath.Dir(s.walPath))
+}
+
+// Записать операцию в журнал и применить ее, вызывается под блокировкой.
+// Возвращает количество удаленных метрик для удалений
+func (s *WALMemStorage) log(rec walRecord) (int64, error) {
+	// без восстановления старый журнал к текущим данным не относится
+	if !s.restored {
+		if err := s.wal.reset(); err != nil {
+			return 0, err
+		}
+		s.restored = true
+	}
+
+	rec.Seq = s.seq + 1
+	rec.Time = time.Now()
+	if err := s.wal.append(rec); err != nil {
+		return 0, err
+	}
+
+	s.seq = rec.Seq
+	return s.s.apply(rec)
+}
+
+func (s *WALMemStorage) UpdateCounterMetric(ctx context.Context, m model.CounterMetric) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value := m.Value
+	_, err := s.log(walRecord{Op: walUpdate, Metrics: model.MetricsData{{Name: m.Name, Kind: model.Counter, Delta: &value}}})
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := s.s.GetCounterMetric(ctx, m.Name)
+	return res.Value, err
+}
+
+func (s *WALMemStorage) UpdateGaugeMetric(ctx context.Context, m model.GaugeMetric) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value := m.Value
+	_, err := s.log(walRecord{Op: walUpdate, Metrics: model.MetricsData{{Name: m.Name, Kind: model.Gauge, Value: &value}}})
+	return err
+}
+
+func (s *WALMemStorage) UpdateMetrics(ctx context.Context, metricsData model.MetricsData) error {
+	// запись, которую нельзя применить, сломала бы восстановление из журнала
+	for _, metricData := range metricsData {
+		if (metricData.Kind == model.Gauge && metricData.Value == nil) ||
+			(metricData.Kind == model.Counter && metricData.Delta == nil) {
+			return model.ErrMissingFields
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, err := s.log(walRecord{Op: walUpdate, Metrics: metricsData})
+	return err
+}
+
+func (s *WALMemStorage) GetGaugeMetric(ctx context.Context, name string) (model.GaugeMetric, error) {
+	return s.s.GetGaugeMetric(ctx, name)
+}
+
+func (s *WALMemStorage) GetCounterMetric(ctx context.Context, name string) (model.CounterMetric, error) {
+	return s.s.GetCounterMetric(ctx, name)
+}
+
+func (s *WALMemStorage) SearchByPrefix(ctx context.Context, kind, prefix string) (model.MetricsData, error) {
+	return s.s.SearchByPrefix(ctx, kind, prefix)
+}
+
+func (s *WALMemStorage) SearchByGlob(ctx context.Context, kind, pattern string) (model.MetricsData, error) {
+	return s.s.SearchByGlob(ctx, kind, pattern)
+}
+
+func (s *WALMemStorage) GetAllGaugeMetrics(ctx context.Context) ([]model.GaugeMetric, error) {
+	return s.s.GetAllGaugeMetrics(ctx)
+}
+
+func (s *WALMemStorage) GetAllCounterMetrics(ctx context.Context) ([]model.CounterMetric, error) {
+	return s.s.GetAllCounterMetrics(ctx)
+}
+
+func (s *WALMemStorage) Delete(ctx context.Context, kind, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// удаление, которое ничего не поменяет, в журнал не попадает
+	if kind != model.Gauge && kind != model.Counter {
+		return model.ErrWrongMetricKind
+	}
+	if !s.s.has(kind, name) {
+		return ErrNoSuchMetric
+	}
+
+	_, err := s.log(walRecord{Op: walDelete, Kind: kind, Name: name})
+	return err
+}
+
+func (s *WALMemStorage) DeleteByPrefix(ctx context.Context, kind, prefix string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if kind != model.Gauge && kind != model.Counter {
+		return 0, model.ErrWrongMetricKind
+	}
+
+	return s.log(walRecord{Op: walDeletePrefix, Kind: kind, Prefix: prefix})
+}
+
+func (s *WALMemStorage) DeleteStale(ctx context.Context, before time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.log(walRecord{Op: walDeleteStale, Before: &before})
+}
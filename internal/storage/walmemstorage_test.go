@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/smakimka/mtrcscollector/internal/logger"
+	"github.com/smakimka/mtrcscollector/internal/model"
+)
+
+// Открыть хранилище заново, как после перезапуска сервера
+func reopenWAL(t *testing.T, walPath, snapshotPath string) *WALMemStorage {
+	s, err := NewWALMemStorage(walPath, WALSyncAlways)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	require.NoError(t, s.Restore(snapshotPath))
+	return s
+}
+
+func walState(t *testing.T, s Storage) (map[string]float64, map[string]int64) {
+	ctx := context.Background()
+
+	gauges, err := s.GetAllGaugeMetrics(ctx)
+	require.NoError(t, err)
+	counters, err := s.GetAllCounterMetrics(ctx)
+	require.NoError(t, err)
+
+	gaugeValues := map[string]float64{}
+	for _, m := range gauges {
+		gaugeValues[m.Name] = m.Value
+	}
+	counterValues := map[string]int64{}
+	for _, m := range counters {
+		counterValues[m.Name] = m.Value
+	}
+	return gaugeValues, counterValues
+}
+
+func TestWALReplay(t *testing.T) {
+	dir := t.TempDir()
+	walPath, snapshotPath := filepath.Join(dir, "metrics.wal"), filepath.Join(dir, "metrics.json")
+	ctx := context.Background()
+
+	s := reopenWAL(t, walPath, snapshotPath)
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1.5}))
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 2})
+	require.NoError(t, err)
+
+	value, delta := 3.0, int64(3)
+	require.NoError(t, s.UpdateMetrics(ctx, model.MetricsData{
+		{Name: "Free", Kind: model.Gauge, Value: &value},
+		{Name: "PollCount", Kind: model.Counter, Delta: &delta},
+		{Name: "tmp.a", Kind: model.Counter, Delta: &delta},
+		{Name: "tmp.b", Kind: model.Counter, Delta: &delta},
+	}))
+	require.NoError(t, s.Delete(ctx, model.Gauge, "Free"))
+	assert.ErrorIs(t, s.Delete(ctx, model.Gauge, "Free"), ErrNoSuchMetric)
+
+	deleted, err := s.DeleteByPrefix(ctx, model.Counter, "tmp.")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	assert.ErrorIs(t, s.UpdateMetrics(ctx, model.MetricsData{{Name: "broken", Kind: model.Gauge}}), model.ErrMissingFields)
+
+	// снимка нет, все восстанавливается только из журнала
+	restored := reopenWAL(t, walPath, snapshotPath)
+	gauges, counters := walState(t, restored)
+	assert.Equal(t, map[string]float64{"Alloc": 1.5}, gauges)
+	assert.Equal(t, map[string]int64{"PollCount": 5}, counters)
+}
+
+func TestWALCompaction(t *testing.T) {
+	dir := t.TempDir()
+	walPath, snapshotPath := filepath.Join(dir, "metrics.wal"), filepath.Join(dir, "metrics.json")
+	ctx := context.Background()
+	walRecords := func() int {
+		data, err := os.ReadFile(walPath)
+		require.NoError(t, err)
+		return bytes.Count(data, []byte("\n"))
+	}
+
+	s := reopenWAL(t, walPath, snapshotPath)
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 1})
+	require.NoError(t, err)
+
+	// предыдущего снимка нет, записи остаются на случай отката
+	require.NoError(t, s.Save(snapshotPath))
+	assert.Equal(t, 1, walRecords())
+
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 10})
+	require.NoError(t, err)
+
+	// записи из предыдущего снимка убираются, записи после него остаются
+	require.NoError(t, s.Save(snapshotPath))
+	assert.Equal(t, 1, walRecords())
+
+	// падение между сохранением снимка и очисткой журнала: записи из снимка не применяются второй раз
+	require.NoError(t, s.s.Save(snapshotPath))
+	_, err = s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 100})
+	require.NoError(t, err)
+
+	restored := reopenWAL(t, walPath, snapshotPath)
+	_, counters := walState(t, restored)
+	assert.Equal(t, map[string]int64{"PollCount": 111}, counters)
+
+	// без предыдущих снимков журнал очищается полностью
+	restored.SetSnapshotOptions(SnapshotOptions{Keep: 1, Format: SnapshotJSON})
+	require.NoError(t, restored.Save(snapshotPath))
+	assert.Zero(t, walRecords())
+}
+
+func TestWALSnapshotFallback(t *testing.T) {
+	dir := t.TempDir()
+	walPath, snapshotPath := filepath.Join(dir, "metrics.wal"), filepath.Join(dir, "metrics.json")
+	ctx := context.Background()
+
+	s := reopenWAL(t, walPath, snapshotPath)
+	for i := 1; i <= 3; i++ {
+		_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: int64(i)})
+		require.NoError(t, err)
+		require.NoError(t, s.Save(snapshotPath))
+	}
+	_, err := s.UpdateCounterMetric(ctx, model.CounterMetric{Name: "PollCount", Value: 4})
+	require.NoError(t, err)
+
+	// последний снимок битый, предыдущий доигрывается журналом без потерь
+	require.NoError(t, os.WriteFile(snapshotPath, []byte(`{"version": 2, "checksum": "`), 0o644))
+	restored := reopenWAL(t, walPath, snapshotPath)
+	_, counters := walState(t, restored)
+	assert.Equal(t, map[string]int64{"PollCount": 10}, counters)
+
+	// откат еще дальше: журнал уже не продолжает снимок, об этом пишется ошибка
+	require.NoError(t, os.WriteFile(snapshotPath+".1", []byte(`{"version": 2, "checksum": "`), 0o644))
+	var logs bytes.Buffer
+	defer func(l zerolog.Logger) { logger.Log = l }(logger.Log)
+	logger.Log = zerolog.New(&logs)
+
+	restored = reopenWAL(t, walPath, snapshotPath)
+	_, counters = walState(t, restored)
+	assert.Equal(t, map[string]int64{"PollCount": 8}, counters)
+	assert.Contains(t, logs.String(), "wal does not continue the restored snapshot")
+}
+
+func TestWALBrokenTail(t *testing.T) {
+	dir := t.TempDir()
+	walPath, snapshotPath := filepath.Join(dir, "metrics.wal"), filepath.Join(dir, "metrics.json")
+	ctx := context.Background()
+
+	s := reopenWAL(t, walPath, snapshotPath)
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Alloc", Value: 1}))
+
+	// запись, недописанная при падении
+	f, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq": 2, "op": "upd`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restored := reopenWAL(t, walPath, snapshotPath)
+	require.NoError(t, restored.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Free", Value: 2}))
+
+	restored = reopenWAL(t, walPath, snapshotPath)
+	gauges, _ := walState(t, restored)
+	assert.Equal(t, map[string]float64{"Alloc": 1, "Free": 2}, gauges)
+}
+
+func TestWALWithoutRestore(t *testing.T) {
+	dir := t.TempDir()
+	walPath, snapshotPath := filepath.Join(dir, "metrics.wal"), filepath.Join(dir, "metrics.json")
+	ctx := context.Background()
+
+	s := reopenWAL(t, walPath, snapshotPath)
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Old", Value: 1}))
+	require.NoError(t, s.Close())
+
+	// запуск без восстановления начинает журнал заново
+	fresh, err := NewWALMemStorage(walPath, WALSyncInterval)
+	require.NoError(t, err)
+	require.NoError(t, fresh.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "New", Value: 2}))
+	require.NoError(t, fresh.Close())
+
+	restored := reopenWAL(t, walPath, snapshotPath)
+	gauges, _ := walState(t, restored)
+	assert.Equal(t, map[string]float64{"New": 2}, gauges)
+}
+
+func TestWALDeleteStale(t *testing.T) {
+	dir := t.TempDir()
+	walPath, snapshotPath := filepath.Join(dir, "metrics.wal"), filepath.Join(dir, "metrics.json")
+	ctx := context.Background()
+
+	s := reopenWAL(t, walPath, snapshotPath)
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "Old", Value: 1}))
+	cutoff := time.Now()
+	require.NoError(t, s.UpdateGaugeMetric(ctx, model.GaugeMetric{Name: "New", Value: 2}))
+
+	deleted, err := s.DeleteStale(ctx, cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	restored := reopenWAL(t, walPath, snapshotPath)
+	gauges, _ := walState(t, restored)
+	assert.Equal(t, map[string]float64{"New": 2}, gauges)
+}
@@ -0,0 +1,51 @@
+package subnet
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Trusted Доверенная подсеть, которую можно заменить на лету. Пустая подсеть доверяет всем адресам.
+type Trusted struct {
+	subnet atomic.Pointer[net.IPNet]
+}
+
+func NewTrusted(subnet *net.IPNet) *Trusted {
+	t := &Trusted{}
+	t.Set(subnet)
+	return t
+}
+
+// Заменить доверенную подсеть, nil отключает проверку
+func (t *Trusted) Set(subnet *net.IPNet) {
+	t.subnet.Store(subnet)
+}
+
+// Текущая доверенная подсеть или nil, если проверка отключена
+func (t *Trusted) Get() *net.IPNet {
+	if t == nil {
+		return nil
+	}
+	return t.subnet.Load()
+}
+
+// Входит ли адрес в доверенную подсеть, без подсети не входит никакой
+func (t *Trusted) Contains(ip string) bool {
+	subnet := t.Get()
+	if subnet == nil {
+		return false
+	}
+	return subnet.Contains(net.ParseIP(ip))
+}
+
+// Доверять ли адресу из заголовка X-Real-IP
+func (t *Trusted) Allowed(realIP string) bool {
+	subnet := t.Get()
+	if subnet == nil {
+		return true
+	}
+	if realIP == "" {
+		return false
+	}
+	return subnet.Contains(net.ParseIP(realIP))
+}
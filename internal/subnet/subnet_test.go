@@ -0,0 +1,32 @@
+package subnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrusted(t *testing.T) {
+	_, local, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	_, other, err := net.ParseCIDR("192.168.0.0/16")
+	require.NoError(t, err)
+
+	trusted := NewTrusted(nil)
+	assert.True(t, trusted.Allowed(""))
+	assert.True(t, trusted.Allowed("192.168.1.1"))
+
+	trusted.Set(local)
+	assert.False(t, trusted.Allowed(""))
+	assert.True(t, trusted.Allowed("10.1.2.3"))
+	assert.False(t, trusted.Allowed("192.168.1.1"))
+
+	trusted.Set(other)
+	assert.False(t, trusted.Allowed("10.1.2.3"))
+	assert.True(t, trusted.Allowed("192.168.1.1"))
+
+	var empty *Trusted
+	assert.True(t, empty.Allowed("10.1.2.3"))
+}
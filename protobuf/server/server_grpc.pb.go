@@ -20,6 +20,7 @@ const _ = grpc.SupportPackageIsVersion7
 
 const (
 	MetricsCollector_Update_FullMethodName = "/MetricsCollector/Update"
+	MetricsCollector_Agents_FullMethodName = "/MetricsCollector/Agents"
 )
 
 // MetricsCollectorClient is the client API for MetricsCollector service.
@@ -27,6 +28,7 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MetricsCollectorClient interface {
 	Update(ctx context.Context, in *UpdateMetrics, opts ...grpc.CallOption) (*Response, error)
+	Agents(ctx context.Context, in *AgentsRequest, opts ...grpc.CallOption) (*AgentsResponse, error)
 }
 
 type metricsCollectorClient struct {
@@ -46,11 +48,21 @@ func (c *metricsCollectorClient) Update(ctx context.Context, in *UpdateMetrics,
 	return out, nil
 }
 
+func (c *metricsCollectorClient) Agents(ctx context.Context, in *AgentsRequest, opts ...grpc.CallOption) (*AgentsResponse, error) {
+	out := new(AgentsResponse)
+	err := c.cc.Invoke(ctx, MetricsCollector_Agents_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsCollectorServer is the server API for MetricsCollector service.
 // All implementations must embed UnimplementedMetricsCollectorServer
 // for forward compatibility
 type MetricsCollectorServer interface {
 	Update(context.Context, *UpdateMetrics) (*Response, error)
+	Agents(context.Context, *AgentsRequest) (*AgentsResponse, error)
 	mustEmbedUnimplementedMetricsCollectorServer()
 }
 
@@ -61,6 +73,9 @@ type UnimplementedMetricsCollectorServer struct {
 func (UnimplementedMetricsCollectorServer) Update(context.Context, *UpdateMetrics) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
 }
+func (UnimplementedMetricsCollectorServer) Agents(context.Context, *AgentsRequest) (*AgentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Agents not implemented")
+}
 func (UnimplementedMetricsCollectorServer) mustEmbedUnimplementedMetricsCollectorServer() {}
 
 // UnsafeMetricsCollectorServer may be embedded to opt out of forward compatibility for this service.
@@ -92,6 +107,24 @@ func _MetricsCollector_Update_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricsCollector_Agents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AgentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServer).Agents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollector_Agents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServer).Agents(ctx, req.(*AgentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MetricsCollector_ServiceDesc is the grpc.ServiceDesc for MetricsCollector service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -103,6 +136,10 @@ var MetricsCollector_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Update",
 			Handler:    _MetricsCollector_Update_Handler,
 		},
+		{
+			MethodName: "Agents",
+			Handler:    _MetricsCollector_Agents_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "server.proto",
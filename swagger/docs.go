@@ -44,6 +44,107 @@ const docTemplate = `{
                 }
             }
         },
+        "/agents": {
+            "get": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Status"
+                ],
+                "summary": "Запрос агентов, присылающих метрики",
+                "operationId": "Agents",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/agents.Agent"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/alerts": {
+            "get": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Status"
+                ],
+                "summary": "Запрос активных алертов",
+                "operationId": "Alerts",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/alerts.Alert"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Status"
+                ],
+                "summary": "Проверка, что сервис запущен",
+                "operationId": "Healthz",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Response"
+                        }
+                    }
+                }
+            }
+        },
+        "/internal/metrics": {
+            "get": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Status"
+                ],
+                "summary": "Запрос метрик сервера о себе",
+                "operationId": "SelfMetrics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/model.MetricData"
+                            }
+                        }
+                    }
+                }
+            }
+        },
         "/ping": {
             "get": {
                 "consumes": [
@@ -73,6 +174,35 @@ const docTemplate = `{
                 }
             }
         },
+        "/readyz": {
+            "get": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Status"
+                ],
+                "summary": "Проверка, что хранилище готово обслуживать запросы",
+                "operationId": "Readyz",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.Response"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/model.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/update/": {
             "post": {
                 "consumes": [
@@ -104,6 +234,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/model.MetricData"
                         }
                     },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/model.Response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -162,6 +298,12 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "429": {
+                        "description": "превышено ограничение на количество метрик",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "ошибка",
                         "schema": {
@@ -205,12 +347,24 @@ const docTemplate = `{
                             "$ref": "#/definitions/model.Response"
                         }
                     },
+                    "207": {
+                        "description": "метрики сверх ограничений не записаны, остальные записаны",
+                        "schema": {
+                            "$ref": "#/definitions/model.Response"
+                        }
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/model.Response"
                         }
                     },
+                    "429": {
+                        "description": "ни одна метрика не записана из-за ограничений",
+                        "schema": {
+                            "$ref": "#/definitions/model.Response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -266,6 +420,69 @@ const docTemplate = `{
                 }
             }
         },
+        "/value/{metricKind}/": {
+            "delete": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Update"
+                ],
+                "summary": "Запрос для удаления всех метрик типа с именами, начинающимися с префикса",
+                "operationId": "DeleteMetrics",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Тип метрики",
+                        "name": "metricKind",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Префикс имени метрики",
+                        "name": "prefix",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "HMAC-SHA256 пути запроса с параметрами, обязателен вне доверенной подсети при заданном ключе",
+                        "name": "HashSHA256",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.DeleteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/model.Response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/model.Response"
+                        }
+                    }
+                }
+            }
+        },
         "/value/{metricKind}/{metricName}": {
             "get": {
                 "consumes": [
@@ -315,10 +532,160 @@ const docTemplate = `{
                         }
                     }
                 }
+            },
+            "delete": {
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "Update"
+                ],
+                "summary": "Запрос для удаления метрики",
+                "operationId": "DeleteMetric",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Тип метрики",
+                        "name": "metricKind",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "имя метрики",
+                        "name": "metricName",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "HMAC-SHA256 пути запроса, обязателен вне доверенной подсети при заданном ключе",
+                        "name": "HashSHA256",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "ошибка",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
             }
         }
     },
     "definitions": {
+        "agents.Agent": {
+            "type": "object",
+            "properties": {
+                "ip": {
+                    "type": "string"
+                },
+                "last_seen": {
+                    "type": "string"
+                },
+                "metrics_count": {
+                    "type": "integer"
+                },
+                "report_interval": {
+                    "type": "integer"
+                },
+                "reports": {
+                    "type": "integer"
+                },
+                "stale": {
+                    "type": "boolean"
+                },
+                "transport": {
+                    "$ref": "#/definitions/agents.Transport"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "agents.Transport": {
+            "type": "string",
+            "enum": [
+                "http",
+                "grpc"
+            ],
+            "x-enum-varnames": [
+                "HTTP",
+                "GRPC"
+            ]
+        },
+        "alerts.Alert": {
+            "type": "object",
+            "properties": {
+                "active_since": {
+                    "type": "string"
+                },
+                "expr": {
+                    "type": "string"
+                },
+                "fired_at": {
+                    "type": "string"
+                },
+                "resolved_at": {
+                    "type": "string"
+                },
+                "rule": {
+                    "type": "string"
+                },
+                "state": {
+                    "$ref": "#/definitions/alerts.State"
+                },
+                "value": {
+                    "type": "number"
+                }
+            }
+        },
+        "alerts.State": {
+            "type": "string",
+            "enum": [
+                "inactive",
+                "pending",
+                "firing",
+                "resolved"
+            ],
+            "x-enum-varnames": [
+                "Inactive",
+                "Pending",
+                "Firing",
+                "Resolved"
+            ]
+        },
+        "model.DeleteResponse": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "type": "integer"
+                }
+            }
+        },
         "model.MetricData": {
             "type": "object",
             "properties": {
@@ -348,6 +715,15 @@ const docTemplate = `{
                 },
                 "ok": {
                     "type": "boolean"
+                },
+                "rejected": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "written": {
+                    "type": "integer"
                 }
             }
         }